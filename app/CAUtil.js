@@ -196,4 +196,55 @@ exports.registerAndEnrollRetailer = async (caClient, wallet, orgMspId, userId, a
 	} catch (error) {
 		console.error(`Failed to register user : ${error}`);
 	}
-};
\ No newline at end of file
+};
+
+// The channel admin is a client of Org1MSP with admin=true, which the policy of the chaincode needs to
+// set the policy and run the setup transactions, and issuer=true, the attribute of the issuing role
+exports.registerAndEnrollChannelAdmin = async (caClient, wallet, orgMspId, userId, affiliation) => {
+	try {
+		// Check to see if we've already enrolled the user
+		const userIdentity = await wallet.get(userId);
+		if (userIdentity) {
+			console.log(`An identity for the user ${userId} already exists in the wallet`);
+			return;
+		}
+
+		// Must use an admin to register a new user
+		const adminIdentity = await wallet.get(adminUserId);
+		if (!adminIdentity) {
+			console.log('An identity for the admin user does not exist in the wallet');
+			console.log('Enroll the admin user before retrying');
+			return;
+		}
+
+		// build a user object for authenticating with the CA
+		const provider = wallet.getProviderRegistry().getProvider(adminIdentity.type);
+		const adminUser = await provider.getUserContext(adminIdentity, adminUserId);
+
+		// Register the user, enroll the user, and import the new identity into the wallet.
+		// if affiliation is specified by client, the affiliation value must be configured in CA
+		const secret = await caClient.register({
+			affiliation: affiliation,
+			enrollmentID: userId,
+			role: 'client',
+			attrs: [{ name: 'admin', value: 'true', ecert: true }, { name: 'issuer', value: 'true', ecert: true }],
+		}, adminUser);
+		const enrollment = await caClient.enroll({
+			enrollmentID: userId,
+			enrollmentSecret: secret,
+			attr_reqs: [{ name: 'admin', optional: false }, { name: 'issuer', optional: false }]}
+		);
+		const x509Identity = {
+			credentials: {
+				certificate: enrollment.certificate,
+				privateKey: enrollment.key.toBytes(),
+			},
+			mspId: orgMspId,
+			type: 'X.509',
+		};
+		await wallet.put(userId, x509Identity);
+		console.log(`Successfully registered and enrolled user ${userId} and imported it into the wallet`);
+	} catch (error) {
+		console.error(`Failed to register user : ${error}`);
+	}
+};
@@ -0,0 +1,70 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+'use strict';
+
+const { Gateway, Wallets } = require('fabric-network');
+const FabricCAServices = require('fabric-ca-client');
+const fs = require('fs');
+const path = require('path');
+const { buildCAClient, enrollAdmin, registerAndEnrollChannelAdmin } = require('./CAUtil');
+const { buildCCPOrg1, buildWallet } = require('./AppUtil');
+
+const mspOrg1 = 'Org1MSP';
+const channelAdminUserId = 'ChannelAdmin';
+
+// issuerRole lets the clients of Org1MSP with the attribute issuer=true mint settlement tokens. The
+// default policy of the chaincode has no such role, so the channel admin adds it.
+const issuerRole = { name: 'issuer', mspID: mspOrg1, attribute: 'issuer', functions: ['market:Mint'] };
+
+// connectChannelAdmin registers the channel admin with the CA of Org1 and connects it to the Org1 peer
+exports.connectChannelAdmin = async () => {
+	console.log('--> Fabric client user & Gateway init: Using the channel admin identity to Org1 Peer');
+	const ccpOrg1 = buildCCPOrg1();
+	const caOrg1Client = buildCAClient(FabricCAServices, ccpOrg1, 'ca.org1.example.com');
+	const walletOrg1 = await buildWallet(Wallets, path.join(__dirname, 'wallet/org1'));
+
+	await enrollAdmin(caOrg1Client, walletOrg1, mspOrg1);
+	await registerAndEnrollChannelAdmin(caOrg1Client, walletOrg1, mspOrg1, channelAdminUserId, 'org1.department1');
+
+	const gateway = new Gateway();
+	await gateway.connect(ccpOrg1,
+		{ wallet: walletOrg1, identity: channelAdminUserId, discovery: { enabled: true, asLocalhost: true } });
+	return gateway;
+};
+
+// registerSharedCollections registers the collections of collections_config.json, which buy requests
+// between two orgs are kept in. Registering them again on every run overwrites the same entries.
+exports.registerSharedCollections = async (adminContract) => {
+	const collectionsConfig = fs.readFileSync(path.resolve(__dirname, '..', 'collections_config.json'), 'utf8');
+	console.log('--> Submit Transaction: RegisterSharedCollections, from collections_config.json');
+	await adminContract.submitTransaction('market:RegisterSharedCollections', JSON.stringify(JSON.parse(collectionsConfig)));
+};
+
+// initProductCatalog adds the default products, which CreateAsset checks the type of an asset against.
+// Products that are already in the catalog are left as they are.
+exports.initProductCatalog = async (adminContract) => {
+	console.log('--> Submit Transaction: InitProductCatalog');
+	await adminContract.submitTransaction('asset:InitProductCatalog');
+};
+
+// designateIssuer adds the issuer role to the policy on the ledger, unless an earlier run did
+exports.designateIssuer = async (adminContract) => {
+	const policy = JSON.parse((await adminContract.evaluateTransaction('query:GetPolicy')).toString());
+	if (policy.roles.some((role) => role.name === issuerRole.name)) {
+		return;
+	}
+	policy.roles.push(issuerRole);
+	console.log('--> Submit Transaction: SetPolicy, with a role that may call market:Mint');
+	await adminContract.submitTransaction('asset:SetPolicy', JSON.stringify(policy));
+};
+
+// fundPurchase mints the price of a purchase to the buyer and has the buyer approve the seller for it,
+// which the transfer of the asset checks before it moves the tokens
+exports.fundPurchase = async (issuerContract, buyerContract, buyer, seller, price) => {
+	console.log(`--> Submit Transaction: Mint, ${price.amount} ${price.currency} to ${buyer.id} of ${buyer.mspID}`);
+	await issuerContract.submitTransaction('market:Mint', buyer.id, buyer.mspID, `${price.amount}`, price.currency);
+	console.log(`--> Submit Transaction: Approve, ${seller.id} of ${seller.mspID} for ${price.amount} ${price.currency}`);
+	await buyerContract.submitTransaction('market:Approve', seller.id, seller.mspID, `${price.amount}`, price.currency);
+};
@@ -9,9 +9,11 @@
 const { Gateway, Wallets } = require('fabric-network');
 const FabricCAServices = require('fabric-ca-client');
 const path = require('path');
+const crypto = require('crypto');
 const { buildCAClient, registerAndEnrollUser, enrollAdmin, registerAndEnrollFarmer, registerAndEnrollRetailer } = require('./CAUtil');
 //../../test-application/javascript/CAUtil.js
 const { buildCCPOrg1, buildWallet, buildCCPOrg2 } = require('./AppUtil');//  ../../test-application/javascript/AppUtil.js
+const { connectChannelAdmin, registerSharedCollections, initProductCatalog, designateIssuer, fundPurchase } = require('./SetupUtil');
 
 const channelName = 'mychannel';
 const chaincodeName = 'try';
@@ -124,7 +126,7 @@ async function initContractFromOrg2Identity() {
 async function readBidPrice(assetKey, org, contract) {
 	console.log(`${GREEN}--> Evaluate Transaction: GetAssetBidPrice, - ${assetKey} from organization ${org}${RESET}`);
 	try {
-		const resultBuffer = await contract.evaluateTransaction('GetAssetBidPrice', assetKey);
+		const resultBuffer = await contract.evaluateTransaction('query:GetAssetBidPrice', assetKey);
 		const asset = JSON.parse(resultBuffer.toString('utf8'));
 		console.log(`*** Result: GetAssetBidPrice, ${JSON.stringify(asset)}`);
 
@@ -136,7 +138,7 @@ async function readBidPrice(assetKey, org, contract) {
 async function readSalePrice(assetKey, org, contract) {
 	console.log(`${GREEN}--> Evaluate Transaction: GetAssetSalesPrice, - ${assetKey} from organization ${org}${RESET}`);
 	try {
-		const resultBuffer = await contract.evaluateTransaction('GetAssetSalesPrice', assetKey);
+		const resultBuffer = await contract.evaluateTransaction('query:GetAssetSalesPrice', assetKey);
 		const asset = JSON.parse(resultBuffer.toString('utf8'));
 		console.log(`*** Result: GetAssetSalesPrice, ${JSON.stringify(asset)}`);
 
@@ -150,9 +152,9 @@ async function readSalePrice(assetKey, org, contract) {
 async function readAssetByBothOrgs(assetKey, ownerOrg, contractOrg1, contractOrg2) {
 	console.log(`${GREEN}--> Evaluate Transactions: ReadAsset, - ${assetKey} should be owned by ${ownerOrg}${RESET}`);
 	let resultBuffer;
-	resultBuffer = await contractOrg1.evaluateTransaction('ReadAsset', assetKey);
+	resultBuffer = await contractOrg1.evaluateTransaction('query:ReadAsset', assetKey);
 	//checkAsset('Org1', resultBuffer, ownerOrg);
-	resultBuffer = await contractOrg2.evaluateTransaction('ReadAsset', assetKey);
+	resultBuffer = await contractOrg2.evaluateTransaction('query:ReadAsset', assetKey);
 	//checkAsset('Org2', resultBuffer, ownerOrg);
 }
 
@@ -172,10 +174,25 @@ async function main() {
         const contractOrg2 = networkOrg2.getContract(chaincodeName);
         contractOrg2.addDiscoveryInterest({ name: chaincodeName, collectionNames: [memberAssetCollectionName, org2PrivateCollectionName] });
 
+        /** ~~~~~~~ Channel setup: Using the channel admin identity to Org1 Peer ~~~~~~~ */
+        const gatewayAdmin = await connectChannelAdmin();
+        const networkAdmin = await gatewayAdmin.getNetwork(channelName);
+        const contractAdmin = networkAdmin.getContract(chaincodeName);
+        // the buy requests between two orgs go to the collection registered for them
+        await registerSharedCollections(contractAdmin);
+        // an asset can only be created with a type of the catalog
+        await initProductCatalog(contractAdmin);
+        // nobody can mint settlement tokens until the admin adds an issuing role to the policy
+        await designateIssuer(contractAdmin);
+
 		try {
 
 
 			let randomNumber = Math.floor(Math.random() * 1000) + 1;
+			// the salt of the price terms is shared by the seller and the buyer off chain
+			const tradeSalt = crypto.randomBytes(16).toString('hex');
+			// the terms are 110 EUR a kg for the 10 kg of the asset, which the buyer pays on the transfer
+			const tradePrice = { amount: 110 * 10, currency: 'EUR' };
             // use a random key so that we can run multiple times
             let privateAssetID = `asset${randomNumber}`;
             let transaction;
@@ -183,37 +200,38 @@ async function main() {
 
 			console.log("******************Public Details of Asset Created ***********************")
             console.log('Adding Assets to work with:\n--> Submit Transaction: CreatePrivateAsset ' + privateAssetID);
-            let statefulTxn = contractOrg1.createTransaction('CreateAsset');
-            let result = await statefulTxn.submit(privateAssetID,'green',10);
+            let statefulTxn = contractOrg1.createTransaction('asset:CreateAsset');
+            let result = await statefulTxn.submit(privateAssetID,'green',10,'apples');
 			console.log(" Asset Was created. Public details should be present !");
 
 			await readAssetByBothOrgs(privateAssetID, mspOrg1, contractOrg1, contractOrg2);
 
 
 			console.log('\n--> This is going to return the details of ',privateAssetID);
-			result = await contractOrg1.evaluateTransaction('ReadAsset', privateAssetID);
+			result = await contractOrg1.evaluateTransaction('query:ReadAsset', privateAssetID);
 			console.log(`*** Result: ${prettyJSONString(result.toString())}`);
 
 			
 
 			console.log('\n--> Evaluate Transaction: AssetExists, function eturns "true" if an asset with given assetID exist');
-			result = await contractOrg1.evaluateTransaction('AssetExists', privateAssetID);
+			result = await contractOrg1.evaluateTransaction('query:AssetExists', privateAssetID);
 			console.log(`*** Result: ${prettyJSONString(result.toString())}`);
 			console.log("Now we are going to read all assets including ",privateAssetID)
-			result = await contractOrg1.evaluateTransaction('GetAllAssets');
+			result = await contractOrg1.evaluateTransaction('query:GetAllAssets');
 			console.log(`*** Result: ${prettyJSONString(result.toString())}`);
 
 
 			try {
 				// Agree to a sell by Org1
 				const asset_price = {
-					asset_id: privateAssetID,
 					price: 110,
-					trade_id: randomNumber.toString()
+					currency: 'EUR',
+					quantity: { value: 10, unit: 'kg' },
+					salt: tradeSalt
 				};
 				const asset_price_string =  Buffer.from(JSON.stringify(asset_price));
 				console.log(`${GREEN}--> Submit Transaction: setPrice, ${privateAssetID} as Org1 - endorsed by Org1${RESET}`);
-				transaction = contractOrg1.createTransaction('SetPrice');
+				transaction = contractOrg1.createTransaction('market:SetPrice');
 				//transaction.setEndorsingOrganizations(mspOrg1);
 				transaction.setTransient({
 					asset_price:asset_price_string
@@ -225,32 +243,33 @@ async function main() {
 			}
 			
             console.log('\n~~~~~~~~~~~~~~~~ As Org2 Client ~~~~~~~~~~~~~~~~');
-            let buyerDetails = { assetID: privateAssetID, buyerMSP: mspOrg2 };
+            let buyerDetails = { assetID: privateAssetID, buyerMSP: mspOrg2, buyerID: org2UserId };
             console.log('\n~~~~~~~~~~~~~~~ We need to request to buy asset ~~~~~~~~~~~~~~~~');
             //make request to buy it,might have to do this before org1 sets price
-            transaction = contractOrg2.createTransaction('RequestToBuy');
+            transaction = contractOrg2.createTransaction('market:RequestToBuy');
             transaction.submit(privateAssetID);
 
 			
             console.log('\n--> Evaluate Transaction: ReadAsset ' + privateAssetID);
-            result = await contractOrg2.evaluateTransaction('ReadAsset', privateAssetID);
+            result = await contractOrg2.evaluateTransaction('query:ReadAsset', privateAssetID);
             console.log(`<-- result: ${prettyJSONString(result.toString())}`);
             
             console.log("==============REQUEST TO BUY==================")
-            result = await contractOrg1.evaluateTransaction('ReadRequestToBuy', privateAssetID);//
+            result = await contractOrg1.evaluateTransaction('query:ListBuyRequests', privateAssetID);
             console.log(`<-- result: ${result.toString()}`);
 			console.log("Here we are going to AgreeToBuy")
 
 			try {
 				// Agree to a buy by Org2
 				const asset_price = {
-					asset_id: privateAssetID,
 					price: 110,
-					trade_id: randomNumber.toString()
+					currency: 'EUR',
+					quantity: { value: 10, unit: 'kg' },
+					salt: tradeSalt
 				};
 				const asset_price_string = JSON.stringify(asset_price);
 				console.log(`${GREEN}--> Submit Transaction: AgreeToBuy, ${privateAssetID} as Org2 - endorsed by Org2${RESET}`);
-				transaction = contractOrg2.createTransaction('AgreeToBuy');
+				transaction = contractOrg2.createTransaction('market:AgreeToBuy');
 				//transaction.setEndorsingOrganizations(mspOrg2);
 				transaction.setTransient({
 					asset_price: Buffer.from(asset_price_string)
@@ -262,19 +281,22 @@ async function main() {
 			}
 
             console.log('\n**************** As Org1 Client ****************');
-            // All members can send txn ReadRequestToBuy, set by Org2 above
-            console.log('\n--> Evaluate Transaction: ReadRequestToBuy ' + privateAssetID);
+            // The seller lists the buy requests for its asset
+            console.log('\n--> Evaluate Transaction: ListBuyRequests ' + privateAssetID);
             
 
 
-            result = await contractOrg1.evaluateTransaction('ReadRequestToBuy', privateAssetID);//should change how AgreeToTransfer is implemented
+            result = await contractOrg1.evaluateTransaction('query:ListBuyRequests', privateAssetID);
             console.log(`<-- result: ${prettyJSONString(result.toString())}`);
 
+            // The buyer needs the tokens and has to approve the seller for them before the transfer
+            await fundPurchase(contractAdmin, contractOrg2, { id: org2UserId, mspID: mspOrg2 }, { id: org1UserId, mspID: mspOrg1 }, tradePrice);
+
             // Transfer the asset to Org2 //
             // To transfer the asset, the owner needs to pass the MSP ID of new asset owner, and initiate the transfer
             console.log('\n--> Submit Transaction: TransferRequestedAsset ' + privateAssetID);
 
-            statefulTxn = contractOrg1.createTransaction('TransferRequestedAsset');
+            statefulTxn = contractOrg1.createTransaction('market:TransferRequestedAsset');
             let tmapData = Buffer.from(JSON.stringify(buyerDetails));
            // transaction.setEndorsingOrganizations(mspOrg1,mspOrg2);
             statefulTxn.setTransient({
@@ -285,7 +307,7 @@ async function main() {
 
 
 			console.log('\n--> We are going to read privateAssetAfter after transfer to org2');
-			result = await contractOrg1.evaluateTransaction('ReadAsset', privateAssetID);
+			result = await contractOrg1.evaluateTransaction('query:ReadAsset', privateAssetID);
 			console.log(`*** Result: ${prettyJSONString(result.toString())}`);
 
 			console.log('\n--> We are going to read Bid Price from buyers private collection');
@@ -295,7 +317,7 @@ async function main() {
 
 
             // ReadAssetPrivateDetails reads data from Org's private collection: Should return empty
-            // result = await contractOrg1.evaluateTransaction('ReadAssetPrivateDetails', org1PrivateCollectionName, privateAssetID);
+            // result = await contractOrg1.evaluateTransaction('query:ReadAssetPrivateDetails', org1PrivateCollectionName, privateAssetID);
             // console.log(`<-- result: ${result.toString()}`);//had to remove prettyJSONString cause empty json cannot be parsed
             // if (result && result.length > 0) {
             //     doFail('Expected empty data from ReadAssetPrivateDetails');
@@ -305,7 +327,7 @@ async function main() {
 
 			
 			console.log('\n--> Evaluate Transaction: GetAssetHistory, get the history of ',privateAssetID);
-			result = await contractOrg1.evaluateTransaction('GetAssetHistory', privateAssetID);
+			result = await contractOrg1.evaluateTransaction('query:GetAssetHistory', privateAssetID);
 			console.log(`*** Result: ${prettyJSONString(result.toString())}`);
 
 
@@ -315,6 +337,7 @@ async function main() {
 			
 			
 			gatewayOrg2.disconnect();
+			gatewayAdmin.disconnect();
 		} finally {
 			// Disconnect from the gateway when the application is closing
 			// This will close all connections to the network
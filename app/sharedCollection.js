@@ -9,8 +9,10 @@
 const { Gateway, Wallets } = require('fabric-network');
 const FabricCAServices = require('fabric-ca-client');
 const path = require('path');
+const crypto = require('crypto');
 const { buildCAClient, registerAndEnrollUser, enrollAdmin,registerAndEnrollFarmer, registerAndEnrollRetailer } = require('./CAUtil')
 const { buildCCPOrg1, buildWallet, buildCCPOrg2,buildCCPOrg3 } = require('./AppUtil');
+const { connectChannelAdmin, registerSharedCollections, initProductCatalog, designateIssuer, fundPurchase } = require('./SetupUtil');
 const channelName = 'mychannel';
 const chaincodeName = 'try';
 const mspOrg1 = 'Org1MSP';
@@ -184,10 +186,25 @@ async function main() {
         const contractOrg3 = networkOrg3.getContract(chaincodeName);
         contractOrg3.addDiscoveryInterest({ name: chaincodeName, collectionNames: [assetCollection, sharedCollectionOrg2Org3] });
 
+        /** ~~~~~~~ Channel setup: Using the channel admin identity to Org1 Peer ~~~~~~~ */
+        const gatewayAdmin = await connectChannelAdmin();
+        const networkAdmin = await gatewayAdmin.getNetwork(channelName);
+        const contractAdmin = networkAdmin.getContract(chaincodeName);
+        // the buy requests between two orgs go to the collection registered for them
+        await registerSharedCollections(contractAdmin);
+        // an asset can only be created with a type of the catalog
+        await initProductCatalog(contractAdmin);
+        // nobody can mint settlement tokens until the admin adds an issuing role to the policy
+        await designateIssuer(contractAdmin);
+
 
 		try {
 
 			let randomNumber = Math.floor(Math.random() * 1000) + 1;
+			// the salt of the price terms is shared by the seller and the buyer off chain
+			const tradeSalt = crypto.randomBytes(16).toString('hex');
+			// the terms are 110 EUR a kg for the 10 kg of the asset, which the buyer pays on the transfer
+			const tradePrice = { amount: 110 * 10, currency: 'EUR' };
             // use a random key so that we can run multiple times
             // let assetID = `asset${randomNumber}`;
             let assetID = `asset${randomNumber}`;
@@ -198,16 +215,16 @@ async function main() {
 
 			console.log("******************Public Details of Asset Created ***********************\n")
             console.log('Adding Assets to work with:\n--> Submit Transaction: Create Asset ' + assetID);
-            statefulTxn = contractOrg1.createTransaction('CreateAsset');
+            statefulTxn = contractOrg1.createTransaction('asset:CreateAsset');
             statefulTxn.setEndorsingOrganizations(mspOrg1);
-            let result = await statefulTxn.submit(assetID,'green',10);
+            let result = await statefulTxn.submit(assetID,'green',10,'apples');
 			console.log(" Asset Was created. Public details should be present !");
             await sleep(3000);
 
 
 
 			// console.log('\n--> This is going to return the details of ',assetID);
-			result = await contractOrg1.evaluateTransaction('ReadAsset', assetID);//should delete let
+			result = await contractOrg1.evaluateTransaction('query:ReadAsset', assetID);//should delete let
 			console.log(`*** Result: ${prettyJSONString(result.toString())}`);
             await sleep(3000);
 
@@ -215,13 +232,14 @@ async function main() {
 			try {
 				// Agree to a sell by Org1
 				const asset_price = {
-					asset_id: assetID,
 					price: 110,
-					trade_id: randomNumber.toString()
+					currency: 'EUR',
+					quantity: { value: 10, unit: 'kg' },
+					salt: tradeSalt
 				};
 				const asset_price_string =  Buffer.from(JSON.stringify(asset_price));
 				console.log(`${GREEN}--> Submit Transaction: setPrice, ${assetID} as Org1 - endorsed by Org1${RESET}`);
-				transaction = contractOrg1.createTransaction('SetPrice');
+				transaction = contractOrg1.createTransaction('market:SetPrice');
                 transaction.setEndorsingOrganizations(mspOrg1);
 				transaction.setTransient({
 					asset_price:asset_price_string
@@ -236,23 +254,23 @@ async function main() {
 
 
             console.log('\n~~~~~~~~~~~~~~~~ As Org2 Client ~~~~~~~~~~~~~~~~');
-            let buyerDetails = { assetID: assetID, buyerMSP: mspOrg2 };
+            let buyerDetails = { assetID: assetID, buyerMSP: mspOrg2, buyerID: org2UserId };
             console.log('\n~~~~~~~~~~~~~~~ We need to request to buy asset ~~~~~~~~~~~~~~~~');
             //make request to buy it,might have to do this before org1 sets price
-            transaction = contractOrg2.createTransaction('RequestToBuy');
+            transaction = contractOrg2.createTransaction('market:RequestToBuy');
             transaction.setEndorsingOrganizations(mspOrg2);
             transaction.submit(assetID);
             await sleep(3000);
 
 			
             console.log('\n--> Evaluate Transaction: ReadAsset ' + assetID);
-            result = await contractOrg2.evaluateTransaction('ReadAsset', assetID);
+            result = await contractOrg2.evaluateTransaction('query:ReadAsset', assetID);
             console.log(`<-- result: ${prettyJSONString(result.toString())}`);
             await sleep(4000);
 
 
             // console.log("==============REQUEST TO BUY==================")
-            // result = await contractOrg1.evaluateTransaction('ReadRequestToBuy', assetID);//
+            // result = await contractOrg1.evaluateTransaction('query:ReadRequestToBuy', assetID);//
             // console.log(`<-- result: ${result.toString()}`);
             // await sleep(3000);
 
@@ -261,13 +279,14 @@ async function main() {
 			try {
 				// Agree to a buy by Org2
 				const asset_price = {
-					asset_id: assetID,
 					price: 110,
-					trade_id: randomNumber.toString()
+					currency: 'EUR',
+					quantity: { value: 10, unit: 'kg' },
+					salt: tradeSalt
 				};
 				const asset_price_string = JSON.stringify(asset_price);
 				console.log(`${GREEN}--> Submit Transaction: AgreeToBuy, ${assetID} as Org2 - endorsed by Org2${RESET}`);
-				transaction = contractOrg2.createTransaction('AgreeToBuy');
+				transaction = contractOrg2.createTransaction('market:AgreeToBuy');
 				transaction.setEndorsingOrganizations(mspOrg2);//mspOrg1
 				transaction.setTransient({
 					asset_price: Buffer.from(asset_price_string)
@@ -281,18 +300,21 @@ async function main() {
 
 
             console.log('\n**************** As Org1 Client ****************');
-            // All members can send txn ReadRequestToBuy, set by Org2 above
-            console.log('\n--> Evaluate Transaction: ReadRequestToBuy ' + assetID);
-            result = await contractOrg1.evaluateTransaction('ReadRequestToBuy', assetID,assetCollection);//should change how AgreeToTransfer is implemented
+            // The seller lists the buy requests for its asset
+            console.log('\n--> Evaluate Transaction: ListBuyRequests ' + assetID);
+            result = await contractOrg1.evaluateTransaction('query:ListBuyRequests', assetID);
             console.log(`<-- result: ${prettyJSONString(result.toString())}`);
             await sleep(3000);
 
 
 
+            // The buyer needs the tokens and has to approve the seller for them before the transfer
+            await fundPurchase(contractAdmin, contractOrg2, { id: org2UserId, mspID: mspOrg2 }, { id: org1UserId, mspID: mspOrg1 }, tradePrice);
+
             // Transfer the asset to Org2 //
             // To transfer the asset, the owner needs to pass the MSP ID of new asset owner, and initiate the transfer
             console.log('\n--> Submit Transaction: TransferRequestedAsset ' + assetID);
-            statefulTxn = contractOrg1.createTransaction('TransferRequestedAsset');
+            statefulTxn = contractOrg1.createTransaction('market:TransferRequestedAsset');
             let tmapData = Buffer.from(JSON.stringify(buyerDetails));
             statefulTxn.setEndorsingOrganizations(mspOrg1);
             statefulTxn.setTransient({
@@ -303,21 +325,21 @@ async function main() {
 
 
 			console.log('\n--> We are going to read privateAssetAfter after transfer to org2');
-			result = await contractOrg1.evaluateTransaction('ReadAsset', assetID);
+			result = await contractOrg1.evaluateTransaction('query:ReadAsset', assetID);
 			console.log(`*** Result: ${prettyJSONString(result.toString())}`);
             await sleep(3000);
 
 
 			console.log('\n~~~~~~~~~~~~~~~~ As Org2 Client ~~~~~~~~~~~~~~~~');
 			console.log('\n--> Evaluate Transaction: GetAssetHistory, get the history of ',assetID);
-			result = await contractOrg1.evaluateTransaction('GetAssetHistory', assetID);
+			result = await contractOrg1.evaluateTransaction('query:GetAssetHistory', assetID);
 			console.log(`*** Result: ${prettyJSONString(result.toString())}`);
             await sleep(3000);
 
             console.log('\n~~~~~~~~~~~~~~~ We have to delete previous buy request ~~~~~~~~~~~~~~~~');
-            transaction = contractOrg2.createTransaction('DeleteBuyRequest');
+            transaction = contractOrg2.createTransaction('market:DeleteBuyRequest');
             transaction.setEndorsingOrganizations(mspOrg2);
-            transaction.submit(assetID,assetCollection);
+            transaction.submit(assetID);
             await sleep(3000);
 
 
@@ -328,10 +350,10 @@ async function main() {
 
 
             console.log('\n~~~~~~~~~~~~~~~~ As Org3 Client ~~~~~~~~~~~~~~~~\n');
-            buyerDetails = { assetID: assetID, buyerMSP: mspOrg3 };
+            buyerDetails = { assetID: assetID, buyerMSP: mspOrg3, buyerID: org3UserId };
             console.log('\n~~~~~~~~~~~~~~~ We need to request to buy asset ~~~~~~~~~~~~~~~~');
             //make request to buy it,might have to do this before org1 sets price
-            transaction = contractOrg3.createTransaction('RequestToBuy');
+            transaction = contractOrg3.createTransaction('market:RequestToBuy');
             transaction.setEndorsingOrganizations(mspOrg3);
             transaction.submit(assetID);
             await sleep(3000);
@@ -339,7 +361,7 @@ async function main() {
 			
             await sleep(2000);
             console.log("=====This reads the request to buy from org3")
-            result = await contractOrg2.evaluateTransaction('ReadRequestToBuy', assetID,sharedCollectionOrg2Org3);//should change how AgreeToTransfer is implemented
+            result = await contractOrg2.evaluateTransaction('query:ListBuyRequests', assetID);
             await sleep(3000);
             console.log(`<-- result: ${prettyJSONString(result.toString())}`);
             await sleep(1000);
@@ -349,13 +371,14 @@ async function main() {
             try {
 				// Agree to a sell by Org2
 				const asset_price = {
-					asset_id: assetID,
 					price: 110,
-					trade_id: randomNumber.toString()
+					currency: 'EUR',
+					quantity: { value: 10, unit: 'kg' },
+					salt: tradeSalt
 				};
 				const asset_price_string =  Buffer.from(JSON.stringify(asset_price));
 				console.log(`${GREEN}--> Submit Transaction: setPrice, ${assetID} as Org2 - endorsed by Org2${RESET}`);
-				transaction = contractOrg2.createTransaction('SetPrice');
+				transaction = contractOrg2.createTransaction('market:SetPrice');
                 transaction.setEndorsingOrganizations(mspOrg2);
 				transaction.setTransient({
 					asset_price:asset_price_string
@@ -373,13 +396,14 @@ async function main() {
 			try {
 				// Agree to a buy by Org3
 				const asset_price = {
-					asset_id: assetID,
 					price: 110,
-					trade_id: randomNumber.toString()
+					currency: 'EUR',
+					quantity: { value: 10, unit: 'kg' },
+					salt: tradeSalt
 				};
 				const asset_price_string = JSON.stringify(asset_price);
 				console.log(`${GREEN}--> Submit Transaction: AgreeToBuy, ${assetID} as Org3 - endorsed by Org3${RESET}`);
-				transaction = contractOrg3.createTransaction('AgreeToBuy');
+				transaction = contractOrg3.createTransaction('market:AgreeToBuy');
                 transaction.setEndorsingOrganizations(mspOrg3);
 				transaction.setTransient({
 					asset_price: Buffer.from(asset_price_string)
@@ -395,18 +419,21 @@ async function main() {
 
 
             console.log('\n**************** As Org2Client ****************');
-            // All members can send txn ReadRequestToBuy, set by Org2 above
-            console.log('\n--> Evaluate Transaction: ReadRequestToBuy ' + assetID);
-            result = await contractOrg2.evaluateTransaction('ReadRequestToBuy', assetID,sharedCollectionOrg2Org3);//should change how AgreeToTransfer is implemented
+            // The seller lists the buy requests for its asset
+            console.log('\n--> Evaluate Transaction: ListBuyRequests ' + assetID);
+            result = await contractOrg2.evaluateTransaction('query:ListBuyRequests', assetID);
             console.log(`<-- result: ${prettyJSONString(result.toString())}`);
             await sleep(3000);
 
 
 
+            // The buyer needs the tokens and has to approve the seller for them before the transfer
+            await fundPurchase(contractAdmin, contractOrg3, { id: org3UserId, mspID: mspOrg3 }, { id: org2UserId, mspID: mspOrg2 }, tradePrice);
+
             // Transfer the asset to Org3 //
             // To transfer the asset, the owner needs to pass the MSP ID of new asset owner, and initiate the transfer
             console.log('\n--> Submit Transaction: TransferRequestedAsset ' + assetID);
-            statefulTxn = contractOrg2.createTransaction('TransferRequestedAsset');
+            statefulTxn = contractOrg2.createTransaction('market:TransferRequestedAsset');
             statefulTxn.setEndorsingOrganizations(mspOrg2);
             tmapData = Buffer.from(JSON.stringify(buyerDetails));
             statefulTxn.setTransient({
@@ -419,7 +446,7 @@ async function main() {
 
 
 			console.log('\n--> We are going to read privateAssetAfter after transfer to org3');
-			result = await contractOrg2.evaluateTransaction('ReadAsset', assetID);
+			result = await contractOrg2.evaluateTransaction('query:ReadAsset', assetID);
 			console.log(`*** Result: ${prettyJSONString(result.toString())}`);
             await sleep(3000);
 
@@ -427,7 +454,7 @@ async function main() {
 
 		    console.log('\n~~~~~~~~~~~~~~~~ As Org3 Client ~~~~~~~~~~~~~~~~');
 			console.log('\n--> Evaluate Transaction: GetAssetHistory, get the history of ',assetID);
-			result = await contractOrg2.evaluateTransaction('GetAssetHistory', assetID);
+			result = await contractOrg2.evaluateTransaction('query:GetAssetHistory', assetID);
 			console.log(`*** Result: ${prettyJSONString(result.toString())}`);
 
 
@@ -441,6 +468,7 @@ async function main() {
             gatewayOrg1.disconnect();
 			gatewayOrg2.disconnect();
             gatewayOrg3.disconnect();
+            gatewayAdmin.disconnect();
 			
 		}
 	} catch (error) {
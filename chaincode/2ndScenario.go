@@ -4,7 +4,6 @@ import (
   "encoding/json"
   "fmt"
   "log"
-  "encoding/base64"
   "github.com/hyperledger/fabric-contract-api-go/contractapi"
   "time"
   "strings"
@@ -16,43 +15,38 @@ import (
 
 
 
-// SmartContract provides functions for managing an Asset
+// SmartContract holds the identity, collection and ledger helpers the contracts of the chaincode
+// share, see contracts.go. It has no transactions of its own.
 type SmartContract struct {
   contractapi.Contract
 }
 
 // Asset describes basic details of what makes up a simple asset
 type Asset struct {
-	AssetType 	 string      	`json:"assetType"`
-	ID             string  	 	`json:"ID"`
-	Color          string 	 	`json:"color"`
-	Weight         int       	`json:"weight"`
-	Owner          string    	`json:"owner"`
-	OwnerOrg       string    	`json:ownerOrg`
-	Timestamp      time.Time 	`json:"timestamp"`
-	Creator        string 	 	`json:"creator"`
-	ExpirationDate time.Time 	`json:"expirationDate"`
-	SensorData 	 string		 	`json:"sensorData"`
-  
+	DocType   string `json:"docType"` // always "asset", tells assets apart from other documents in rich queries
+	AssetType string `json:"assetType"`
+	ID        string `json:"ID"`
+	Color     string `json:"color"`
+	// Weight is in the unit of the product when the asset was created, see units.go
+	Weight                Quantity  `json:"weight"`
+	Owner                 string    `json:"owner"`
+	OwnerOrg              string    `json:"ownerOrg"`
+	Timestamp             time.Time `json:"timestamp"`
+	SchemaVersion         int       `json:"schemaVersion"` // see asset_schema.go
+	Creator               string    `json:"creator"`
+	ExpirationDate        time.Time `json:"expirationDate"`
+	SensorData            string    `json:"sensorData"` // free-form, readings are stored as SensorReading records
+	Parents               []string  `json:"parents,omitempty"`
+	Children              []string  `json:"children,omitempty"`
+	Consumed              bool      `json:"consumed"`
+	Expired               bool      `json:"expired,omitempty"`
+	ShelfLifeExtendedDays int       `json:"shelfLifeExtendedDays,omitempty"`
 }
 
 
 
 // InitLedger adds a base set of assets to the ledger
-func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
-	
-	
-	temp := ctx.GetClientIdentity().AssertAttributeValue("retailer", "true")
-	if temp==nil {
-		return fmt.Errorf("submitting client not authorized to create asset, he is a Retailer")
-	}
-
-	err := ctx.GetClientIdentity().AssertAttributeValue("farmer", "true")
-	if err != nil {
-		return fmt.Errorf("submitting client not authorized to create asset, he is not a Farmer")
-	}
-	
-	
+func (s *AssetContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
 	timeS,err:= ctx.GetStub().GetTxTimestamp()
 	if err != nil {
 		return  err
@@ -61,87 +55,88 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 	if err != nil {
 		return err
 	}
-	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	clientID, err := s.getSubmittingClientIdentity(ctx)
 	if err != nil {
 		return err
 	}
-	creatorDN, err:=s.GetSubmittingClientDN(ctx)
+	creatorDN, err:=s.getSubmittingClientDN(ctx)
 	if err != nil {
 		return err
 	}
-	expirationDate := timestamp.AddDate(0,0,7)
-
 	//in case a user from other org has the same name , cause they have different CAs that might happen
-	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	clientOrgID, err := getClientMSPID(ctx)
 	if err != nil {
 		return  fmt.Errorf("failed getting client's orgID: %v", err)
 	}
 
 
 	assets := []Asset{
-		{ID: "asset1", Color: "blue",   AssetType:"berries", Weight: 5,  Owner: clientID, OwnerOrg:clientOrgID,Timestamp: timestamp,Creator: creatorDN,SensorData:"",ExpirationDate:expirationDate},
-		{ID: "asset2", Color: "black",  AssetType:"berries", Weight: 5,  Owner: clientID, OwnerOrg:clientOrgID,Timestamp: timestamp,Creator: creatorDN,SensorData:"",ExpirationDate:expirationDate},
-		{ID: "asset3", Color: "green",  AssetType:"apples",  Weight: 10, Owner: clientID, OwnerOrg:clientOrgID,Timestamp: timestamp,Creator: creatorDN,SensorData:"",ExpirationDate:expirationDate},
-		{ID: "asset4", Color: "yellow", AssetType:"apples",  Weight: 10, Owner: clientID, OwnerOrg:clientOrgID,Timestamp: timestamp,Creator: creatorDN,SensorData:"",ExpirationDate:expirationDate},
-		{ID: "asset5", Color: "red",    AssetType:"apples",  Weight: 15, Owner: clientID, OwnerOrg:clientOrgID,Timestamp: timestamp,Creator: creatorDN,SensorData:"",ExpirationDate:expirationDate},
-		{ID: "asset6", Color: "white",  AssetType:"grapes",  Weight: 15, Owner: clientID, OwnerOrg:clientOrgID,Timestamp: timestamp,Creator: creatorDN,SensorData:"",ExpirationDate:expirationDate},
-	  }
-  for _, asset := range assets {
-    assetJSON, err := json.Marshal(asset)
-    if err != nil {
-      return err
-    }
-
-    err = ctx.GetStub().PutState(asset.ID, assetJSON)
-    if err != nil {
-      return fmt.Errorf("failed to put to world state. %v", err)
-    }
-  }
+		{ID: "asset1", Color: "blue", AssetType: "berries", Weight: Quantity{Value: 5}, Owner: clientID, OwnerOrg: clientOrgID, Timestamp: timestamp, Creator: creatorDN, SensorData: ""},
+		{ID: "asset2", Color: "black", AssetType: "berries", Weight: Quantity{Value: 5}, Owner: clientID, OwnerOrg: clientOrgID, Timestamp: timestamp, Creator: creatorDN, SensorData: ""},
+		{ID: "asset3", Color: "green", AssetType: "apples", Weight: Quantity{Value: 10}, Owner: clientID, OwnerOrg: clientOrgID, Timestamp: timestamp, Creator: creatorDN, SensorData: ""},
+		{ID: "asset4", Color: "yellow", AssetType: "apples", Weight: Quantity{Value: 10}, Owner: clientID, OwnerOrg: clientOrgID, Timestamp: timestamp, Creator: creatorDN, SensorData: ""},
+		{ID: "asset5", Color: "red", AssetType: "apples", Weight: Quantity{Value: 15}, Owner: clientID, OwnerOrg: clientOrgID, Timestamp: timestamp, Creator: creatorDN, SensorData: ""},
+		{ID: "asset6", Color: "white", AssetType: "grapes", Weight: Quantity{Value: 15}, Owner: clientID, OwnerOrg: clientOrgID, Timestamp: timestamp, Creator: creatorDN, SensorData: ""},
+	}
+	for _, asset := range assets {
+		//expiration date, weight unit and validation rules come from the product catalog
+		product, err := s.getProduct(ctx, asset.AssetType)
+		if err != nil {
+			return err
+		}
+		asset.Weight.Unit = product.WeightUnit
+		err = product.validateAsset(asset.Color, asset.Weight)
+		if err != nil {
+			return err
+		}
+		asset.ExpirationDate = timestamp.AddDate(0, 0, product.ShelfLifeDays)
+
+		err = putAsset(ctx, &asset)
+		if err != nil {
+			return err
+		}
+	}
 
-  return nil
+	return nil
 }
 
 // CreateAsset issues a new asset to the world state with given details and adds price to shared collection.
-func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface, id string, color string, weight int,assetType string) error {
+// The weight is in the weight unit of the product, which the asset keeps, and need not be a whole number.
+func (s *AssetContract) CreateAsset(ctx contractapi.TransactionContextInterface, id string, color string, weight float64, assetType string) error {
 //objectType strings,
 
 	//check if asset already exists
-	exists, err := s.AssetExists(ctx, id)
+	exists, err := s.assetExists(ctx, id)
 	if err != nil {
 		return err
 	}
 	if exists {
 		return fmt.Errorf("the asset %s already exists", id)
 	}
-	//get clientOrgID only client with Org1MSP can create assets
-	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	//only farmers of Org1 can create assets, see policy.go
+	clientOrgID, err := getClientMSPID(ctx)
 	if err != nil {
 		return  fmt.Errorf("failed getting client's orgID: %v", err)
 	}
-	if clientOrgID != "Org1MSP"{
-		return fmt.Errorf("submitting client not authorized to create asset, not a member of Org1")
-	}
 
-	//Access Control only farmers can createAssets
-	temp := ctx.GetClientIdentity().AssertAttributeValue("retailer", "true")
-	if temp==nil {
-		return fmt.Errorf("submitting client not authorized to create asset, he is a Retailer")
+	product, err := s.getProduct(ctx, assetType)
+	if err != nil {
+		return err
 	}
-
-	farmer := ctx.GetClientIdentity().AssertAttributeValue("farmer", "true")
-	if farmer != nil {
-		return fmt.Errorf("submitting client not authorized to create asset, he is not a Farmer")
+	assetWeight := newQuantity(weight, product.WeightUnit)
+	err = product.validateAsset(color, assetWeight)
+	if err != nil {
+		return err
 	}
-
 		
 
 	// Get ID of submitting client identity
-	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	clientID, err := s.getSubmittingClientIdentity(ctx)
 	if err != nil {
 		return err
 	}
 
-	creatorDN, err:=s.GetSubmittingClientDN(ctx)
+	creatorDN, err:=s.getSubmittingClientDN(ctx)
 	if err != nil {
 		return err
 	}
@@ -155,8 +150,8 @@ func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface,
 	if erri != nil {
 		return  erri
 	}
-	//add expiration date
-	expirationDate := timestamp.AddDate(0,0,7)
+	//add expiration date, the shelf life of the product
+	expirationDate := timestamp.AddDate(0,0,product.ShelfLifeDays)
 
 
 	// Verify that the client is submitting request to peer in their organization
@@ -169,126 +164,119 @@ func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface,
 
 	// Make submitting client the owner
 	asset := Asset{
-		AssetType:		assetType,
-		ID:    			id,
-		Color: 			color,
-		Weight:  		weight,
-		Owner: 			clientID,
-		OwnerOrg:		clientOrgID,
-		Timestamp:  	timestamp,
-		Creator: 		creatorDN,
-		ExpirationDate:	expirationDate,
-		SensorData: 	""}
-
-	assetJSONasBytes, err := json.Marshal(asset)
-	if err != nil {
-		return fmt.Errorf("failed to marshal asset into JSON: %v", err)
-	}
-
-
-
-	err = ctx.GetStub().PutState(id, assetJSONasBytes)//puts data in public
-	if err != nil {
-		return fmt.Errorf("failed to put asset into private data collecton: %v", err)
-	}
-
-	return nil
+		DocType:        assetDocType,
+		AssetType:      assetType,
+		ID:             id,
+		Color:          color,
+		Weight:         assetWeight,
+		Owner:          clientID,
+		OwnerOrg:       clientOrgID,
+		Timestamp:      timestamp,
+		Creator:        creatorDN,
+		ExpirationDate: expirationDate,
+		SensorData:     ""}
+
+	//puts data in public
+	return putAsset(ctx, &asset)
 
 }
 
-// UpdateAsset updates an existing asset in the world state with provided parameters.
-func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface, id string, newColor string, newWeight int) error {
+// UpdateAsset updates an existing asset in the world state with provided parameters. The weight is in the unit of the asset.
+func (s *AssetContract) UpdateAsset(ctx contractapi.TransactionContextInterface, id string, newColor string, newWeight float64) error {
 
-	asset, err := s.ReadAsset(ctx, id)
+	asset, err := s.readAsset(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	err = s.verifyAssetOwner(ctx, asset)
 	if err != nil {
-		return  fmt.Errorf("failed getting client's orgID: %v", err)
+		return err
 	}
-	
-	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	err = verifyNotConsumed(asset)
 	if err != nil {
 		return err
 	}
-
-	if clientID != asset.Owner {
-		return fmt.Errorf("submitting client not authorized to update asset, does not own asset")
+	// bidders bid on the lot as it was when the auction opened
+	err = s.verifyNoOpenAuction(ctx, id)
+	if err != nil {
+		return err
 	}
-
-	if clientOrgID != asset.OwnerOrg {
-		return fmt.Errorf("submitting client not authorized to update asset, not from the same Org")
+	product, err := s.getProduct(ctx, asset.AssetType)
+	if err != nil {
+		return err
 	}
-	asset.Color = newColor
-	asset.Weight = newWeight
-
-
-	assetJSON, err := json.Marshal(asset)
+	weight := newQuantity(newWeight, asset.Weight.Unit)
+	err = product.validateAsset(newColor, weight)
 	if err != nil {
 		return err
 	}
+	asset.Color = newColor
+	asset.Weight = weight
 
-	return ctx.GetStub().PutState(id, assetJSON)
+	return putAsset(ctx, asset)
 }
 
 // DeleteAsset deletes a given asset from the world state.
-func (s *SmartContract) DeleteAsset(ctx contractapi.TransactionContextInterface, id string) error {
+func (s *AssetContract) DeleteAsset(ctx contractapi.TransactionContextInterface, id string) error {
 
-	asset, err := s.ReadAsset(ctx, id)
+	asset, err := s.readAsset(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	err = s.verifyAssetOwner(ctx, asset)
 	if err != nil {
 		return err
 	}
 
-	if clientID != asset.Owner {
-		return fmt.Errorf("submitting client not authorized to update asset, does not own asset")
+	// keep repackaged lots, their children refer to them for provenance
+	err = verifyNotConsumed(asset)
+	if err != nil {
+		return err
 	}
-
-	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	err = s.verifyNoOpenAuction(ctx, id)
 	if err != nil {
-		return  fmt.Errorf("failed getting client's orgID: %v", err)
+		return err
 	}
 
-	if clientOrgID != asset.OwnerOrg {
-		return fmt.Errorf("submitting client not authorized to update asset, not from the same Org")
+	return deleteAsset(ctx, asset)
+}
+
+//Delete Buy Request from the collection shared between the buyer and the asset owner
+func (s *MarketContract) DeleteBuyRequest(ctx contractapi.TransactionContextInterface, id string) error {
+	asset, err := s.readAsset(ctx, id)
+	if err != nil {
+		return err
 	}
 
-	return ctx.GetStub().DelState(id)
-}
+	clientOrgID, err := getClientMSPID(ctx)
+	if err != nil {
+		return  fmt.Errorf("failed getting client's orgID: %v", err)
+	}
 
-//Delete Buy Request
-func (s *SmartContract) DeleteBuyRequest(ctx contractapi.TransactionContextInterface, id string, sharedCollection string) error {
-	//temp:=assetCollection
-	request, err := s.ReadRequestToBuy(ctx, id,sharedCollection)
+	sharedCollection, err := s.getSharedCollection(ctx, asset.OwnerOrg, clientOrgID)
 	if err != nil {
 		return err
 	}
 
-	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	clientID, err := s.getSubmittingClientIdentity(ctx)
 	if err != nil {
 		return err
 	}
 
-	if clientID != request.BuyerID {
-		return fmt.Errorf("submitting client not authorized to delete buy request , does not own asset")
+	// requests are kept per buyer, a buyer can only reach its own
+	request, err := s.readRequestToBuy(ctx, id, clientID, sharedCollection)
+	if err != nil {
+		return err
 	}
-	requestToBuyKey, err := ctx.GetStub().CreateCompositeKey(requestToBuyObjectType, []string{id})
+	if request == nil {
+		return fmt.Errorf("no buy request of %s for asset %s exists in %s", clientID, id, sharedCollection)
+	}
+	requestToBuyKey, err := ctx.GetStub().CreateCompositeKey(requestToBuyObjectType, []string{id, clientID})
 	if err != nil {
 		return fmt.Errorf("failed to create composite key: %v", err)
 	}
-	// clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
-	// if err != nil {
-	// 	return  fmt.Errorf("failed getting client's orgID: %v", err)
-	// }
-	// if clientOrgID =="Org3MSP"{
-	// 	temp=assetCollection23
-	// }
 	log.Printf("DeleteBuy Request : collection %v, ID %v,", sharedCollection, id)
 	return ctx.GetStub().DelPrivateData(sharedCollection,requestToBuyKey)
 }
@@ -297,11 +285,16 @@ func (s *SmartContract) DeleteBuyRequest(ctx contractapi.TransactionContextInter
 
 
 // AssetExists returns true when asset with given ID exists in world state
-func (s *SmartContract) AssetExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+func (s *QueryContract) AssetExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	return s.assetExists(ctx, id)
+}
+
+// assetExists returns true when asset with given ID exists in world state
+func (s *SmartContract) assetExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
 
-	assetJSON, err := ctx.GetStub().GetState(id)
+	assetJSON, _, err := getAssetState(ctx, id)
 	if err != nil {
-		return false, fmt.Errorf("failed to read from world state: %v", err)
+		return false, err
 	}
 
 	return assetJSON != nil, nil
@@ -312,42 +305,130 @@ func (s *SmartContract) AssetExists(ctx contractapi.TransactionContextInterface,
 
 
 
-// GetSubmittingClientIdentity returns the name and issuer of the identity that
-// invokes the smart contract. This function base64 decodes the identity string
-// before returning the value to the client or smart contract.
+// getSubmittingClientIdentity returns the common name of the identity that
+// invokes the smart contract, resolved once per transaction by getClient.
 //files is located at pkg/cid/cid.go for GetID() on sourcegraph.com
 //returns x509::CN=FarmerO,OU=org1+OU=client+OU=department1::CN=ca.org1.example.com,O=org1.example.com,L=Durham,ST=North Carolina,C=US
 //on GetId() => ("x509::%s::%s", getDN(&c.cert.Subject), getDN(&c.cert.Issuer)
 //DN is distinguished name as defined by RFC 2253
 /* https://sourcegraph.com/github.com/hyperledger/fabric-chaincode-go@38d29fabecb9916a8a1ecbd0facb72f2ac32d016/-/blob/pkg/cid/cid.go?L76 */
-func (s *SmartContract) GetSubmittingClientIdentity(ctx contractapi.TransactionContextInterface) (string, error) {
+func (s *SmartContract) getSubmittingClientIdentity(ctx contractapi.TransactionContextInterface) (string, error) {
+	client, err := getClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	return client.ID, nil
+}
+
+
+//getSubmittingClientDN returns the Distinguished Name as defined by RFC 2253
+func (s *SmartContract) getSubmittingClientDN(ctx contractapi.TransactionContextInterface) (string, error) {
+	client, err := getClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	return client.DN, nil
+}
+
+// verifyAssetOwner checks that the submitting client owns the asset and is from the owner org
+func (s *SmartContract) verifyAssetOwner(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	client, err := getClient(ctx)
+	if err != nil {
+		return err
+	}
+	if client.ID != asset.Owner {
+		return fmt.Errorf("submitting client not authorized to change asset %s, does not own asset", asset.ID)
+	}
+	if client.MSPID != asset.OwnerOrg {
+		return fmt.Errorf("submitting client not authorized to change asset %s, not from the same Org", asset.ID)
+	}
+	return nil
+}
+
+// putAsset writes the asset to the world state under Asset~ID and updates its index entries.
+// An asset still stored under its raw ID is moved to the new key.
+func putAsset(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	previous, legacy, err := readStoredAsset(ctx, asset.ID)
+	if err != nil {
+		return err
+	}
+	key, err := assetKey(ctx, asset.ID)
+	if err != nil {
+		return err
+	}
 
-	b64ID, err := ctx.GetClientIdentity().GetID()
+	asset.DocType = assetDocType
+	asset.SchemaVersion = currentAssetSchemaVersion
+	assetJSON, err := json.Marshal(asset)
 	if err != nil {
-		return "", fmt.Errorf("Failed to read clientID: %v", err)
+		return fmt.Errorf("failed to marshal asset into JSON: %v", err)
 	}
-	decodeID, err := base64.StdEncoding.DecodeString(b64ID)
+	err = ctx.GetStub().PutState(key, assetJSON)
 	if err != nil {
-		return "", fmt.Errorf("failed to base64 decode clientID: %v", err)
+		return fmt.Errorf("failed to put asset %s to world state: %v", asset.ID, err)
+	}
+	if legacy {
+		err = ctx.GetStub().DelState(asset.ID)
+		if err != nil {
+			return fmt.Errorf("failed to delete asset %s from its old key: %v", asset.ID, err)
+		}
 	}
-	clientName:=_between(string(decodeID),"x509::CN=",",")
-	return  clientName, nil
+	return updateAssetIndexes(ctx, previous, asset)
 }
 
+// deleteAsset removes the asset, as read from the world state, and its index entries
+func deleteAsset(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	_, legacy, err := getAssetState(ctx, asset.ID)
+	if err != nil {
+		return err
+	}
+	key := asset.ID
+	if !legacy {
+		key, err = assetKey(ctx, asset.ID)
+		if err != nil {
+			return err
+		}
+	}
 
-//GetSubmittingClientDN returns the Distinguished Name as defined by RFC 2253
-func (s *SmartContract) GetSubmittingClientDN(ctx contractapi.TransactionContextInterface) (string, error) {
+	err = ctx.GetStub().DelState(key)
+	if err != nil {
+		return fmt.Errorf("failed to delete asset %s from world state: %v", asset.ID, err)
+	}
+	return updateAssetIndexes(ctx, asset, nil)
+}
 
-	b64ID, err := ctx.GetClientIdentity().GetID()
+// readStoredAsset returns the asset as it is stored in the world state, or nil.
+// legacy reports that it is still stored under its raw ID.
+func readStoredAsset(ctx contractapi.TransactionContextInterface, id string) (*Asset, bool, error) {
+	assetJSON, legacy, err := getAssetState(ctx, id)
 	if err != nil {
-		return "", fmt.Errorf("Failed to read clientID: %v", err)
+		return nil, false, err
+	}
+	if assetJSON == nil {
+		return nil, false, nil
 	}
-	decodeID, err := base64.StdEncoding.DecodeString(b64ID)
+	asset, err := decodeAsset(ctx, assetJSON)
 	if err != nil {
-		return "", fmt.Errorf("failed to base64 decode clientID: %v", err)
+		return nil, false, err
 	}
-	
-	return string(decodeID) , nil
+	return asset, legacy, nil
+}
+
+// getTxTime returns the transaction timestamp, which is the same on every endorsing peer
+func getTxTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	txTimestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+	return ptypes.Timestamp(txTimestamp)
+}
+
+// verifyNotConsumed fails for a lot that has been split or merged into other lots
+func verifyNotConsumed(asset *Asset) error {
+	if asset.Consumed {
+		return fmt.Errorf("asset %s has been repackaged into %v and can no longer be changed", asset.ID, asset.Children)
+	}
+	return nil
 }
 
 //Function to get string between two strings.
@@ -368,15 +449,3 @@ func _between(value string, a string, b string) string {
     return value[posFirstAdjusted:posLast]
 }
 
-
-//main function that starts the chaincode
-func main() {
-  assetChaincode, err := contractapi.NewChaincode(&SmartContract{})
-  if err != nil {
-    log.Panicf("Error creating asset-transfer-basic chaincode: %v", err)
-  }
-
-  if err := assetChaincode.Start(); err != nil {
-    log.Panicf("Error starting asset-transfer-basic chaincode: %v", err)
-  }
-}
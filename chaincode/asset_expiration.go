@@ -0,0 +1,239 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ExtendShelfLife moves the expiration date of an asset days later. Only the owner can extend
+// the shelf life, only before the asset expires and only up to the limit in the product catalog.
+func (s *AssetContract) ExtendShelfLife(ctx contractapi.TransactionContextInterface, assetID string, days int) error {
+	asset, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	err = s.verifyAssetOwner(ctx, asset)
+	if err != nil {
+		return err
+	}
+	err = verifyNotConsumed(asset)
+	if err != nil {
+		return err
+	}
+	err = verifyNotExpired(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	if days <= 0 {
+		return fmt.Errorf("shelf life must be extended by a positive number of days")
+	}
+	product, err := s.getProduct(ctx, asset.AssetType)
+	if err != nil {
+		return err
+	}
+	maxDays := product.MaxShelfLifeExtensionDays
+	if asset.ShelfLifeExtendedDays+days > maxDays {
+		return fmt.Errorf("shelf life of %s can be extended by %d days in total, asset %s has already been extended by %d", asset.AssetType, maxDays, assetID, asset.ShelfLifeExtendedDays)
+	}
+
+	asset.ExpirationDate = asset.ExpirationDate.AddDate(0, 0, days)
+	asset.ShelfLifeExtendedDays += days
+	log.Printf("ExtendShelfLife: %v until %v", assetID, asset.ExpirationDate)
+	return putAsset(ctx, asset)
+}
+
+// MarkExpired takes an asset off the market. The owner can do it at any time,
+// anyone else only once the expiration date has passed.
+func (s *AssetContract) MarkExpired(ctx contractapi.TransactionContextInterface, assetID string) error {
+	asset, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	if asset.Expired {
+		return fmt.Errorf("asset %s is already marked as expired", assetID)
+	}
+
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if txTime.Before(asset.ExpirationDate) {
+		err = s.verifyAssetOwner(ctx, asset)
+		if err != nil {
+			return fmt.Errorf("asset %s expires on %v, before that only its owner can mark it as expired: %v", assetID, asset.ExpirationDate, err)
+		}
+	}
+
+	asset.Expired = true
+	log.Printf("MarkExpired: %v", assetID)
+	return putAsset(ctx, asset)
+}
+
+// IndexAssetExpirations adds at most batchSize assets written before the expiration index existed to
+// it, starting at bookmark. Pass an empty bookmark for the first batch and the returned bookmark for the
+// next one. Once a batch returns an empty bookmark every asset is indexed and QueryAssetsExpiringBefore
+// walks the index instead of reading every asset. Assets still stored under their raw ID are indexed by
+// MigrateAssetKeys.
+func (s *AssetContract) IndexAssetExpirations(ctx contractapi.TransactionContextInterface, batchSize int, bookmark string) (*AssetMigrationResult, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batchSize must be a positive number")
+	}
+
+	// paginated queries are not allowed in transactions that write, so the keys before bookmark are skipped here
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(assetObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &AssetMigrationResult{Migrated: []string{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if queryResponse.Key < bookmark {
+			continue
+		}
+
+		asset, err := decodeAsset(ctx, queryResponse.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", queryResponse.Key, err)
+		}
+		if asset.Consumed {
+			continue
+		}
+		indexKey, err := expirationIndexKey(ctx, asset)
+		if err != nil {
+			return nil, err
+		}
+		indexed, err := ctx.GetStub().GetState(indexKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index entry: %v", err)
+		}
+		if indexed != nil {
+			continue
+		}
+		if len(result.Migrated) == batchSize {
+			result.Bookmark = queryResponse.Key
+			break
+		}
+
+		err = ctx.GetStub().PutState(indexKey, indexValue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to put index entry: %v", err)
+		}
+		result.Migrated = append(result.Migrated, asset.ID)
+	}
+
+	if result.Bookmark == "" {
+		builtKey, err := ctx.GetStub().CreateCompositeKey(expirationIndexBuiltObjectType, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create composite key: %v", err)
+		}
+		err = ctx.GetStub().PutState(builtKey, indexValue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to put to world state: %v", err)
+		}
+	}
+	log.Printf("IndexAssetExpirations: %d assets, next %q", len(result.Migrated), result.Bookmark)
+	return result, nil
+}
+
+// QueryAssetsExpiringBefore returns the assets that are still on the market and expire before
+// date (RFC 3339), including the ones whose date has already passed, the first to expire first.
+// Assets marked as expired or consumed are left out. It walks the expiration index from the
+// earliest date and stops at date. Until IndexAssetExpirations has indexed the assets written
+// before the index existed, it reads every asset instead.
+func (s *QueryContract) QueryAssetsExpiringBefore(ctx contractapi.TransactionContextInterface, date string) ([]*Asset, error) {
+	before, err := time.Parse(time.RFC3339Nano, date)
+	if err != nil {
+		return nil, fmt.Errorf("date must be an RFC 3339 timestamp: %v", err)
+	}
+	builtKey, err := ctx.GetStub().CreateCompositeKey(expirationIndexBuiltObjectType, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	built, err := ctx.GetStub().GetState(builtKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if built == nil {
+		return s.scanAssetsExpiringBefore(ctx, before)
+	}
+	end := before.UTC().Format(expirationIndexLayout)
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(expirationIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	results := []*Asset{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) != 2 {
+			return nil, fmt.Errorf("index key %q is not valid", queryResponse.Key)
+		}
+		if compositeKeyParts[0] >= end {
+			break
+		}
+
+		asset, err := s.readAsset(ctx, compositeKeyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		if asset.Expired || asset.Consumed {
+			continue
+		}
+		results = append(results, asset)
+	}
+	return results, nil
+}
+
+// scanAssetsExpiringBefore is QueryAssetsExpiringBefore without the expiration index
+func (s *QueryContract) scanAssetsExpiringBefore(ctx contractapi.TransactionContextInterface, before time.Time) ([]*Asset, error) {
+	assets, err := s.GetAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*Asset{}
+	for _, asset := range assets {
+		if !asset.Consumed && !asset.Expired && asset.ExpirationDate.Before(before) {
+			results = append(results, asset)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].ExpirationDate.Before(results[j].ExpirationDate)
+	})
+	return results, nil
+}
+
+// verifyNotExpired fails for an asset that is marked as expired or whose expiration
+// date is not after the transaction timestamp
+func verifyNotExpired(ctx contractapi.TransactionContextInterface, asset *Asset) error {
+	if asset.Expired {
+		return fmt.Errorf("asset %s is marked as expired", asset.ID)
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !txTime.Before(asset.ExpirationDate) {
+		return fmt.Errorf("asset %s expired on %v", asset.ID, asset.ExpirationDate)
+	}
+	return nil
+}
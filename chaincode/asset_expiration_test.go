@@ -0,0 +1,233 @@
+package chaincode_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+	"phase2/chaincode/mocks"
+)
+
+func TestExpiredAssetsCannotBeTraded(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	run(t, stub, []step{
+		{"farmer creates berries", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "blue", 5, "berries")
+		}, ""},
+		{"farmer creates apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset2", "red", 10, "apples")
+		}, ""},
+		{"farmer asks 40 for the berries", farmer, price("40", 5), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, ""},
+		{"retailer bids 40 for the berries", retailer, price("40", 5), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AgreeToBuy(ctx, "asset1")
+		}, ""},
+		{"retailer requests the berries", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, ""},
+	})
+
+	stub.Advance(8 * 24 * time.Hour)
+
+	run(t, stub, []step{
+		{"transfer after expiration", farmer, transferTo(t, "asset1", retailer), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferRequestedAsset(ctx)
+		}, "expired on"},
+		{"ask after expiration", farmer, price("40", 10), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset2")
+		}, "expired on"},
+		{"bid after expiration", retailer, price("40", 10), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AgreeToBuy(ctx, "asset2")
+		}, "expired on"},
+		{"request after expiration", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset2")
+		}, "expired on"},
+		{"split after expiration", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SplitAsset(ctx, "asset2", []string{"a", "b"}, []float64{5, 5}, "kg")
+		}, "expired on"},
+		{"extend after expiration", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.ExtendShelfLife(ctx, "asset2", 1)
+		}, "expired on"},
+		{"anyone can mark an expired asset", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.MarkExpired(ctx, "asset1")
+		}, ""},
+		{"already marked", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.MarkExpired(ctx, "asset1")
+		}, "already marked"},
+	})
+	require.True(t, readAsset(t, stub, "asset1").Expired)
+	require.Equal(t, "FarmerO", readAsset(t, stub, "asset1").Owner)
+}
+
+func TestShelfLife(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	extend := func(id string, days int) txFunc {
+		return func(ctx contractapi.TransactionContextInterface) error {
+			return sc.ExtendShelfLife(ctx, id, days)
+		}
+	}
+	run(t, stub, []step{
+		{"farmer creates berries", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "blue", 5, "berries")
+		}, ""},
+		{"farmer creates apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset2", "red", 10, "apples")
+		}, ""},
+		{"not the owner", farmer2, nil, extend("asset1", 1), "does not own asset"},
+		{"zero days", farmer, nil, extend("asset1", 0), "positive number"},
+		{"extend berries by 1 day", farmer, nil, extend("asset1", 1), ""},
+		{"berries over the limit", farmer, nil, extend("asset1", 2), "extended by 2 days in total"},
+		{"extend apples by 14 days", farmer, nil, extend("asset2", 14), ""},
+		{"others cannot mark a fresh asset", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.MarkExpired(ctx, "asset2")
+		}, "only its owner"},
+	})
+
+	berries := readAsset(t, stub, "asset1")
+	apples := readAsset(t, stub, "asset2")
+	require.Equal(t, 1, berries.ShelfLifeExtendedDays)
+	require.Equal(t, 14, apples.ShelfLifeExtendedDays)
+
+	var expiring []*chaincode.Asset
+	query := func(before time.Time) {
+		err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			expiring, err = sc.QueryAssetsExpiringBefore(ctx, before.Format(time.RFC3339))
+			return err
+		})
+		require.NoError(t, err)
+	}
+	query(berries.ExpirationDate)
+	require.Empty(t, expiring)
+	query(apples.ExpirationDate.Add(time.Second))
+	require.Len(t, expiring, 2)
+	require.Equal(t, "asset1", expiring[0].ID)
+	require.Equal(t, "asset2", expiring[1].ID)
+
+	stub.Advance(10 * 24 * time.Hour)
+	run(t, stub, []step{
+		{"extended apples can still be sold", farmer, price("40", 10), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset2")
+		}, ""},
+		{"owner marks the apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.MarkExpired(ctx, "asset2")
+		}, ""},
+		{"marked apples cannot be bid on", retailer, price("40", 10), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AgreeToBuy(ctx, "asset2")
+		}, "marked as expired"},
+	})
+
+	// the berries are past their date but still on the market, the marked apples are not
+	query(apples.ExpirationDate.Add(time.Second))
+	require.Len(t, expiring, 1)
+	require.Equal(t, "asset1", expiring[0].ID)
+}
+
+func TestExpirationIndexSkipsConsumedLots(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	run(t, stub, []step{
+		{"farmer creates apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 10, "apples")
+		}, ""},
+		{"farmer splits the apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SplitAsset(ctx, "asset1", []string{"lot1", "lot2"}, []float64{4, 6}, "kg")
+		}, ""},
+	})
+
+	var ids []string
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		expiring, err := sc.QueryAssetsExpiringBefore(ctx, stub.Now.AddDate(1, 0, 0).Format(time.RFC3339))
+		for _, asset := range expiring {
+			ids = append(ids, asset.ID)
+		}
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"lot1", "lot2"}, ids, "the split lot is consumed")
+}
+
+func TestIndexAssetExpirations(t *testing.T) {
+	sc := contracts{}
+	stub := mocks.NewChaincodeStub()
+	err := stub.Submit(admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+		return sc.InitProductCatalog(ctx)
+	})
+	require.NoError(t, err)
+
+	// records written before the expiration index existed
+	putOld := func(id string, days int) {
+		err := stub.Submit(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			key, err := ctx.GetStub().CreateCompositeKey("Asset", []string{id})
+			require.NoError(t, err)
+			return ctx.GetStub().PutState(key, []byte(fmt.Sprintf(
+				`{"ID":%q,"assetType":"apples","color":"red","weight":10,"weightUnit":"kg","owner":"FarmerO","ownerOrg":"Org1MSP","expirationDate":%q,"schemaVersion":2}`,
+				id, stub.Now.AddDate(0, 0, days).Format(time.RFC3339))))
+		})
+		require.NoError(t, err)
+	}
+	putOld("asset1", 3)
+	putOld("asset2", 1)
+	putOld("asset3", 2)
+	run(t, stub, []step{
+		{"farmer creates a current record", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset4", "green", 10, "apples")
+		}, ""},
+	})
+
+	expiring := func() []string {
+		var ids []string
+		err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			assets, err := sc.QueryAssetsExpiringBefore(ctx, stub.Now.AddDate(1, 0, 0).Format(time.RFC3339))
+			for _, asset := range assets {
+				ids = append(ids, asset.ID)
+			}
+			return err
+		})
+		require.NoError(t, err)
+		return ids
+	}
+	require.Equal(t, []string{"asset2", "asset3", "asset1", "asset4"}, expiring(), "assets are read one by one until the index is built")
+
+	index := func(bookmark string) *chaincode.AssetMigrationResult {
+		var result *chaincode.AssetMigrationResult
+		err := stub.Submit(admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			result, err = sc.IndexAssetExpirations(ctx, 2, bookmark)
+			return err
+		})
+		require.NoError(t, err)
+		return result
+	}
+	first := index("")
+	require.Equal(t, []string{"asset1", "asset2"}, first.Migrated)
+	require.NotEmpty(t, first.Bookmark)
+	require.Equal(t, []string{"asset2", "asset3", "asset1", "asset4"}, expiring(), "a partial index is not used")
+
+	second := index(first.Bookmark)
+	require.Equal(t, []string{"asset3"}, second.Migrated, "assets already in the index are skipped")
+	require.Empty(t, second.Bookmark)
+	require.Equal(t, []string{"asset2", "asset3", "asset1", "asset4"}, expiring())
+
+	// once the index is built the query walks it, a record that bypassed putAsset is not seen
+	putOld("asset5", 1)
+	require.Equal(t, []string{"asset2", "asset3", "asset1", "asset4"}, expiring())
+
+	run(t, stub, []step{
+		{"farmer cannot build the index", farmer, nil, mocks.Invoke(chaincode.NewAssetContract(), "IndexAssetExpirations", func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.IndexAssetExpirations(ctx, 2, "")
+			return err
+		}), "not authorized to call asset:IndexAssetExpirations"},
+		{"batch size must be positive", admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.IndexAssetExpirations(ctx, 0, "")
+			return err
+		}, "batchSize must be a positive number"},
+	})
+}
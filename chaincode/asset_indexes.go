@@ -0,0 +1,149 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Composite-key indexes of the public asset state. They are kept up to date by putAsset and
+// deleteAsset and work on any state database, unlike the rich queries of asset_queries.go.
+const (
+	ownerIndex     = "owner~assetID"
+	ownerOrgIndex  = "ownerOrg~assetID"
+	assetTypeIndex = "assetType~assetID"
+	// expirationIndex lists the assets that are not consumed by expiration date, the date in
+	// expirationIndexLayout so that the keys sort in the order of the dates
+	expirationIndex = "expiration~assetID"
+	// expirationIndexBuiltObjectType marks that IndexAssetExpirations has indexed the assets written
+	// before the expiration index existed
+	expirationIndexBuiltObjectType = "ExpirationIndexBuilt"
+)
+
+// expirationIndexLayout is RFC 3339 in UTC with a fixed number of digits for the fraction of a second
+const expirationIndexLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// indexValue is stored under every index key, the key itself holds the information
+var indexValue = []byte{0x00}
+
+// GetAssetsByOwnerIndex returns the assets held by an owner, the client identity returned by getSubmittingClientIdentity
+func (s *QueryContract) GetAssetsByOwnerIndex(ctx contractapi.TransactionContextInterface, owner string) ([]*Asset, error) {
+	return s.getAssetsByIndex(ctx, ownerIndex, owner)
+}
+
+// GetAssetsByTypeIndex returns the assets of an asset type
+func (s *QueryContract) GetAssetsByTypeIndex(ctx contractapi.TransactionContextInterface, assetType string) ([]*Asset, error) {
+	return s.getAssetsByIndex(ctx, assetTypeIndex, assetType)
+}
+
+// GetAssetsByOrgIndex returns the assets held by an org
+func (s *QueryContract) GetAssetsByOrgIndex(ctx contractapi.TransactionContextInterface, ownerOrg string) ([]*Asset, error) {
+	return s.getAssetsByIndex(ctx, ownerOrgIndex, ownerOrg)
+}
+
+// getAssetsByIndex reads the assets whose index entries start with value
+func (s *SmartContract) getAssetsByIndex(ctx contractapi.TransactionContextInterface, index string, value string) ([]*Asset, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{value})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	assets := []*Asset{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) != 2 {
+			return nil, fmt.Errorf("index key %q is not valid", queryResponse.Key)
+		}
+
+		asset, err := s.readAsset(ctx, compositeKeyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+
+	return assets, nil
+}
+
+// assetIndexKeys returns the index keys of an asset
+func assetIndexKeys(ctx contractapi.TransactionContextInterface, asset *Asset) ([]string, error) {
+	entries := [][]string{
+		{ownerIndex, asset.Owner},
+		{ownerOrgIndex, asset.OwnerOrg},
+		{assetTypeIndex, asset.AssetType},
+	}
+
+	keys := make([]string, 0, len(entries)+1)
+	for _, entry := range entries {
+		key, err := ctx.GetStub().CreateCompositeKey(entry[0], []string{entry[1], asset.ID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create composite key: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	if !asset.Consumed {
+		key, err := expirationIndexKey(ctx, asset)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// expirationIndexKey returns the entry of an asset in the expiration index
+func expirationIndexKey(ctx contractapi.TransactionContextInterface, asset *Asset) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(expirationIndex, []string{asset.ExpirationDate.UTC().Format(expirationIndexLayout), asset.ID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return key, nil
+}
+
+// updateAssetIndexes replaces the index entries of previous, the stored version of an asset,
+// with the ones of current. Either can be nil when the asset is created or deleted.
+func updateAssetIndexes(ctx contractapi.TransactionContextInterface, previous *Asset, current *Asset) error {
+	var currentKeys []string
+	if current != nil {
+		var err error
+		currentKeys, err = assetIndexKeys(ctx, current)
+		if err != nil {
+			return err
+		}
+	}
+	kept := make(map[string]bool)
+	for _, key := range currentKeys {
+		kept[key] = true
+	}
+
+	if previous != nil {
+		previousKeys, err := assetIndexKeys(ctx, previous)
+		if err != nil {
+			return err
+		}
+		for _, key := range previousKeys {
+			if kept[key] {
+				continue
+			}
+			err = ctx.GetStub().DelState(key)
+			if err != nil {
+				return fmt.Errorf("failed to delete index entry: %v", err)
+			}
+		}
+	}
+	for _, key := range currentKeys {
+		err := ctx.GetStub().PutState(key, indexValue)
+		if err != nil {
+			return fmt.Errorf("failed to put index entry: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// assetObjectType is the prefix of the keys assets are stored under. Assets written by earlier
+// versions of the chaincode are stored under their raw ID until MigrateAssetKeys moves them.
+const assetObjectType = "Asset"
+
+// AssetMigrationResult reports a batch of MigrateAssetKeys
+type AssetMigrationResult struct {
+	Migrated []string `json:"migrated"`
+	Bookmark string   `json:"bookmark"`
+}
+
+// MigrateAssetKeys moves at most batchSize assets stored under their raw ID to the Asset~ID key,
+// starting at bookmark. Pass an empty bookmark for the first batch and the returned bookmark for
+// the next one, an empty bookmark in the result means every asset has been migrated.
+// The history of an asset before the migration stays under its raw ID and is still returned by GetAssetHistory.
+func (s *AssetContract) MigrateAssetKeys(ctx contractapi.TransactionContextInterface, batchSize int, bookmark string) (*AssetMigrationResult, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batchSize must be a positive number")
+	}
+
+	// paginated queries are not allowed in transactions that write, so the batch is cut here.
+	// Composite keys are never part of a range query, so every key in the range is a raw asset ID.
+	resultsIterator, err := ctx.GetStub().GetStateByRange(bookmark, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &AssetMigrationResult{Migrated: []string{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if len(result.Migrated) == batchSize {
+			result.Bookmark = queryResponse.Key
+			break
+		}
+
+		asset, err := decodeAsset(ctx, queryResponse.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", queryResponse.Key, err)
+		}
+		if asset.ID != queryResponse.Key {
+			return nil, fmt.Errorf("key %s holds asset %s", queryResponse.Key, asset.ID)
+		}
+
+		// putAsset stores it under Asset~ID, removes the raw ID and indexes it
+		err = putAsset(ctx, asset)
+		if err != nil {
+			return nil, err
+		}
+		result.Migrated = append(result.Migrated, asset.ID)
+	}
+
+	log.Printf("MigrateAssetKeys: %d assets, next %q", len(result.Migrated), result.Bookmark)
+	return result, nil
+}
+
+// assetKey returns the key an asset is stored under
+func assetKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(assetObjectType, []string{id})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return key, nil
+}
+
+// getAssetState returns the stored asset JSON, nil if there is no such asset. legacy reports
+// that the asset is still stored under its raw ID.
+func getAssetState(ctx contractapi.TransactionContextInterface, id string) (assetJSON []byte, legacy bool, err error) {
+	key, err := assetKey(ctx, id)
+	if err != nil {
+		return nil, false, err
+	}
+	assetJSON, err = ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if assetJSON != nil {
+		return assetJSON, false, nil
+	}
+
+	assetJSON, err = ctx.GetStub().GetState(id)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	return assetJSON, assetJSON != nil, nil
+}
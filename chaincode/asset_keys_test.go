@@ -0,0 +1,99 @@
+package chaincode_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+	"phase2/chaincode/mocks"
+)
+
+func TestMigrateAssetKeys(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+
+	// assets written by an earlier version of the chaincode, under their raw ID
+	for i := 1; i <= 5; i++ {
+		id := fmt.Sprintf("asset%d", i)
+		err := stub.Submit(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return ctx.GetStub().PutState(id, []byte(fmt.Sprintf(
+				`{"ID":%q,"assetType":"apples","color":"red","weight":10,"owner":"FarmerO","ownerOrg":"Org1MSP"}`, id)))
+		})
+		require.NoError(t, err)
+	}
+
+	listed := func() []*chaincode.Asset {
+		var assets []*chaincode.Asset
+		err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			assets, err = sc.GetAllAssets(ctx)
+			return err
+		})
+		require.NoError(t, err)
+		return assets
+	}
+	require.Empty(t, listed(), "assets under raw IDs are not listed")
+	require.Equal(t, "asset1", readAsset(t, stub, "asset1").ID, "but they can still be read")
+
+	migrate := func(batchSize int, bookmark string) (*chaincode.AssetMigrationResult, error) {
+		var result *chaincode.AssetMigrationResult
+		err := stub.Submit(admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			result, err = sc.MigrateAssetKeys(ctx, batchSize, bookmark)
+			return err
+		})
+		return result, err
+	}
+
+	run(t, stub, []step{
+		{"not an admin", farmer, nil, mocks.Invoke(chaincode.NewAssetContract(), "MigrateAssetKeys", func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.MigrateAssetKeys(ctx, 2, "")
+			return err
+		}), "not authorized to call asset:MigrateAssetKeys"},
+		{"updating an asset moves it to the new key", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.UpdateAsset(ctx, "asset5", "green", 10)
+		}, ""},
+	})
+
+	var migrated []string
+	bookmark := ""
+	for batches := 0; ; batches++ {
+		require.Less(t, batches, 5)
+		result, err := migrate(2, bookmark)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(result.Migrated), 2)
+		migrated = append(migrated, result.Migrated...)
+		bookmark = result.Bookmark
+		if bookmark == "" {
+			break
+		}
+	}
+	require.Equal(t, []string{"asset1", "asset2", "asset3", "asset4"}, migrated)
+	require.Len(t, listed(), 5)
+	require.Equal(t, "green", readAsset(t, stub, "asset5").Color)
+
+	result, err := migrate(2, "")
+	require.NoError(t, err)
+	require.Empty(t, result.Migrated, "nothing is left to migrate")
+
+	err = stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		raw, err := ctx.GetStub().GetState("asset1")
+		require.NoError(t, err)
+		require.Nil(t, raw, "the raw ID is deleted")
+
+		history, err := sc.GetAssetHistory(ctx, "asset1")
+		require.NoError(t, err)
+		require.Len(t, history, 3, "write under the new key, delete and write under the raw ID")
+		require.False(t, history[0].IsDelete)
+		require.True(t, history[1].IsDelete)
+		require.Equal(t, "asset1", history[2].Record.ID)
+
+		indexed, err := sc.GetAssetsByTypeIndex(ctx, "apples")
+		require.Len(t, indexed, 5, "migrated assets are indexed")
+		return err
+	})
+	require.NoError(t, err)
+}
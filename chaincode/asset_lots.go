@@ -0,0 +1,240 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SplitAsset repackages a lot into smaller lots. The weights of the new lots are in unit, kg, g or lb,
+// and are converted to the unit of the parent. They must add up to the weight of the parent, which is
+// kept on the ledger marked as consumed.
+func (s *AssetContract) SplitAsset(ctx contractapi.TransactionContextInterface, assetID string, childIDs []string, weights []float64, unit string) error {
+	parent, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	err = s.verifyAssetOwner(ctx, parent)
+	if err != nil {
+		return err
+	}
+	err = verifyNotConsumed(parent)
+	if err != nil {
+		return err
+	}
+	err = verifyNotExpired(ctx, parent)
+	if err != nil {
+		return err
+	}
+	err = s.verifyNoOpenAuction(ctx, assetID)
+	if err != nil {
+		return err
+	}
+
+	if len(childIDs) < 2 {
+		return fmt.Errorf("asset %s must be split into at least two lots", assetID)
+	}
+	if len(childIDs) != len(weights) {
+		return fmt.Errorf("got %d lot IDs but %d weights", len(childIDs), len(weights))
+	}
+	childWeights := make([]Quantity, len(weights))
+	total := Quantity{Unit: parent.Weight.Unit}
+	for i, weight := range weights {
+		if weight <= 0 {
+			return fmt.Errorf("weight of lot %s must be positive", childIDs[i])
+		}
+		childWeights[i], err = Quantity{Value: weight, Unit: unit}.In(parent.Weight.Unit)
+		if err != nil {
+			return fmt.Errorf("weight of lot %s: %v", childIDs[i], err)
+		}
+		total, err = total.Plus(childWeights[i])
+		if err != nil {
+			return err
+		}
+	}
+	cmp, err := total.Compare(parent.Weight)
+	if err != nil {
+		return err
+	}
+	if cmp != 0 {
+		return fmt.Errorf("weights of the new lots add up to %v but asset %s weighs %v", total, assetID, parent.Weight)
+	}
+	product, err := s.getProduct(ctx, parent.AssetType)
+	if err != nil {
+		return err
+	}
+	for _, weight := range childWeights {
+		err = product.validateAsset(parent.Color, weight)
+		if err != nil {
+			return err
+		}
+	}
+	err = s.verifyNewAssetIDs(ctx, childIDs)
+	if err != nil {
+		return err
+	}
+
+	creatorDN, err := s.getSubmittingClientDN(ctx)
+	if err != nil {
+		return err
+	}
+	timestamp, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, childID := range childIDs {
+		child := &Asset{
+			AssetType:      parent.AssetType,
+			ID:             childID,
+			Color:          parent.Color,
+			Weight:         childWeights[i],
+			Owner:          parent.Owner,
+			OwnerOrg:       parent.OwnerOrg,
+			Timestamp:      timestamp,
+			Creator:        creatorDN,
+			ExpirationDate: parent.ExpirationDate,
+			Parents:        []string{parent.ID},
+
+			ShelfLifeExtendedDays: parent.ShelfLifeExtendedDays,
+		}
+		err = putAsset(ctx, child)
+		if err != nil {
+			return err
+		}
+	}
+
+	parent.Consumed = true
+	parent.Children = append(parent.Children, childIDs...)
+	log.Printf("SplitAsset: %v into %v", parent.ID, childIDs)
+	return putAsset(ctx, parent)
+}
+
+// MergeAssets repackages lots of the same asset type into a single new lot of the given color.
+// The merged lot weighs as much as its parents together and expires with the first of them.
+func (s *AssetContract) MergeAssets(ctx contractapi.TransactionContextInterface, newID string, assetIDs []string, color string) error {
+	if len(assetIDs) < 2 {
+		return fmt.Errorf("at least two assets are needed for a merge")
+	}
+	err := s.verifyNewAssetIDs(ctx, []string{newID})
+	if err != nil {
+		return err
+	}
+
+	var parents []*Asset
+	seen := make(map[string]bool)
+	for _, assetID := range assetIDs {
+		if seen[assetID] {
+			return fmt.Errorf("asset %s is listed more than once", assetID)
+		}
+		seen[assetID] = true
+
+		parent, err := s.readAsset(ctx, assetID)
+		if err != nil {
+			return err
+		}
+		err = s.verifyAssetOwner(ctx, parent)
+		if err != nil {
+			return err
+		}
+		err = verifyNotConsumed(parent)
+		if err != nil {
+			return err
+		}
+		err = verifyNotExpired(ctx, parent)
+		if err != nil {
+			return err
+		}
+		err = s.verifyNoOpenAuction(ctx, assetID)
+		if err != nil {
+			return err
+		}
+		if len(parents) > 0 && parent.AssetType != parents[0].AssetType {
+			return fmt.Errorf("cannot merge %s of asset %s with %s of asset %s", parent.AssetType, parent.ID, parents[0].AssetType, parents[0].ID)
+		}
+		if len(parents) > 0 && parent.Weight.Unit != parents[0].Weight.Unit {
+			return fmt.Errorf("cannot merge asset %s weighed in %s with asset %s weighed in %s", parent.ID, parent.Weight.Unit, parents[0].ID, parents[0].Weight.Unit)
+		}
+		parents = append(parents, parent)
+	}
+
+	weight := Quantity{Unit: parents[0].Weight.Unit}
+	for _, parent := range parents {
+		weight, err = weight.Plus(parent.Weight)
+		if err != nil {
+			return err
+		}
+	}
+	product, err := s.getProduct(ctx, parents[0].AssetType)
+	if err != nil {
+		return err
+	}
+	err = product.validateAsset(color, weight)
+	if err != nil {
+		return err
+	}
+
+	creatorDN, err := s.getSubmittingClientDN(ctx)
+	if err != nil {
+		return err
+	}
+	timestamp, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	merged := &Asset{
+		AssetType:      parents[0].AssetType,
+		ID:             newID,
+		Color:          color,
+		Weight:         weight,
+		Owner:          parents[0].Owner,
+		OwnerOrg:       parents[0].OwnerOrg,
+		Timestamp:      timestamp,
+		Creator:        creatorDN,
+		ExpirationDate: parents[0].ExpirationDate,
+		Parents:        assetIDs,
+	}
+	for _, parent := range parents {
+		if parent.ExpirationDate.Before(merged.ExpirationDate) {
+			merged.ExpirationDate = parent.ExpirationDate
+		}
+		if parent.ShelfLifeExtendedDays > merged.ShelfLifeExtendedDays {
+			merged.ShelfLifeExtendedDays = parent.ShelfLifeExtendedDays
+		}
+
+		parent.Consumed = true
+		parent.Children = append(parent.Children, newID)
+		err = putAsset(ctx, parent)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("MergeAssets: %v into %v", assetIDs, newID)
+	return putAsset(ctx, merged)
+}
+
+// verifyNewAssetIDs checks that the IDs of new lots are distinct and not in use
+func (s *SmartContract) verifyNewAssetIDs(ctx contractapi.TransactionContextInterface, ids []string) error {
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if id == "" {
+			return fmt.Errorf("asset ID must be a non-empty string")
+		}
+		if seen[id] {
+			return fmt.Errorf("asset ID %s is listed more than once", id)
+		}
+		seen[id] = true
+
+		exists, err := s.assetExists(ctx, id)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("the asset %s already exists", id)
+		}
+	}
+	return nil
+}
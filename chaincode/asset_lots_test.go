@@ -0,0 +1,154 @@
+package chaincode_test
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+)
+
+func TestSplitAndMergeAssets(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+
+	split := func(id string, childIDs []string, weights []float64) txFunc {
+		return func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SplitAsset(ctx, id, childIDs, weights, "kg")
+		}
+	}
+	merge := func(newID string, ids []string) txFunc {
+		return func(ctx contractapi.TransactionContextInterface) error {
+			return sc.MergeAssets(ctx, newID, ids, "red")
+		}
+	}
+
+	run(t, stub, []step{
+		{"farmer creates apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"farmer creates more apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset2", "green", 10, "apples")
+		}, ""},
+		{"farmer creates grapes", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset3", "white", 5, "grapes")
+		}, ""},
+		{"not the owner", farmer2, nil, split("asset1", []string{"a", "b"}, []float64{5, 10}), "does not own asset"},
+		{"single lot", farmer, nil, split("asset1", []string{"a"}, []float64{15}), "at least two lots"},
+		{"weights do not add up", farmer, nil, split("asset1", []string{"a", "b"}, []float64{5, 5}), "add up to 10"},
+		{"negative weight", farmer, nil, split("asset1", []string{"a", "b"}, []float64{20, -5}), "must be positive"},
+		{"duplicate lot IDs", farmer, nil, split("asset1", []string{"a", "a"}, []float64{5, 10}), "more than once"},
+		{"existing lot ID", farmer, nil, split("asset1", []string{"a", "asset2"}, []float64{5, 10}), "already exists"},
+		{"split 15 kg into 5+5+5", farmer, nil, split("asset1", []string{"asset1a", "asset1b", "asset1c"}, []float64{5, 5, 5}), ""},
+		{"parent cannot be split again", farmer, nil, split("asset1", []string{"x", "y"}, []float64{5, 10}), "repackaged"},
+		{"parent cannot be updated", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.UpdateAsset(ctx, "asset1", "green", 15)
+		}, "repackaged"},
+		{"parent cannot be deleted", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.DeleteAsset(ctx, "asset1")
+		}, "repackaged"},
+		{"parent cannot be sold", farmer, price("100", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, "repackaged"},
+		{"different asset types", farmer, nil, merge("mixed", []string{"asset1a", "asset3"}), "cannot merge grapes"},
+		{"consumed parent", farmer, nil, merge("merged", []string{"asset1", "asset2"}), "repackaged"},
+		{"single asset", farmer, nil, merge("merged", []string{"asset2"}), "at least two"},
+		{"merge 5+10 kg", farmer, nil, merge("merged", []string{"asset1b", "asset2"}), ""},
+	})
+
+	child := readAsset(t, stub, "asset1a")
+	require.Equal(t, kg(5), child.Weight)
+	require.Equal(t, "apples", child.AssetType)
+	require.Equal(t, "FarmerO", child.Owner)
+	require.Equal(t, []string{"asset1"}, child.Parents)
+
+	parent := readAsset(t, stub, "asset1")
+	require.True(t, parent.Consumed)
+	require.Equal(t, kg(15), parent.Weight)
+	require.Equal(t, []string{"asset1a", "asset1b", "asset1c"}, parent.Children)
+
+	merged := readAsset(t, stub, "merged")
+	require.Equal(t, kg(15), merged.Weight)
+	require.Equal(t, "red", merged.Color)
+	require.Equal(t, []string{"asset1b", "asset2"}, merged.Parents)
+	require.Equal(t, parent.ExpirationDate, merged.ExpirationDate, "merged lot expires with its oldest parent")
+
+	var lineage *chaincode.AssetLineage
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		lineage, err = sc.GetAssetLineage(ctx, "merged")
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, "merged", lineage.Asset.ID)
+	require.Len(t, lineage.Parents, 2)
+	require.Equal(t, "asset1b", lineage.Parents[0].Asset.ID)
+	require.Equal(t, "asset1", lineage.Parents[0].Parents[0].Asset.ID)
+	require.Empty(t, lineage.Parents[0].Parents[0].Parents)
+	require.Equal(t, "asset2", lineage.Parents[1].Asset.ID)
+	require.Empty(t, lineage.Parents[1].Parents)
+}
+
+func TestSplitLotIsTradable(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"farmer splits", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SplitAsset(ctx, "asset1", []string{"asset1a", "asset1b"}, []float64{10, 5}, "kg")
+		}, ""},
+		{"farmer asks 40", farmer, price("40", 5), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1b")
+		}, ""},
+		{"retailer bids 40", retailer, price("40", 5), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AgreeToBuy(ctx, "asset1b")
+		}, ""},
+		{"retailer cannot request the parent", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, "repackaged"},
+		{"retailer requests the lot", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1b")
+		}, ""},
+		{"farmer transfers the lot", farmer, transferTo(t, "asset1b", retailer), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferRequestedAsset(ctx)
+		}, ""},
+	})
+	require.Equal(t, "RetailerO", readAsset(t, stub, "asset1b").Owner)
+	require.Equal(t, "FarmerO", readAsset(t, stub, "asset1a").Owner)
+}
+
+func TestSplitAfterPartialSale(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+
+	run(t, stub, []step{
+		{"farmer creates 15 kg lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"farmer asks for 5 kg", farmer, price("10", 5), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, ""},
+		{"retailer bids for 5 kg", retailer, price("10", 5), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AgreeToBuy(ctx, "asset1")
+		}, ""},
+		{"retailer requests 5 kg", retailer, quantity(nil, "5"), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, ""},
+		{"farmer sells 5 kg", farmer, transferTo(t, "asset1", retailer), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferRequestedAsset(ctx)
+		}, ""},
+	})
+	sold := readAsset(t, stub, "asset1").Children
+
+	run(t, stub, []step{
+		{"farmer splits the rest", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SplitAsset(ctx, "asset1", []string{"asset1a", "asset1b"}, []float64{5000, 5000}, "g")
+		}, ""},
+	})
+
+	parent := readAsset(t, stub, "asset1")
+	require.Equal(t, append(sold, "asset1a", "asset1b"), parent.Children, "the lot sold before the split stays in the lineage")
+}
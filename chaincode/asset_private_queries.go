@@ -4,62 +4,130 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
+// RequestToBuyExists returns true when buyerID has a pending request for the asset on shared collection so we dont redefine it.
+// An expired request can be replaced.
+func (s *QueryContract) RequestToBuyExists(ctx contractapi.TransactionContextInterface, assetID string, buyerID string, sharedCollection string) (bool, error) {
+	return s.requestToBuyExists(ctx, assetID, buyerID, sharedCollection)
+}
 
-// RequestToBuyExists returns true when asset Price exists on shared collection so we dont redefine it
-func (s *SmartContract) RequestToBuyExists(ctx contractapi.TransactionContextInterface, assetID string,sharedCollection string) (bool, error) {
-
-	requestToBuyKey, err := ctx.GetStub().CreateCompositeKey(requestToBuyObjectType, []string{assetID})
+// requestToBuyExists returns true when buyerID has a pending request for the asset on shared collection so we dont redefine it.
+// An expired request can be replaced.
+func (s *SmartContract) requestToBuyExists(ctx contractapi.TransactionContextInterface, assetID string, buyerID string, sharedCollection string) (bool, error) {
+	request, err := s.readRequestToBuy(ctx, assetID, buyerID, sharedCollection)
 	if err != nil {
-		return false, fmt.Errorf("failed to create composite key: %v", err)
+		return false, err
 	}
-	requestJSON, err := ctx.GetStub().GetPrivateData(sharedCollection, requestToBuyKey) // Get price from shared collection
+	if request == nil || request.Status != buyRequestPending {
+		return false, nil
+	}
+	now, err := getTxTime(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to read RequestToBuyObject: %v", err)
+		return false, err
 	}
 
-	return requestJSON != nil, nil
+	return !request.expiredAt(now), nil
+}
+
+// ReadRequestToBuy gets the request of buyerID for the asset from collection
+func (s *QueryContract) ReadRequestToBuy(ctx contractapi.TransactionContextInterface, assetID string, buyerID string, sharedCollection string) (*RequestToBuyObject, error) {
+	return s.readRequestToBuy(ctx, assetID, buyerID, sharedCollection)
 }
 
-// ReadRequestToBuy gets the buyer's identity from the transfer request from collection
-func (s *SmartContract) ReadRequestToBuy(ctx contractapi.TransactionContextInterface, assetID string, sharedCollection string) (*RequestToBuyObject, error) {
-	//log.Printf("ReadRequestToBuy: collection %v, ID %v", assetCollection, assetID)
-	// composite key for RequestToBuyObject of this asset
-	transferAgreeKey, err := ctx.GetStub().CreateCompositeKey(requestToBuyObjectType, []string{assetID})
+// readRequestToBuy gets the request of buyerID for the asset from collection
+func (s *SmartContract) readRequestToBuy(ctx contractapi.TransactionContextInterface, assetID string, buyerID string, sharedCollection string) (*RequestToBuyObject, error) {
+	// composite key for RequestToBuyObject of this asset and buyer
+	requestKey, err := ctx.GetStub().CreateCompositeKey(requestToBuyObjectType, []string{assetID, buyerID})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create composite key: %v", err)
 	}
 
-	// clientMSPID,err:=ctx.GetClientIdentity().GetMSPID()
-	// if err != nil {
-	// 	return nil,fmt.Errorf("failed getting the client's MSPID: %v", err)
-	// }
-
-	// temp:=assetCollection
-	// if clientMSPID =="Org2MSP"{
-	// 	temp=assetCollection23
-	// }
-	log.Printf("ReadRequestToBuy: collection %v, ID %v", sharedCollection, assetID)
-	buyerIdentity, err := ctx.GetStub().GetPrivateData(sharedCollection, transferAgreeKey) // Get the state from world state
+	log.Printf("ReadRequestToBuy: collection %v, ID %v, buyer %v", sharedCollection, assetID, buyerID)
+	requestJSON, err := ctx.GetStub().GetPrivateData(sharedCollection, requestKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read RequestToBuyObject: %v", err)
 	}
-	if buyerIdentity == nil {
-		log.Printf("RequestToBuyObject for %v does not exist", assetID)
+	if requestJSON == nil {
+		log.Printf("RequestToBuyObject of %v for %v does not exist", buyerID, assetID)
 		return nil, nil
 	}
-	request := &RequestToBuyObject{
-		ID:      assetID,
-		BuyerID: string(buyerIdentity),
+
+	var request RequestToBuyObject
+	err = json.Unmarshal(requestJSON, &request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	return &request, nil
+}
+
+// ListBuyRequests returns the requests made to the current owner of an asset, from every collection
+// the owner's org shares with other orgs, so that the owner can choose the buyer to transfer to
+func (s *QueryContract) ListBuyRequests(ctx contractapi.TransactionContextInterface, assetID string) ([]*RequestToBuyObject, error) {
+	asset, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.verifyAssetOwner(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	collections, err := s.sharedCollectionsOf(ctx, asset.OwnerOrg)
+	if err != nil {
+		return nil, err
+	}
+	requests := []*RequestToBuyObject{}
+	for _, collection := range collections {
+		collectionRequests, err := getBuyRequests(ctx, collection, assetID)
+		if err != nil {
+			return nil, err
+		}
+		for _, request := range collectionRequests {
+			if request.Seller == asset.Owner && request.SellerOrg == asset.OwnerOrg {
+				requests = append(requests, request)
+			}
+		}
 	}
-	return request, nil
+
+	return requests, nil
 }
 
+// getBuyRequests returns the requests in a shared collection whose key starts with keyAttributes,
+// the requests for an asset when the asset ID is given
+func getBuyRequests(ctx contractapi.TransactionContextInterface, sharedCollection string, keyAttributes ...string) ([]*RequestToBuyObject, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(sharedCollection, requestToBuyObjectType, keyAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read buy requests from %s: %v", sharedCollection, err)
+	}
+	defer resultsIterator.Close()
+
+	var requests []*RequestToBuyObject
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var request RequestToBuyObject
+		err = json.Unmarshal(queryResponse.Value, &request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal buy request %s: %v", queryResponse.Key, err)
+		}
+		requests = append(requests, &request)
+	}
+
+	return requests, nil
+}
 
 // ReadAssetPrivateDetails reads the asset private details in organization specific collection
-func (s *SmartContract) ReadAssetPrivateDetails(ctx contractapi.TransactionContextInterface, collection string, assetID string) (*AssetPrivateDetails, error) {
+func (s *QueryContract) ReadAssetPrivateDetails(ctx contractapi.TransactionContextInterface, collection string, assetID string) (*AssetPrivateDetails, error) {
 	log.Printf("ReadAssetPrivateDetails: collection %v, ID %v", collection, assetID)
 	assetDetailsJSON, err := ctx.GetStub().GetPrivateData(collection, assetID) // Get the asset from chaincode state
 	if err != nil {
@@ -81,20 +149,32 @@ func (s *SmartContract) ReadAssetPrivateDetails(ctx contractapi.TransactionConte
 
 /*=========================Phase 3 =========================================*/
 
-func (s *SmartContract) GetAssetSalesPrice(ctx contractapi.TransactionContextInterface, assetID string) (string, error) {
-	return getAssetPrice(ctx, assetID, typeAssetForSale)
+func (s *QueryContract) GetAssetSalesPrice(ctx contractapi.TransactionContextInterface, assetID string) (string, error) {
+	assetPriceKey, err := askKey(ctx, assetID)
+	if err != nil {
+		return "", err
+	}
+	return getAssetPrice(ctx, assetID, assetPriceKey)
 }
 
-// GetAssetBidPrice returns the bid price
-func (s *SmartContract) GetAssetBidPrice(ctx contractapi.TransactionContextInterface, assetID string) (string, error) {
-	return getAssetPrice(ctx, assetID, typeAssetBid)
+// GetAssetBidPrice returns the bid price of the caller
+func (s *QueryContract) GetAssetBidPrice(ctx contractapi.TransactionContextInterface, assetID string) (string, error) {
+	buyerID, err := s.getSubmittingClientIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+	assetBidKey, err := bidKey(ctx, assetID, buyerID)
+	if err != nil {
+		return "", err
+	}
+	return getAssetPrice(ctx, assetID, assetBidKey)
 }
 
-// getAssetPrice gets the bid or ask price from caller's implicit private data collection
-func getAssetPrice(ctx contractapi.TransactionContextInterface, assetID string, priceType string) (string, error) {
+// getAssetPrice gets the bid or ask price stored under assetPriceKey from caller's implicit private data collection
+func getAssetPrice(ctx contractapi.TransactionContextInterface, assetID string, assetPriceKey string) (string, error) {
 	err := verifyClientOrgMatchesPeerOrg(ctx)
 	if err != nil {
-		return "",fmt.Errorf("TransferAsset cannot be performed: Error %v", err)
+		return "", fmt.Errorf("price of %s cannot be read: Error %v", assetID, err)
 	}
 
 	collection, err := buildCollectionName(ctx)
@@ -102,11 +182,6 @@ func getAssetPrice(ctx contractapi.TransactionContextInterface, assetID string,
 		return "", err
 	}
 
-	assetPriceKey, err := ctx.GetStub().CreateCompositeKey(priceType, []string{assetID})
-	if err != nil {
-		return "", fmt.Errorf("failed to create composite key: %v", err)
-	}
-
 	price, err := ctx.GetStub().GetPrivateData(collection, assetPriceKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to read asset price from implicit private data collection: %v", err)
@@ -118,81 +193,71 @@ func getAssetPrice(ctx contractapi.TransactionContextInterface, assetID string,
 	return string(price), nil
 }
 
-
-
-
-
-/*========================================END OF PHASE 3===================================*/
-// =======Rich queries =========================================================================
-// Two examples of rich queries are provided below (parameterized query and ad hoc query).
-// Rich queries pass a query string to the state database.
-// Rich queries are only supported by state database implementations
-//  that support rich query (e.g. CouchDB).
-// The query string is in the syntax of the underlying state database.
-// With rich queries there is no guarantee that the result set hasn't changed between
-//  endorsement time and commit time, aka 'phantom reads'.
-// Therefore, rich queries should not be used in update transactions, unless the
-// application handles the possibility of result set changes between endorsement and commit time.
-// Rich queries can be used for point-in-time queries against a peer.
-// ============================================================================================
-
-// ===== Example: Parameterized rich query =================================================
-
-// QueryAssetByOwner queries for assets based on assetType, owner.
-// This is an example of a parameterized query where the query logic is baked into the chaincode,
-// and accepting a single query parameter (owner).
-// Only available on state databases that support rich query (e.g. CouchDB)
-// =========================================================================================
-func (s *SmartContract) QueryAssetByOwner(ctx contractapi.TransactionContextInterface, assetType string, owner string) ([]*Asset, error) {
-
-	queryString := fmt.Sprintf("{\"selector\":{\"objectType\":\"%v\",\"owner\":\"%v\"}}", assetType, owner)
-
-	queryResults, err := s.getQueryResultForQueryString(ctx, queryString)
+// ListMyReceipts returns the sale and purchase receipts in the caller's implicit private data collection
+func (s *QueryContract) ListMyReceipts(ctx contractapi.TransactionContextInterface) ([]*Receipt, error) {
+	err := verifyClientOrgMatchesPeerOrg(ctx)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("ListMyReceipts cannot be performed: Error %v", err)
 	}
-	return queryResults, nil
-}
-
-// QueryAssets uses a query string to perform a query for assets.
-// Query string matching state database syntax is passed in and executed as is.
-// Supports ad hoc queries that can be defined at runtime by the client.
-// If this is not desired, follow the QueryAssetByOwner example for parameterized queries.
-// Only available on state databases that support rich query (e.g. CouchDB)
-func (s *SmartContract) QueryAssets(ctx contractapi.TransactionContextInterface, queryString string) ([]*Asset, error) {
 
-	queryResults, err := s.getQueryResultForQueryString(ctx, queryString)
+	collection, err := buildCollectionName(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return queryResults, nil
-}
-
-// getQueryResultForQueryString executes the passed in query string.
-func (s *SmartContract) getQueryResultForQueryString(ctx contractapi.TransactionContextInterface, queryString string) ([]*Asset, error) {
 
-	resultsIterator, err := ctx.GetStub().GetPrivateDataQueryResult(assetCollection, queryString)
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, receiptObjectType, []string{})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to read receipts: %v", err)
 	}
 	defer resultsIterator.Close()
 
-	results := []*Asset{}
-
+	receipts := []*Receipt{}
 	for resultsIterator.HasNext() {
 		response, err := resultsIterator.Next()
 		if err != nil {
 			return nil, err
 		}
-		var asset *Asset
 
-		err = json.Unmarshal(response.Value, &asset)
+		var receipt Receipt
+		err = json.Unmarshal(response.Value, &receipt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
 		}
+		receipts = append(receipts, &receipt)
+	}
+	return receipts, nil
+}
+
+// GetReceipt returns the receipt written by transaction txID for assetID from the caller's implicit private data collection
+func (s *QueryContract) GetReceipt(ctx contractapi.TransactionContextInterface, assetID string, txID string) (*Receipt, error) {
+	err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetReceipt cannot be performed: Error %v", err)
+	}
 
-		results = append(results, asset)
+	collection, err := buildCollectionName(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return results, nil
+
+	receiptKey, err := ctx.GetStub().CreateCompositeKey(receiptObjectType, []string{assetID, txID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	receiptJSON, err := ctx.GetStub().GetPrivateData(collection, receiptKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read receipt: %v", err)
+	}
+	if receiptJSON == nil {
+		return nil, fmt.Errorf("receipt for asset %s in transaction %s does not exist", assetID, txID)
+	}
+
+	var receipt Receipt
+	err = json.Unmarshal(receiptJSON, &receipt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	return &receipt, nil
 }
 
+/*========================================END OF PHASE 3===================================*/
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log"
 	"github.com/golang/protobuf/ptypes"
+	"sort"
 	"time"
 
+	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
@@ -21,10 +23,29 @@ type HistoryQueryResult struct {
 
 // GetAssetHistory returns the chain of custody for an asset since issuance.
 //got it from asset-transfer-ledger-queries
-func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterface, assetID string) ([]HistoryQueryResult, error) {
+func (s *QueryContract) GetAssetHistory(ctx contractapi.TransactionContextInterface, assetID string) ([]HistoryQueryResult, error) {
 	log.Printf("GetAssetHistory: ID %v", assetID)
 
-	resultsIterator, err := ctx.GetStub().GetHistoryForKey(assetID)
+	// newest first: the history under Asset~ID, then the one from before MigrateAssetKeys under the raw ID
+	key, err := assetKey(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	records, err := getAssetHistoryForKey(ctx, key, assetID)
+	if err != nil {
+		return nil, err
+	}
+	legacyRecords, err := getAssetHistoryForKey(ctx, assetID, assetID)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(records, legacyRecords...), nil
+}
+
+// getAssetHistoryForKey returns the modifications of the key an asset is or was stored under
+func getAssetHistoryForKey(ctx contractapi.TransactionContextInterface, key string, assetID string) ([]HistoryQueryResult, error) {
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(key)
 	if err != nil {
 		return nil, err
 	}
@@ -37,14 +58,14 @@ func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterf
 			return nil, err
 		}
 
-		var asset Asset
+		var asset *Asset
 		if len(response.Value) > 0 {
-			err = json.Unmarshal(response.Value, &asset)
+			asset, err = decodeAsset(ctx, response.Value)
 			if err != nil {
 				return nil, err
 			}
 		} else {
-			asset = Asset{
+			asset = &Asset{
 				ID: assetID,
 			}
 		}
@@ -62,7 +83,7 @@ func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterf
 		record := HistoryQueryResult{
 			TxId:      response.TxId,
 			Timestamp: timestamp,
-			Record:    &asset,
+			Record:    asset,
 			IsDelete:  response.IsDelete,
 		}
 		records = append(records, record)
@@ -72,49 +93,262 @@ func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterf
 }
 
 // ReadAsset returns the asset stored in the world state with given id.
-func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, id string) (*Asset, error) {
+func (s *QueryContract) ReadAsset(ctx contractapi.TransactionContextInterface, id string) (*Asset, error) {
+	return s.readAsset(ctx, id)
+}
+
+// readAsset returns the asset stored in the world state with given id.
+func (s *SmartContract) readAsset(ctx contractapi.TransactionContextInterface, id string) (*Asset, error) {
 
-	assetJSON, err := ctx.GetStub().GetState(id)
+	assetJSON, _, err := getAssetState(ctx, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read from world state: %v", err)
+		return nil, err
 	}
 	if assetJSON == nil {
 		return nil, fmt.Errorf("the asset %s does not exist", id)
 	}
 
-	var asset Asset
-	err = json.Unmarshal(assetJSON, &asset)
+	return decodeAsset(ctx, assetJSON)
+}
+
+// GetAllAssets returns all assets found in world state.
+// Assets still stored under their raw ID are only listed once MigrateAssetKeys has moved them.
+func (s *QueryContract) GetAllAssets(ctx contractapi.TransactionContextInterface) ([]*Asset, error) {
+	// partial composite key query with no attributes returns every key
+	// with the Asset prefix and nothing else in the chaincode namespace.
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(assetObjectType, []string{})
 	if err != nil {
 		return nil, err
 	}
+	defer resultsIterator.Close()
 
-	return &asset, nil
+	var assets []*Asset
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		asset, err := decodeAsset(ctx, queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+
+	return assets, nil
+}
+
+// AssetLineage is a node of the ancestry tree of an asset
+type AssetLineage struct {
+	Asset   *Asset          `json:"asset"`
+	Parents []*AssetLineage `json:"parents,omitempty"`
+}
+
+// GetAssetLineage returns the asset together with the lots it was split or merged from,
+// back to the lots that were created by a farmer.
+func (s *QueryContract) GetAssetLineage(ctx contractapi.TransactionContextInterface, assetID string) (*AssetLineage, error) {
+	asset, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+
+	lineage := &AssetLineage{Asset: asset}
+	for _, parentID := range asset.Parents {
+		parent, err := s.GetAssetLineage(ctx, parentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read lineage of %s: %v", assetID, err)
+		}
+		lineage.Parents = append(lineage.Parents, parent)
+	}
+
+	return lineage, nil
 }
 
-// GetAllAssets returns all assets found in world state
-func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface) ([]*Asset, error) {
-	// range query with empty string for startKey and endKey does an
-	// open-ended query of all assets in the chaincode namespace.
-	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+// PaginatedQueryResult structure used for returning paginated query results and metadata
+//got it from asset-transfer-ledger-queries
+type PaginatedQueryResult struct {
+	Records             []*Asset `json:"records"`
+	FetchedRecordsCount int32    `json:"fetchedRecordsCount"`
+	Bookmark            string   `json:"bookmark"`
+}
+
+// GetAllAssetsWithPagination returns a page of at most pageSize assets, starting at bookmark.
+// Pass an empty bookmark for the first page and the returned bookmark for the next one,
+// an empty bookmark in the result means there are no more assets.
+// Works on LevelDB and CouchDB, but only in queries: the peer refuses paginated
+// queries in transactions that write to the ledger.
+func (s *QueryContract) GetAllAssetsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int, bookmark string) (*PaginatedQueryResult, error) {
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(assetObjectType, []string{}, int32(pageSize), bookmark)
 	if err != nil {
 		return nil, err
 	}
 	defer resultsIterator.Close()
 
-	var assets []*Asset
+	assets, err := constructQueryResponseFromIterator(ctx, resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaginatedQueryResult{
+		Records:             assets,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+// QueryAssetsWithPagination runs a rich query on the public state and returns a page of
+// at most pageSize assets, starting at bookmark. Like QueryAssets the query string is passed
+// to the state database as is.
+// Only available on state databases that support rich query (e.g. CouchDB), in queries.
+func (s *QueryContract) QueryAssetsWithPagination(ctx contractapi.TransactionContextInterface, queryString string, pageSize int, bookmark string) (*PaginatedQueryResult, error) {
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetQueryResultWithPagination(queryString, int32(pageSize), bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	assets, err := constructQueryResponseFromIterator(ctx, resultsIterator)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PaginatedQueryResult{
+		Records:             assets,
+		FetchedRecordsCount: responseMetadata.FetchedRecordsCount,
+		Bookmark:            responseMetadata.Bookmark,
+	}, nil
+}
+
+// constructQueryResponseFromIterator constructs a slice of assets from the resultsIterator
+func constructQueryResponseFromIterator(ctx contractapi.TransactionContextInterface, resultsIterator shim.StateQueryIteratorInterface) ([]*Asset, error) {
+	assets := []*Asset{}
 	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+		queryResult, err := resultsIterator.Next()
 		if err != nil {
 			return nil, err
 		}
 
-		var asset Asset
-		err = json.Unmarshal(queryResponse.Value, &asset)
+		asset, err := decodeAsset(ctx, queryResult.Value)
 		if err != nil {
 			return nil, err
 		}
-		assets = append(assets, &asset)
+		assets = append(assets, asset)
 	}
 
 	return assets, nil
-}
\ No newline at end of file
+}
+
+// =======Rich queries =========================================================================
+// Rich queries pass a query string to the state database and run on the public asset state.
+// Rich queries are only supported by state database implementations
+//  that support rich query (e.g. CouchDB).
+// The query string is in the syntax of the underlying state database.
+// With rich queries there is no guarantee that the result set hasn't changed between
+//  endorsement time and commit time, aka 'phantom reads'.
+// Therefore, rich queries should not be used in update transactions, unless the
+// application handles the possibility of result set changes between endorsement and commit time.
+// Rich queries can be used for point-in-time queries against a peer.
+// The indexes they use are in META-INF/statedb/couchdb/indexes.
+// ============================================================================================
+
+// assetDocType is the docType of every asset, other documents in the world state don't have it
+const assetDocType = "asset"
+
+// QueryAssetsByOwner returns the assets held by an owner, the client identity returned by getSubmittingClientIdentity
+func (s *QueryContract) QueryAssetsByOwner(ctx contractapi.TransactionContextInterface, owner string) ([]*Asset, error) {
+	return s.queryAssetsBy(ctx, "owner", owner, "indexOwner")
+}
+
+// QueryAssetsByOwnerOrg returns the assets held by an org
+func (s *QueryContract) QueryAssetsByOwnerOrg(ctx contractapi.TransactionContextInterface, ownerOrg string) ([]*Asset, error) {
+	return s.queryAssetsBy(ctx, "ownerOrg", ownerOrg, "indexOwnerOrg")
+}
+
+// QueryAssetsByType returns the assets of an asset type
+func (s *QueryContract) QueryAssetsByType(ctx contractapi.TransactionContextInterface, assetType string) ([]*Asset, error) {
+	return s.queryAssetsBy(ctx, "assetType", assetType, "indexAssetType")
+}
+
+// QueryAssetsByTimestampRange returns the assets last written in [from, to), oldest first.
+// from and to are RFC 3339 timestamps.
+func (s *QueryContract) QueryAssetsByTimestampRange(ctx contractapi.TransactionContextInterface, from string, to string) ([]*Asset, error) {
+	fromTime, err := time.Parse(time.RFC3339Nano, from)
+	if err != nil {
+		return nil, fmt.Errorf("from must be an RFC 3339 timestamp: %v", err)
+	}
+	toTime, err := time.Parse(time.RFC3339Nano, to)
+	if err != nil {
+		return nil, fmt.Errorf("to must be an RFC 3339 timestamp: %v", err)
+	}
+
+	// Timestamps are stored in UTC as RFC 3339 with the trailing zeros of the fraction left out,
+	// so the state database compares them as strings correctly only down to the second. It selects
+	// the whole seconds of the range, the assets are then filtered and sorted on the parsed time.
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"docType": assetDocType,
+			"timestamp": map[string]interface{}{
+				"$gte": fromTime.UTC().Truncate(time.Second).Format(timestampSecondLayout),
+				"$lt":  toTime.UTC().Truncate(time.Second).Add(time.Second).Format(timestampSecondLayout),
+			},
+		},
+		"sort":      []map[string]string{{"docType": "asc"}, {"timestamp": "asc"}},
+		"use_index": []string{"_design/indexTimestampDoc", "indexTimestamp"},
+	}
+	queryString, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %v", err)
+	}
+	candidates, err := s.getQueryResultForQueryString(ctx, string(queryString))
+	if err != nil {
+		return nil, err
+	}
+
+	assets := []*Asset{}
+	for _, asset := range candidates {
+		if !asset.Timestamp.Before(fromTime) && asset.Timestamp.Before(toTime) {
+			assets = append(assets, asset)
+		}
+	}
+	sort.SliceStable(assets, func(i, j int) bool { return assets[i].Timestamp.Before(assets[j].Timestamp) })
+	return assets, nil
+}
+
+// timestampSecondLayout is the prefix of an RFC 3339 timestamp up to the second, every timestamp
+// within that second compares as a string at least equal to it
+const timestampSecondLayout = "2006-01-02T15:04:05"
+
+// QueryAssets uses a query string to perform a query for assets.
+// Query string matching state database syntax is passed in and executed as is.
+// Supports ad hoc queries that can be defined at runtime by the client.
+// If this is not desired, follow the QueryAssetsByOwner example for parameterized queries.
+// Only available on state databases that support rich query (e.g. CouchDB)
+func (s *QueryContract) QueryAssets(ctx contractapi.TransactionContextInterface, queryString string) ([]*Asset, error) {
+	return s.getQueryResultForQueryString(ctx, queryString)
+}
+
+// queryAssetsBy returns the assets whose field equals value, using the index of the field
+func (s *SmartContract) queryAssetsBy(ctx contractapi.TransactionContextInterface, field string, value string, index string) ([]*Asset, error) {
+	// the query is marshalled rather than formatted, so value cannot change the selector
+	query := map[string]interface{}{
+		"selector":  map[string]interface{}{"docType": assetDocType, field: value},
+		"use_index": []string{"_design/" + index + "Doc", index},
+	}
+	queryString, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %v", err)
+	}
+	return s.getQueryResultForQueryString(ctx, string(queryString))
+}
+
+// getQueryResultForQueryString executes the passed in query string on the world state.
+func (s *SmartContract) getQueryResultForQueryString(ctx contractapi.TransactionContextInterface, queryString string) ([]*Asset, error) {
+	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return constructQueryResponseFromIterator(ctx, resultsIterator)
+}
@@ -0,0 +1,228 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+)
+
+func TestGetAllAssetsWithPagination(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	run(t, stub, []step{
+		{"farmer initializes the ledger", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.InitLedger(ctx)
+		}, ""},
+	})
+
+	page := func(pageSize int, bookmark string) *chaincode.PaginatedQueryResult {
+		var result *chaincode.PaginatedQueryResult
+		err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			result, err = sc.GetAllAssetsWithPagination(ctx, pageSize, bookmark)
+			return err
+		})
+		require.NoError(t, err)
+		return result
+	}
+
+	first := page(4, "")
+	require.EqualValues(t, 4, first.FetchedRecordsCount)
+	require.Len(t, first.Records, 4)
+	require.Equal(t, "asset1", first.Records[0].ID)
+	require.NotEmpty(t, first.Bookmark)
+
+	second := page(4, first.Bookmark)
+	require.EqualValues(t, 2, second.FetchedRecordsCount)
+	require.Equal(t, "asset5", second.Records[0].ID)
+	require.Equal(t, "asset6", second.Records[1].ID)
+	require.Empty(t, second.Bookmark)
+
+	all := page(10, "")
+	require.Len(t, all.Records, 6, "composite keys of the catalog and registry are not assets")
+
+	run(t, stub, []step{
+		{"not in a transaction that writes", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			if err := sc.CreateAsset(ctx, "asset7", "red", 5, "apples"); err != nil {
+				return err
+			}
+			_, err := sc.GetAllAssetsWithPagination(ctx, 4, "")
+			return err
+		}, "not allowed in a read-write transaction"},
+		{"rich queries need CouchDB", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.QueryAssetsWithPagination(ctx, `{"selector":{"assetType":"apples"}}`, 4, "")
+			return err
+		}, "not supported for leveldb"},
+	})
+}
+
+func TestRichQueries(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	stub.RichQueries = true
+	run(t, stub, []step{
+		{"farmer initializes the ledger", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.InitLedger(ctx)
+		}, ""},
+		{"another farmer creates grapes", farmer2, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset7", "red", 10, "grapes")
+		}, ""},
+	})
+	asset7 := readAsset(t, stub, "asset7")
+	require.Equal(t, "asset", asset7.DocType)
+
+	query := func(fn func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error)) []string {
+		var ids []string
+		err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			assets, err := fn(ctx)
+			for _, asset := range assets {
+				ids = append(ids, asset.ID)
+			}
+			return err
+		})
+		require.NoError(t, err)
+		return ids
+	}
+
+	require.Equal(t, []string{"asset7"}, query(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.QueryAssetsByOwner(ctx, asset7.Owner)
+	}))
+	require.Len(t, query(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.QueryAssetsByOwnerOrg(ctx, "Org1MSP")
+	}), 7)
+	require.Empty(t, query(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.QueryAssetsByOwnerOrg(ctx, "Org2MSP")
+	}))
+	require.Equal(t, []string{"asset3", "asset4", "asset5"}, query(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.QueryAssetsByType(ctx, "apples")
+	}), "catalog entries are not assets")
+	require.Equal(t, []string{"asset7"}, query(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.QueryAssetsByTimestampRange(ctx, asset7.Timestamp.Format(time.RFC3339), asset7.Timestamp.Add(time.Minute).Format(time.RFC3339))
+	}))
+	require.Len(t, query(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.QueryAssetsByTimestampRange(ctx, "2021-01-01T00:00:00Z", asset7.Timestamp.Add(time.Minute).Format(time.RFC3339))
+	}), 7)
+	require.Equal(t, []string{"asset6", "asset7"}, query(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.QueryAssets(ctx, `{"selector":{"docType":"asset","assetType":"grapes"}}`)
+	}))
+
+	var result *chaincode.PaginatedQueryResult
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		result, err = sc.QueryAssetsWithPagination(ctx, `{"selector":{"docType":"asset","assetType":"apples"}}`, 2, "")
+		return err
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, result.FetchedRecordsCount)
+	require.NotEmpty(t, result.Bookmark)
+}
+
+func TestCompositeKeyIndexes(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	run(t, stub, []step{
+		{"farmer creates apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 10, "apples")
+		}, ""},
+		{"farmer creates more apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset2", "green", 10, "apples")
+		}, ""},
+		{"farmer creates berries", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset3", "blue", 5, "berries")
+		}, ""},
+		{"farmer asks 40", farmer, price("40", 10), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, ""},
+		{"retailer bids 40", retailer, price("40", 10), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AgreeToBuy(ctx, "asset1")
+		}, ""},
+		{"retailer requests", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, ""},
+		{"farmer transfers", farmer, transferTo(t, "asset1", retailer), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferRequestedAsset(ctx)
+		}, ""},
+		{"farmer deletes the berries", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.DeleteAsset(ctx, "asset3")
+		}, ""},
+	})
+
+	lookup := func(fn func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error)) []string {
+		ids := []string{}
+		err := stub.Evaluate(supermarket, nil, func(ctx contractapi.TransactionContextInterface) error {
+			assets, err := fn(ctx)
+			for _, asset := range assets {
+				ids = append(ids, asset.ID)
+			}
+			return err
+		})
+		require.NoError(t, err)
+		return ids
+	}
+	owner := func(id string) string { return readAsset(t, stub, id).Owner }
+
+	require.Equal(t, []string{"asset1"}, lookup(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.GetAssetsByOwnerIndex(ctx, owner("asset1"))
+	}))
+	require.Equal(t, []string{"asset2"}, lookup(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.GetAssetsByOwnerIndex(ctx, owner("asset2"))
+	}))
+	require.Equal(t, []string{"asset1"}, lookup(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.GetAssetsByOrgIndex(ctx, "Org2MSP")
+	}))
+	require.Equal(t, []string{"asset2"}, lookup(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.GetAssetsByOrgIndex(ctx, "Org1MSP")
+	}))
+	require.Equal(t, []string{"asset1", "asset2"}, lookup(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.GetAssetsByTypeIndex(ctx, "apples")
+	}))
+	require.Empty(t, lookup(func(ctx contractapi.TransactionContextInterface) ([]*chaincode.Asset, error) {
+		return sc.GetAssetsByTypeIndex(ctx, "berries")
+	}), "deleted assets leave the index")
+}
+
+func TestQueryAssetsByTimestampRangeWithinASecond(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	stub.RichQueries = true
+	create := func(id string) txFunc {
+		return func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, id, "red", 10, "apples")
+		}
+	}
+
+	// the timestamps of asset1 and asset3 have a fraction of a second, that of asset2 has none
+	stub.Advance(500 * time.Millisecond)
+	run(t, stub, []step{{"farmer creates asset1", farmer, nil, create("asset1"), ""}})
+	stub.Advance(500 * time.Millisecond)
+	run(t, stub, []step{{"farmer creates asset2", farmer, nil, create("asset2"), ""}})
+	stub.Advance(250 * time.Millisecond)
+	run(t, stub, []step{{"farmer creates asset3", farmer, nil, create("asset3"), ""}})
+	asset1 := readAsset(t, stub, "asset1").Timestamp
+	asset2 := readAsset(t, stub, "asset2").Timestamp
+	asset3 := readAsset(t, stub, "asset3").Timestamp
+	require.NotZero(t, asset1.Nanosecond())
+	require.Zero(t, asset2.Nanosecond())
+
+	query := func(from time.Time, to time.Time) []string {
+		var ids []string
+		err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			assets, err := sc.QueryAssetsByTimestampRange(ctx, from.Format(time.RFC3339Nano), to.Format(time.RFC3339Nano))
+			for _, asset := range assets {
+				ids = append(ids, asset.ID)
+			}
+			return err
+		})
+		require.NoError(t, err)
+		return ids
+	}
+	second := asset1.Truncate(time.Second)
+	require.Equal(t, []string{"asset1"}, query(second, second.Add(time.Second)), "a timestamp with a fraction sorts before its whole second as a string")
+	require.Equal(t, []string{"asset1", "asset2", "asset3"}, query(asset1, asset3.Add(time.Nanosecond)))
+	require.Equal(t, []string{"asset2"}, query(asset2, asset2.Add(time.Millisecond)))
+	require.Equal(t, []string{"asset2", "asset3"}, query(asset1.Add(time.Millisecond), asset3.Add(time.Second)))
+}
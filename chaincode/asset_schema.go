@@ -0,0 +1,178 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// currentAssetSchemaVersion is the schemaVersion putAsset writes. Records without a
+// schemaVersion were written before versioning and are version 0.
+const currentAssetSchemaVersion = 3
+
+// assetUpgrades[v] turns a stored asset of schema version v into version v+1.
+// Append a function and bump currentAssetSchemaVersion when the shape of Asset changes.
+var assetUpgrades = []func(ctx contractapi.TransactionContextInterface, record map[string]interface{}) error{
+	upgradeAssetV0,
+	upgradeAssetV1,
+	upgradeAssetV2,
+}
+
+// upgradeAssetV0 renames OwnerOrg, which was persisted under the field name because of a
+// malformed json tag, and turns sensorData into the free-form string it is now
+func upgradeAssetV0(ctx contractapi.TransactionContextInterface, record map[string]interface{}) error {
+	if ownerOrg, ok := record["OwnerOrg"]; ok {
+		if _, ok := record["ownerOrg"]; !ok {
+			record["ownerOrg"] = ownerOrg
+		}
+		delete(record, "OwnerOrg")
+	}
+
+	switch sensorData := record["sensorData"].(type) {
+	case string:
+	case nil:
+		record["sensorData"] = ""
+	default:
+		sensorDataJSON, err := json.Marshal(sensorData)
+		if err != nil {
+			return fmt.Errorf("failed to marshal sensorData: %v", err)
+		}
+		record["sensorData"] = string(sensorDataJSON)
+	}
+
+	if _, ok := record["docType"]; !ok {
+		record["docType"] = assetDocType
+	}
+	return nil
+}
+
+// upgradeAssetV1 adds the weight unit, which assets took from the product catalog entry of their
+// type until it was stored with them
+func upgradeAssetV1(ctx contractapi.TransactionContextInterface, record map[string]interface{}) error {
+	if unit, _ := record["weightUnit"].(string); unit != "" {
+		return nil
+	}
+	assetType, _ := record["assetType"].(string)
+	product, err := readProduct(ctx, assetType)
+	if err != nil {
+		return err
+	}
+	if product == nil {
+		return fmt.Errorf("product %q is not in the catalog, its weight unit is unknown", assetType)
+	}
+	record["weightUnit"] = product.WeightUnit
+	return nil
+}
+
+// upgradeAssetV2 turns the weight, a whole number in weightUnit, into a quantity
+func upgradeAssetV2(ctx contractapi.TransactionContextInterface, record map[string]interface{}) error {
+	value, _ := record["weight"].(float64)
+	unit, _ := record["weightUnit"].(string)
+	record["weight"] = map[string]interface{}{"value": value, "unit": unit}
+	delete(record, "weightUnit")
+	return nil
+}
+
+// UpgradeAssetRecords rewrites at most batchSize assets stored under an older schema version in the
+// current one, starting at bookmark. Pass an empty bookmark for the first batch and the returned
+// bookmark for the next one, an empty bookmark in the result means every asset is up to date.
+// Assets still stored under their raw ID are upgraded by MigrateAssetKeys.
+func (s *AssetContract) UpgradeAssetRecords(ctx contractapi.TransactionContextInterface, batchSize int, bookmark string) (*AssetMigrationResult, error) {
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batchSize must be a positive number")
+	}
+
+	// paginated queries are not allowed in transactions that write, so the keys before bookmark are skipped here
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(assetObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	result := &AssetMigrationResult{Migrated: []string{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		if queryResponse.Key < bookmark {
+			continue
+		}
+
+		version, err := assetSchemaVersion(queryResponse.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", queryResponse.Key, err)
+		}
+		if version == currentAssetSchemaVersion {
+			continue
+		}
+		if len(result.Migrated) == batchSize {
+			result.Bookmark = queryResponse.Key
+			break
+		}
+
+		asset, err := decodeAsset(ctx, queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		err = putAsset(ctx, asset)
+		if err != nil {
+			return nil, err
+		}
+		result.Migrated = append(result.Migrated, asset.ID)
+	}
+
+	log.Printf("UpgradeAssetRecords: %d assets, next %q", len(result.Migrated), result.Bookmark)
+	return result, nil
+}
+
+// decodeAsset unmarshals a stored asset, upgrading it to the current schema version
+func decodeAsset(ctx contractapi.TransactionContextInterface, assetJSON []byte) (*Asset, error) {
+	var record map[string]interface{}
+	err := json.Unmarshal(assetJSON, &record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+
+	version, err := assetSchemaVersion(assetJSON)
+	if err != nil {
+		return nil, err
+	}
+	if version > currentAssetSchemaVersion {
+		return nil, fmt.Errorf("asset %v has schema version %d, this chaincode only knows up to %d", record["ID"], version, currentAssetSchemaVersion)
+	}
+	if version < currentAssetSchemaVersion {
+		for v := version; v < currentAssetSchemaVersion; v++ {
+			err = assetUpgrades[v](ctx, record)
+			if err != nil {
+				return nil, fmt.Errorf("failed to upgrade asset %v from schema version %d: %v", record["ID"], v, err)
+			}
+		}
+		record["schemaVersion"] = currentAssetSchemaVersion
+		assetJSON, err = json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal upgraded asset: %v", err)
+		}
+	}
+
+	var asset Asset
+	err = json.Unmarshal(assetJSON, &asset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	return &asset, nil
+}
+
+// assetSchemaVersion returns the schema version of a stored asset
+func assetSchemaVersion(assetJSON []byte) (int, error) {
+	var versioned struct {
+		SchemaVersion int `json:"schemaVersion"`
+	}
+	err := json.Unmarshal(assetJSON, &versioned)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	return versioned.SchemaVersion, nil
+}
@@ -0,0 +1,103 @@
+package chaincode_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+	"phase2/chaincode/mocks"
+)
+
+func TestUpgradeAssetRecords(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	stub.RichQueries = true
+
+	// records written before schema versioning: OwnerOrg under the field name, no docType
+	// and sensorData stored as an object
+	for i := 1; i <= 3; i++ {
+		id := fmt.Sprintf("asset%d", i)
+		err := stub.Submit(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			key, err := ctx.GetStub().CreateCompositeKey("Asset", []string{id})
+			require.NoError(t, err)
+			return ctx.GetStub().PutState(key, []byte(fmt.Sprintf(
+				`{"ID":%q,"assetType":"apples","color":"red","weight":10,"owner":"FarmerO","OwnerOrg":"Org1MSP","sensorData":{"temperature":3}}`, id)))
+		})
+		require.NoError(t, err)
+	}
+	run(t, stub, []step{
+		{"farmer creates a current record", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset4", "green", 10, "apples")
+		}, ""},
+	})
+
+	old := readAsset(t, stub, "asset1")
+	require.Equal(t, "Org1MSP", old.OwnerOrg)
+	require.Equal(t, `{"temperature":3}`, old.SensorData)
+	require.Equal(t, "asset", old.DocType)
+	require.Equal(t, 3, old.SchemaVersion)
+	require.Equal(t, kg(10), old.Weight, "old records take the unit of their product")
+
+	byOrg := func() int {
+		var assets []*chaincode.Asset
+		err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			assets, err = sc.QueryAssetsByOwnerOrg(ctx, "Org1MSP")
+			return err
+		})
+		require.NoError(t, err)
+		return len(assets)
+	}
+	require.Equal(t, 1, byOrg(), "old records are stored with OwnerOrg")
+
+	upgrade := func(bookmark string) *chaincode.AssetMigrationResult {
+		var result *chaincode.AssetMigrationResult
+		err := stub.Submit(admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			result, err = sc.UpgradeAssetRecords(ctx, 2, bookmark)
+			return err
+		})
+		require.NoError(t, err)
+		return result
+	}
+	run(t, stub, []step{
+		{"not an admin", farmer, nil, mocks.Invoke(chaincode.NewAssetContract(), "UpgradeAssetRecords", func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.UpgradeAssetRecords(ctx, 2, "")
+			return err
+		}), "not authorized to call asset:UpgradeAssetRecords"},
+	})
+
+	first := upgrade("")
+	require.Equal(t, []string{"asset1", "asset2"}, first.Migrated)
+	require.NotEmpty(t, first.Bookmark)
+	second := upgrade(first.Bookmark)
+	require.Equal(t, []string{"asset3"}, second.Migrated, "current records are skipped")
+	require.Empty(t, second.Bookmark)
+	require.Empty(t, upgrade("").Migrated)
+	require.Equal(t, 4, byOrg())
+
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		history, err := sc.GetAssetHistory(ctx, "asset1")
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		require.Equal(t, `{"temperature":3}`, history[1].Record.SensorData, "history is upgraded too")
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = stub.Submit(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		key, err := ctx.GetStub().CreateCompositeKey("Asset", []string{"asset5"})
+		require.NoError(t, err)
+		return ctx.GetStub().PutState(key, []byte(`{"ID":"asset5","schemaVersion":99}`))
+	})
+	require.NoError(t, err)
+	err = stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.ReadAsset(ctx, "asset5")
+		return err
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "schema version 99")
+}
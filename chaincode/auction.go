@@ -0,0 +1,628 @@
+package chaincode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const auctionObjectType = "Auction"
+const auctionBidObjectType = "AuctionBid"
+const auctionRevealObjectType = "AuctionReveal"
+const auctionBidderObjectType = "AuctionBidder"
+
+const (
+	auctionStatusOpen   = "open"
+	auctionStatusClosed = "closed"
+)
+
+// auctionRevealPeriod is how long after the deadline bids can be revealed. Until it ends only the
+// seller can close the auction, after it anyone can, so a seller cannot keep the asset locked.
+const auctionRevealPeriod = 24 * time.Hour
+
+// Auction is a sealed-bid auction of an asset. Bids stay in the implicit collections of the
+// bidders until the deadline, then the bidders reveal them until RevealUntil and the auction is closed.
+// Closed auctions are kept on the ledger as the published result. Bids are in the minor units of
+// the auction's currency. Each revealed bid is kept under its own key until the auction closes,
+// so that bidders revealing at the same time do not conflict on the auction. Who committed a bid is
+// public, only the price stays sealed, so the close can delete the bids of bidders that never revealed.
+type Auction struct {
+	ID           string         `json:"auctionID"`
+	AssetID      string         `json:"assetID"`
+	Seller       string         `json:"seller"`
+	SellerOrg    string         `json:"sellerOrg"`
+	Deadline     time.Time      `json:"deadline"`
+	RevealUntil  time.Time      `json:"revealUntil"`
+	Currency     string         `json:"currency"`
+	Status       string         `json:"status"`
+	Revealed     []*RevealedBid `json:"revealed"`
+	Unfunded     []*RevealedBid `json:"unfunded,omitempty"`
+	Winner       string         `json:"winner,omitempty"`
+	WinnerOrg    string         `json:"winnerOrg,omitempty"`
+	WinningPrice *Money         `json:"winningPrice,omitempty"`
+	ClosedAt     *time.Time     `json:"closedAt,omitempty"`
+}
+
+// RevealedBid is a bid whose price has been checked against the hash committed before the deadline
+type RevealedBid struct {
+	Bidder     string    `json:"bidder"`
+	BidderOrg  string    `json:"bidderOrg"`
+	Price      int       `json:"price"`
+	TxID       string    `json:"txID"`
+	RevealedAt time.Time `json:"revealedAt"`
+}
+
+// sealedBid is the bid as it is passed in the transient map under "bid". The salt keeps
+// others from guessing the price from its hash, which every peer of the channel has. A bid
+// without a currency is in the currency of the auction.
+type sealedBid struct {
+	Price    *int   `json:"price"`
+	Currency string `json:"currency,omitempty"`
+	Salt     string `json:"salt"`
+}
+
+// OpenAuction puts an asset up for a sealed-bid auction in currency (ISO 4217) that takes bids until
+// deadline (RFC 3339). It returns the ID of the auction.
+func (s *MarketContract) OpenAuction(ctx contractapi.TransactionContextInterface, assetID string, deadline string, currency string) (string, error) {
+	asset, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return "", err
+	}
+	err = s.verifyAssetOwner(ctx, asset)
+	if err != nil {
+		return "", err
+	}
+	err = verifyNotConsumed(asset)
+	if err != nil {
+		return "", err
+	}
+	err = verifyNotExpired(ctx, asset)
+	if err != nil {
+		return "", err
+	}
+	err = s.verifyNoOpenAuction(ctx, assetID)
+	if err != nil {
+		return "", err
+	}
+	err = validateCurrency(currency)
+	if err != nil {
+		return "", err
+	}
+
+	deadlineTime, err := time.Parse(time.RFC3339Nano, deadline)
+	if err != nil {
+		return "", fmt.Errorf("deadline must be an RFC 3339 timestamp: %v", err)
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !deadlineTime.After(txTime) {
+		return "", fmt.Errorf("deadline %v has already passed", deadlineTime)
+	}
+	if !deadlineTime.Before(asset.ExpirationDate) {
+		return "", fmt.Errorf("deadline %v is not before asset %s expires on %v", deadlineTime, assetID, asset.ExpirationDate)
+	}
+
+	auction := &Auction{
+		ID:          ctx.GetStub().GetTxID(),
+		AssetID:     assetID,
+		Seller:      asset.Owner,
+		SellerOrg:   asset.OwnerOrg,
+		Deadline:    deadlineTime.UTC(),
+		RevealUntil: deadlineTime.UTC().Add(auctionRevealPeriod),
+		Currency:    currency,
+		Status:      auctionStatusOpen,
+		Revealed:    []*RevealedBid{},
+	}
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("OpenAuction: %v for %v until %v", auction.ID, assetID, auction.Deadline)
+	return auction.ID, nil
+}
+
+// CommitBid seals a bid on the open auction of an asset. The bid {"price","salt"} is passed
+// in the transient map under "bid" and stored as is in the implicit collection of the bidder's
+// org, the ledger only gets its hash. A bidder can replace the bid until the deadline.
+func (s *MarketContract) CommitBid(ctx contractapi.TransactionContextInterface, assetID string) error {
+	auction, err := s.getOpenAuction(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !txTime.Before(auction.Deadline) {
+		return fmt.Errorf("auction %s took bids until %v", auction.ID, auction.Deadline)
+	}
+
+	err = verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return fmt.Errorf("CommitBid cannot be performed: Error %v", err)
+	}
+	bidderID, err := s.getSubmittingClientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	bidderOrg, err := getClientMSPID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+	if bidderID == auction.Seller && bidderOrg == auction.SellerOrg {
+		return fmt.Errorf("the seller cannot bid on auction %s", auction.ID)
+	}
+
+	bidJSON, _, err := getTransientBid(ctx, auction)
+	if err != nil {
+		return err
+	}
+	bidKey, err := ctx.GetStub().CreateCompositeKey(auctionBidObjectType, []string{auction.ID, bidderID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	collection, err := buildCollectionName(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to infer private collection name for the org: %v", err)
+	}
+
+	// The bid hash is verified when it is revealed, therefore always persist the bid bytes as is
+	err = ctx.GetStub().PutPrivateData(collection, bidKey, bidJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put bid: %v", err)
+	}
+	bidderKey, err := ctx.GetStub().CreateCompositeKey(auctionBidderObjectType, []string{auction.ID, bidderOrg, bidderID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	err = ctx.GetStub().PutState(bidderKey, indexValue)
+	if err != nil {
+		return fmt.Errorf("failed to put bidder: %v", err)
+	}
+	log.Printf("CommitBid: auction %v, from %v", auction.ID, bidderOrg)
+	return nil
+}
+
+// RevealBid publishes the price of a bid after the deadline. The bid is passed in the transient
+// map under "bid" exactly as it was committed and is checked against the committed hash.
+func (s *MarketContract) RevealBid(ctx contractapi.TransactionContextInterface, assetID string) error {
+	auction, err := s.getOpenAuction(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if txTime.Before(auction.Deadline) {
+		return fmt.Errorf("bids on auction %s can be revealed after %v", auction.ID, auction.Deadline)
+	}
+	if !txTime.Before(auction.revealUntil()) {
+		return fmt.Errorf("bids on auction %s could be revealed until %v", auction.ID, auction.revealUntil())
+	}
+
+	bidderID, err := s.getSubmittingClientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	bidderOrg, err := getClientMSPID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+	bidJSON, bid, err := getTransientBid(ctx, auction)
+	if err != nil {
+		return err
+	}
+
+	revealKey, err := ctx.GetStub().CreateCompositeKey(auctionRevealObjectType, []string{auction.ID, bidderOrg, bidderID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	previous, err := ctx.GetStub().GetState(revealKey)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if previous != nil {
+		return fmt.Errorf("bid of %s on auction %s is already revealed", bidderID, auction.ID)
+	}
+
+	bidKey, err := ctx.GetStub().CreateCompositeKey(auctionBidObjectType, []string{auction.ID, bidderID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	committedHash, err := ctx.GetStub().GetPrivateDataHash("_implicit_org_"+bidderOrg, bidKey)
+	if err != nil {
+		return fmt.Errorf("failed to get bid hash: %v", err)
+	}
+	if committedHash == nil {
+		return fmt.Errorf("no bid of %s on auction %s was committed", bidderID, auction.ID)
+	}
+	revealedHash := sha256.Sum256(bidJSON)
+	if !bytes.Equal(committedHash, revealedHash[:]) {
+		return fmt.Errorf("revealed bid does not match the bid committed on auction %s", auction.ID)
+	}
+
+	revealed := &RevealedBid{Bidder: bidderID, BidderOrg: bidderOrg, Price: *bid.Price, TxID: ctx.GetStub().GetTxID(), RevealedAt: txTime}
+	revealedJSON, err := json.Marshal(revealed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal revealed bid into JSON: %v", err)
+	}
+	log.Printf("RevealBid: auction %v, %v from %v", auction.ID, revealed.Price, bidderOrg)
+	return ctx.GetStub().PutState(revealKey, revealedJSON)
+}
+
+// CloseAuction ends the open auction of an asset after the deadline and transfers the asset to
+// the highest revealed bid. Equal bids are ranked by the ID of the transaction that revealed them,
+// which unlike its timestamp the bidder cannot choose. A bidder who cannot pay its bid, or has
+// not approved the seller for it, is passed over for the next highest bid and listed as unfunded. A sale deletes the asks of the seller and
+// rejects the pending buy requests for the asset. Until the reveal period ends only the seller can close
+// the auction, after it anyone can. The close cleans up the private data of the seller's org, so it has
+// to run on a peer of that org. The bids committed on the auction, revealed or not, are deleted from
+// the implicit collections of the bidders.
+// An auction without revealed bids, or without one that can be paid, or of an asset that has expired
+// closes without a winner.
+func (s *MarketContract) CloseAuction(ctx contractapi.TransactionContextInterface, assetID string) (*Auction, error) {
+	auction, err := s.getOpenAuction(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	asset, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if txTime.Before(auction.Deadline) {
+		return nil, fmt.Errorf("auction %s takes bids until %v", auction.ID, auction.Deadline)
+	}
+	if txTime.Before(auction.revealUntil()) {
+		err = s.verifyAssetOwner(ctx, asset)
+		if err != nil {
+			return nil, fmt.Errorf("only the seller can close auction %s before %v: %v", auction.ID, auction.revealUntil(), err)
+		}
+	}
+	peerMSPID, err := shim.GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting the peer's MSPID: %v", err)
+	}
+	if peerMSPID != asset.OwnerOrg {
+		return nil, fmt.Errorf("auction %s has to be closed on a peer of %s, not of %s", auction.ID, asset.OwnerOrg, peerMSPID)
+	}
+
+	// the revealed bids move into the closed auction, its published result
+	auction.Revealed, err = getRevealedBids(ctx, auction.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, bid := range auction.Revealed {
+		revealKey, err := ctx.GetStub().CreateCompositeKey(auctionRevealObjectType, []string{auction.ID, bid.BidderOrg, bid.Bidder})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create composite key: %v", err)
+		}
+		err = ctx.GetStub().DelState(revealKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete revealed bid: %v", err)
+		}
+	}
+	err = deleteBidCommitments(ctx, auction)
+	if err != nil {
+		return nil, err
+	}
+	ranked := make([]*RevealedBid, len(auction.Revealed))
+	copy(ranked, auction.Revealed)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Price != ranked[j].Price {
+			return ranked[i].Price > ranked[j].Price
+		}
+		return ranked[i].TxID < ranked[j].TxID
+	})
+	var winner *RevealedBid
+	// an expired asset cannot be sold, the same as in TransferRequestedAsset
+	expired := verifyNotExpired(ctx, asset)
+	if expired != nil {
+		log.Printf("CloseAuction: %v closes without a winner: %v", auction.ID, expired)
+	}
+	for _, bid := range ranked {
+		if expired != nil {
+			break
+		}
+		funded, err := canPay(ctx, bid.Bidder, bid.BidderOrg, asset.Owner, asset.OwnerOrg, Money{Amount: bid.Price, Currency: auction.Currency})
+		if err != nil {
+			return nil, err
+		}
+		if funded {
+			winner = bid
+			break
+		}
+		log.Printf("CloseAuction: %v, %v from %v cannot pay %v", auction.ID, bid.Bidder, bid.BidderOrg, bid.Price)
+		auction.Unfunded = append(auction.Unfunded, bid)
+	}
+
+	auction.Status = auctionStatusClosed
+	auction.ClosedAt = &txTime
+	if winner != nil {
+		auction.Winner = winner.Bidder
+		auction.WinnerOrg = winner.BidderOrg
+		price := Money{Amount: winner.Price, Currency: auction.Currency}
+		auction.WinningPrice = &price
+		weight := asset.Weight
+
+		sellerID, sellerMSP := asset.Owner, asset.OwnerOrg
+		// The winner pays with the revealed bid, in the same transaction as the lot changes owner
+		err = settlePayment(ctx, winner.Bidder, winner.BidderOrg, sellerID, sellerMSP, price)
+		if err != nil {
+			return nil, err
+		}
+		asset.Owner = winner.Bidder
+		asset.OwnerOrg = winner.BidderOrg
+		err = putAsset(ctx, asset)
+		if err != nil {
+			return nil, err
+		}
+
+		// The lot is sold, so the asks of the seller and the pending buy requests are void,
+		// the same as after TransferRequestedAsset
+		err = deleteAsks(ctx, "_implicit_org_"+sellerMSP, asset.ID)
+		if err != nil {
+			return nil, err
+		}
+		err = s.rejectBuyRequests(ctx, asset.ID, sellerMSP, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		sale := Receipt{AssetID: asset.ID, Type: receiptTypeSale, Counterparty: winner.Bidder, CounterpartyOrg: winner.BidderOrg, Price: price, Quantity: &weight}
+		err = writeReceipt(ctx, sellerMSP, sale)
+		if err != nil {
+			return nil, err
+		}
+		purchase := Receipt{AssetID: asset.ID, Type: receiptTypePurchase, Counterparty: sellerID, CounterpartyOrg: sellerMSP, Price: price, Quantity: &weight}
+		err = writeReceipt(ctx, winner.BidderOrg, purchase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("CloseAuction: %v, winner %v from %v at %v", auction.ID, auction.Winner, auction.WinnerOrg, auction.WinningPrice)
+	err = putAuction(ctx, auction)
+	if err != nil {
+		return nil, err
+	}
+	return auction, nil
+}
+
+// GetAuction returns an auction of an asset
+func (s *QueryContract) GetAuction(ctx contractapi.TransactionContextInterface, assetID string, auctionID string) (*Auction, error) {
+	auctionKey, err := ctx.GetStub().CreateCompositeKey(auctionObjectType, []string{assetID, auctionID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	auctionJSON, err := ctx.GetStub().GetState(auctionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if auctionJSON == nil {
+		return nil, fmt.Errorf("auction %s of asset %s does not exist", auctionID, assetID)
+	}
+
+	var auction Auction
+	err = json.Unmarshal(auctionJSON, &auction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	if auction.Status == auctionStatusOpen {
+		auction.Revealed, err = getRevealedBids(ctx, auction.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &auction, nil
+}
+
+// GetAuctions returns every auction of an asset, open and closed, with the bids revealed so far
+func (s *QueryContract) GetAuctions(ctx contractapi.TransactionContextInterface, assetID string) ([]*Auction, error) {
+	auctions, err := s.getAuctions(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	for _, auction := range auctions {
+		if auction.Status != auctionStatusOpen {
+			continue
+		}
+		auction.Revealed, err = getRevealedBids(ctx, auction.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return auctions, nil
+}
+
+// getAuctions returns every auction of an asset, open and closed
+func (s *SmartContract) getAuctions(ctx contractapi.TransactionContextInterface, assetID string) ([]*Auction, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(auctionObjectType, []string{assetID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	auctions := []*Auction{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var auction Auction
+		err = json.Unmarshal(queryResponse.Value, &auction)
+		if err != nil {
+			return nil, err
+		}
+		auctions = append(auctions, &auction)
+	}
+
+	return auctions, nil
+}
+
+// getOpenAuction returns the open auction of an asset
+func (s *SmartContract) getOpenAuction(ctx contractapi.TransactionContextInterface, assetID string) (*Auction, error) {
+	auctions, err := s.getAuctions(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	for _, auction := range auctions {
+		if auction.Status == auctionStatusOpen {
+			return auction, nil
+		}
+	}
+	return nil, fmt.Errorf("asset %s is not being auctioned", assetID)
+}
+
+// verifyNoOpenAuction fails while an asset is being auctioned, so it cannot change hands outside the auction
+func (s *SmartContract) verifyNoOpenAuction(ctx contractapi.TransactionContextInterface, assetID string) error {
+	auctions, err := s.getAuctions(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	for _, auction := range auctions {
+		if auction.Status == auctionStatusOpen {
+			return fmt.Errorf("asset %s is being auctioned in auction %s", assetID, auction.ID)
+		}
+	}
+	return nil
+}
+
+// getRevealedBids returns the bids revealed on an auction in the order they were revealed
+func getRevealedBids(ctx contractapi.TransactionContextInterface, auctionID string) ([]*RevealedBid, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(auctionRevealObjectType, []string{auctionID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	revealed := []*RevealedBid{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var bid RevealedBid
+		err = json.Unmarshal(queryResponse.Value, &bid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal revealed bid %s: %v", queryResponse.Key, err)
+		}
+		revealed = append(revealed, &bid)
+	}
+	sort.SliceStable(revealed, func(i, j int) bool { return revealed[i].RevealedAt.Before(revealed[j].RevealedAt) })
+
+	return revealed, nil
+}
+
+// deleteBidCommitments deletes the sealed bids of an auction from the implicit collections of the
+// bidders. The revealed bids are deleted too, their bidders may have committed before bidders were recorded.
+func deleteBidCommitments(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(auctionBidderObjectType, []string{auction.ID})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	// the bidders are kept as {org, ID}, deleting the bid of one twice does no harm
+	var bidders [][2]string
+	var bidderKeys []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return fmt.Errorf("failed to split composite key: %v", err)
+		}
+		if len(attributes) != 3 {
+			return fmt.Errorf("bidder key %q is not valid", queryResponse.Key)
+		}
+		bidders = append(bidders, [2]string{attributes[1], attributes[2]})
+		bidderKeys = append(bidderKeys, queryResponse.Key)
+	}
+	for _, bid := range auction.Revealed {
+		bidders = append(bidders, [2]string{bid.BidderOrg, bid.Bidder})
+	}
+
+	for _, bidderKey := range bidderKeys {
+		err = ctx.GetStub().DelState(bidderKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete bidder: %v", err)
+		}
+	}
+	for _, bidder := range bidders {
+		bidKey, err := ctx.GetStub().CreateCompositeKey(auctionBidObjectType, []string{auction.ID, bidder[1]})
+		if err != nil {
+			return fmt.Errorf("failed to create composite key: %v", err)
+		}
+		err = ctx.GetStub().DelPrivateData("_implicit_org_"+bidder[0], bidKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete bid of %s: %v", bidder[1], err)
+		}
+	}
+	return nil
+}
+
+// revealUntil returns the end of the reveal period, for an auction opened before it was recorded too
+func (a *Auction) revealUntil() time.Time {
+	if a.RevealUntil.IsZero() {
+		return a.Deadline.Add(auctionRevealPeriod)
+	}
+	return a.RevealUntil
+}
+
+// getTransientBid returns the bid on an auction passed in the transient map, as bytes and parsed
+func getTransientBid(ctx contractapi.TransactionContextInterface, auction *Auction) ([]byte, *sealedBid, error) {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting transient: %v", err)
+	}
+	bidJSON, ok := transientMap["bid"]
+	if !ok {
+		return nil, nil, fmt.Errorf("bid key not found in the transient map")
+	}
+
+	var bid sealedBid
+	err = json.Unmarshal(bidJSON, &bid)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal bid: %v", err)
+	}
+	if bid.Price == nil || *bid.Price <= 0 {
+		return nil, nil, fmt.Errorf("bid must have a positive price")
+	}
+	if bid.Currency != "" && bid.Currency != auction.Currency {
+		return nil, nil, fmt.Errorf("bid is in %s but auction %s is in %s", bid.Currency, auction.ID, auction.Currency)
+	}
+	if len(bid.Salt) < minSaltLength {
+		return nil, nil, fmt.Errorf("bid must have a salt of at least %d characters, otherwise its price can be guessed from its hash", minSaltLength)
+	}
+	return bidJSON, &bid, nil
+}
+
+func putAuction(ctx contractapi.TransactionContextInterface, auction *Auction) error {
+	auctionKey, err := ctx.GetStub().CreateCompositeKey(auctionObjectType, []string{auction.AssetID, auction.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	auctionJSON, err := json.Marshal(auction)
+	if err != nil {
+		return fmt.Errorf("failed to marshal auction into JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(auctionKey, auctionJSON)
+}
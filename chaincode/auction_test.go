@@ -0,0 +1,380 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+)
+
+func bid(value string) map[string][]byte {
+	return map[string][]byte{"bid": []byte(value)}
+}
+
+func TestSealedBidAuction(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	deadline := stub.Now.Add(time.Hour).Format(time.RFC3339)
+
+	commit := func(ctx contractapi.TransactionContextInterface) error {
+		return sc.CommitBid(ctx, "asset1")
+	}
+	reveal := func(ctx contractapi.TransactionContextInterface) error {
+		return sc.RevealBid(ctx, "asset1")
+	}
+	closeAuction := func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.CloseAuction(ctx, "asset1")
+		return err
+	}
+	open := func(deadline string) txFunc {
+		return func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.OpenAuction(ctx, "asset1", deadline, "EUR")
+			return err
+		}
+	}
+
+	run(t, stub, []step{
+		{"farmer creates apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 10, "apples")
+		}, ""},
+		{"bid without an auction", retailer, bid(`{"price":50,"salt":"r1-0123456789abcdef"}`), commit, "not being auctioned"},
+		{"not the owner", farmer2, nil, open(deadline), "does not own asset"},
+		{"deadline passed", farmer, nil, open("2021-01-01T00:00:00Z"), "already passed"},
+		{"deadline after expiration", farmer, nil, open(stub.Now.AddDate(0, 1, 0).Format(time.RFC3339)), "expires on"},
+		{"farmer opens an auction", farmer, nil, open(deadline), ""},
+		{"only one open auction", farmer, nil, open(deadline), "being auctioned"},
+		{"seller cannot bid", farmer, bid(`{"price":90,"salt":"f1-0123456789abcdef"}`), commit, "seller cannot bid"},
+		{"bid without a salt", retailer, bid(`{"price":50}`), commit, "must have a salt"},
+		{"bid with a short salt", retailer, bid(`{"price":50,"salt":"r1"}`), commit, "at least 16 characters"},
+		{"bid in another currency", retailer, bid(`{"price":50,"currency":"USD","salt":"r1-0123456789abcdef"}`), commit, "is in EUR"},
+		{"retailer bids 40", retailer, bid(`{"price":40,"salt":"r1-0123456789abcdef"}`), commit, ""},
+		{"retailer raises to 50", retailer, bid(`{"price":50,"salt":"r2-0123456789abcdef"}`), commit, ""},
+		{"supermarket bids 70", supermarket, bid(`{"price":70,"salt":"s1-0123456789abcdef"}`), commit, ""},
+		{"reveal before the deadline", retailer, bid(`{"price":50,"salt":"r2-0123456789abcdef"}`), reveal, "can be revealed after"},
+		{"close before the deadline", farmer, nil, closeAuction, "takes bids until"},
+		{"no direct sale during the auction", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.DeleteAsset(ctx, "asset1")
+		}, "being auctioned"},
+		{"no change to the lot during the auction", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.UpdateAsset(ctx, "asset1", "red", 5)
+		}, "being auctioned"},
+	})
+
+	stub.Advance(2 * time.Hour)
+
+	run(t, stub, []step{
+		{"bid after the deadline", farmer2, bid(`{"price":99,"salt":"f2-0123456789abcdef"}`), commit, "took bids until"},
+		{"retailer reveals the replaced bid", retailer, bid(`{"price":40,"salt":"r1-0123456789abcdef"}`), reveal, "does not match"},
+		{"retailer reveals 50", retailer, bid(`{"price":50,"salt":"r2-0123456789abcdef"}`), reveal, ""},
+		{"retailer reveals again", retailer, bid(`{"price":50,"salt":"r2-0123456789abcdef"}`), reveal, "already revealed"},
+		{"supermarket lies about its bid", supermarket, bid(`{"price":30,"salt":"s1-0123456789abcdef"}`), reveal, "does not match"},
+		{"supermarket reveals 70", supermarket, bid(`{"price":70,"salt":"s1-0123456789abcdef"}`), reveal, ""},
+		{"farmer2 never bid", farmer2, bid(`{"price":99,"salt":"f2-0123456789abcdef"}`), reveal, "no bid"},
+		{"revealed bids are listed before the close", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			auctions, err := sc.GetAuctions(ctx, "asset1")
+			require.Len(t, auctions[0].Revealed, 2)
+			return err
+		}, ""},
+		{"only the seller closes during the reveal period", retailer, nil, closeAuction, "only the seller can close"},
+		{"farmer closes the auction", farmer, nil, closeAuction, ""},
+		{"auction is closed", supermarket, bid(`{"price":70,"salt":"s1-0123456789abcdef"}`), reveal, "not being auctioned"},
+	})
+
+	asset := readAsset(t, stub, "asset1")
+	require.Equal(t, "SupermarketO", asset.Owner)
+	require.Equal(t, "Org3MSP", asset.OwnerOrg)
+
+	var auctions []*chaincode.Auction
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		auctions, err = sc.GetAuctions(ctx, "asset1")
+		return err
+	})
+	require.NoError(t, err)
+	require.Len(t, auctions, 1)
+	require.Equal(t, "closed", auctions[0].Status)
+	require.Equal(t, "SupermarketO", auctions[0].Winner)
+	require.Equal(t, "Org3MSP", auctions[0].WinnerOrg)
+	require.Equal(t, &chaincode.Money{Amount: 70, Currency: "EUR"}, auctions[0].WinningPrice)
+	require.Len(t, auctions[0].Revealed, 2)
+
+	var receipts []*chaincode.Receipt
+	err = stub.Evaluate(supermarket, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		receipts, err = sc.ListMyReceipts(ctx)
+		return err
+	})
+	require.NoError(t, err)
+	require.Len(t, receipts, 1)
+	require.Equal(t, "purchase", receipts[0].Type)
+	require.Equal(t, chaincode.Money{Amount: 70, Currency: "EUR"}, receipts[0].Price)
+	require.Equal(t, &chaincode.Quantity{Value: 10, Unit: "kg"}, receipts[0].Quantity)
+
+	run(t, stub, []step{
+		{"new owner opens another auction", supermarket, nil, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.OpenAuction(ctx, "asset1", stub.Now.Add(time.Hour).Format(time.RFC3339), "EUR")
+			return err
+		}, ""},
+	})
+}
+
+func TestAuctionWithoutBids(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	run(t, stub, []step{
+		{"farmer creates apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 10, "apples")
+		}, ""},
+		{"farmer opens an auction", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.OpenAuction(ctx, "asset1", stub.Now.Add(time.Hour).Format(time.RFC3339), "EUR")
+			return err
+		}, ""},
+	})
+	stub.Advance(2 * time.Hour)
+
+	var auction *chaincode.Auction
+	err := stub.Submit(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		auction, err = sc.CloseAuction(ctx, "asset1")
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, "closed", auction.Status)
+	require.Empty(t, auction.Winner)
+	require.Equal(t, "FarmerO", readAsset(t, stub, "asset1").Owner)
+}
+
+func TestAuctionSkipsUnfundedBid(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	reveal := func(ctx contractapi.TransactionContextInterface) error {
+		return sc.RevealBid(ctx, "asset1")
+	}
+	run(t, stub, []step{
+		{"farmer creates apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 10, "apples")
+		}, ""},
+		{"farmer opens an auction", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.OpenAuction(ctx, "asset1", stub.Now.Add(time.Hour).Format(time.RFC3339), "EUR")
+			return err
+		}, ""},
+		{"retailer bids 50", retailer, bid(`{"price":50,"salt":"r1-0123456789abcdef"}`), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CommitBid(ctx, "asset1")
+		}, ""},
+		{"supermarket bids 70", supermarket, bid(`{"price":70,"salt":"s1-0123456789abcdef"}`), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CommitBid(ctx, "asset1")
+		}, ""},
+		{"other farmer bids 60", farmer2, bid(`{"price":60,"salt":"f2-0123456789abcdef"}`), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CommitBid(ctx, "asset1")
+		}, ""},
+	})
+	stub.Advance(2 * time.Hour)
+	run(t, stub, []step{
+		{"retailer reveals 50", retailer, bid(`{"price":50,"salt":"r1-0123456789abcdef"}`), reveal, ""},
+		{"supermarket reveals 70", supermarket, bid(`{"price":70,"salt":"s1-0123456789abcdef"}`), reveal, ""},
+		{"other farmer reveals 60", farmer2, bid(`{"price":60,"salt":"f2-0123456789abcdef"}`), reveal, ""},
+		{"supermarket spends its tokens", supermarket, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferTokens(ctx, "FarmerT", "Org1MSP", 99950, "EUR")
+		}, ""},
+		{"other farmer withdraws its approval of the farmer", farmer2, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.Approve(ctx, "FarmerO", "Org1MSP", 0, "EUR")
+		}, ""},
+	})
+
+	var auction *chaincode.Auction
+	err := stub.Submit(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		auction, err = sc.CloseAuction(ctx, "asset1")
+		return err
+	})
+	require.NoError(t, err, "an unfunded highest bid does not keep the auction open")
+	require.Equal(t, "RetailerO", auction.Winner)
+	require.Equal(t, &chaincode.Money{Amount: 50, Currency: "EUR"}, auction.WinningPrice)
+	require.Len(t, auction.Unfunded, 2)
+	require.Equal(t, "SupermarketO", auction.Unfunded[0].Bidder)
+	require.Equal(t, "FarmerT", auction.Unfunded[1].Bidder, "a bidder who has not approved the seller cannot pay")
+	require.Equal(t, "RetailerO", readAsset(t, stub, "asset1").Owner)
+	require.Equal(t, 99950, balanceOf(t, stub, retailer))
+	require.Equal(t, 50, balanceOf(t, stub, supermarket))
+}
+
+func TestAuctionVoidsAskAndBuyRequests(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	run(t, stub, []step{
+		{"farmer creates apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 10, "apples")
+		}, ""},
+		{"farmer asks", farmer, price("100", 10), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, ""},
+		{"retailer requests", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, ""},
+		{"farmer opens an auction", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.OpenAuction(ctx, "asset1", stub.Now.Add(time.Hour).Format(time.RFC3339), "EUR")
+			return err
+		}, ""},
+		{"supermarket bids 70", supermarket, bid(`{"price":70,"salt":"s1-0123456789abcdef"}`), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CommitBid(ctx, "asset1")
+		}, ""},
+	})
+	stub.Advance(2 * time.Hour)
+	run(t, stub, []step{
+		{"supermarket reveals 70", supermarket, bid(`{"price":70,"salt":"s1-0123456789abcdef"}`), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RevealBid(ctx, "asset1")
+		}, ""},
+		{"farmer closes the auction", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.CloseAuction(ctx, "asset1")
+			return err
+		}, ""},
+		{"the ask is gone", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.GetAssetSalesPrice(ctx, "asset1")
+			return err
+		}, "asset price does not exist"},
+	})
+
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		request, err := sc.ReadRequestToBuy(ctx, "asset1", "RetailerO", "assetCollection")
+		require.Equal(t, "rejected", request.Status)
+		return err
+	})
+	require.NoError(t, err)
+}
+
+func TestAuctionClosedByAnyoneAfterRevealPeriod(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	closeAuction := func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.CloseAuction(ctx, "asset1")
+		return err
+	}
+	run(t, stub, []step{
+		{"farmer creates apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 10, "apples")
+		}, ""},
+		{"farmer opens an auction", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.OpenAuction(ctx, "asset1", stub.Now.Add(time.Hour).Format(time.RFC3339), "EUR")
+			return err
+		}, ""},
+		{"supermarket bids 70", supermarket, bid(`{"price":70,"salt":"s1-0123456789abcdef"}`), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CommitBid(ctx, "asset1")
+		}, ""},
+	})
+	stub.Advance(2 * time.Hour)
+	run(t, stub, []step{
+		{"supermarket reveals 70", supermarket, bid(`{"price":70,"salt":"s1-0123456789abcdef"}`), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RevealBid(ctx, "asset1")
+		}, ""},
+		{"farmer2 cannot close yet", farmer2, nil, closeAuction, "only the seller can close"},
+	})
+	stub.Advance(24 * time.Hour)
+	run(t, stub, []step{
+		{"reveal after the reveal period", retailer, bid(`{"price":50,"salt":"r1-0123456789abcdef"}`), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RevealBid(ctx, "asset1")
+		}, "could be revealed until"},
+		{"the close runs on a peer of the seller's org", supermarket, nil, closeAuction, "has to be closed on a peer of Org1MSP"},
+		{"farmer2 closes for the seller", farmer2, nil, closeAuction, ""},
+	})
+	require.Equal(t, "SupermarketO", readAsset(t, stub, "asset1").Owner)
+	require.Equal(t, 100000-70, balanceOf(t, stub, supermarket))
+}
+
+func TestAuctionOfExpiredAsset(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	run(t, stub, []step{
+		{"farmer creates apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 10, "apples")
+		}, ""},
+		{"farmer opens an auction until the day before the apples expire", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.OpenAuction(ctx, "asset1", stub.Now.AddDate(0, 0, 6).Format(time.RFC3339), "EUR")
+			return err
+		}, ""},
+		{"supermarket bids 70", supermarket, bid(`{"price":70,"salt":"s1-0123456789abcdef"}`), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CommitBid(ctx, "asset1")
+		}, ""},
+	})
+	stub.Advance(6*24*time.Hour + time.Hour)
+	run(t, stub, []step{
+		{"supermarket reveals 70", supermarket, bid(`{"price":70,"salt":"s1-0123456789abcdef"}`), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RevealBid(ctx, "asset1")
+		}, ""},
+	})
+	stub.Advance(24 * time.Hour)
+
+	var auction *chaincode.Auction
+	err := stub.Submit(farmer2, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		auction, err = sc.CloseAuction(ctx, "asset1")
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, "closed", auction.Status)
+	require.Empty(t, auction.Winner)
+	require.Equal(t, "FarmerO", readAsset(t, stub, "asset1").Owner)
+	require.Equal(t, 100000, balanceOf(t, stub, supermarket))
+}
+
+func TestAuctionTieAndBidCleanup(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	commit := func(ctx contractapi.TransactionContextInterface) error {
+		return sc.CommitBid(ctx, "asset1")
+	}
+	reveal := func(ctx contractapi.TransactionContextInterface) error {
+		return sc.RevealBid(ctx, "asset1")
+	}
+	run(t, stub, []step{
+		{"farmer creates apples", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 10, "apples")
+		}, ""},
+		{"farmer opens an auction", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.OpenAuction(ctx, "asset1", stub.Now.Add(time.Hour).Format(time.RFC3339), "EUR")
+			return err
+		}, ""},
+		{"retailer bids 60", retailer, bid(`{"price":60,"salt":"r1-0123456789abcdef"}`), commit, ""},
+		{"supermarket bids 60", supermarket, bid(`{"price":60,"salt":"s1-0123456789abcdef"}`), commit, ""},
+		{"other farmer bids 40", farmer2, bid(`{"price":40,"salt":"f2-0123456789abcdef"}`), commit, ""},
+	})
+	stub.Advance(2 * time.Hour)
+	run(t, stub, []step{
+		{"retailer reveals 60", retailer, bid(`{"price":60,"salt":"r1-0123456789abcdef"}`), reveal, ""},
+	})
+	// the supermarket backdates its reveal to before the one of the retailer
+	stub.Now = stub.Now.Add(-30 * time.Minute)
+	run(t, stub, []step{
+		{"supermarket reveals 60", supermarket, bid(`{"price":60,"salt":"s1-0123456789abcdef"}`), reveal, ""},
+	})
+
+	var auction *chaincode.Auction
+	err := stub.Submit(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		auction, err = sc.CloseAuction(ctx, "asset1")
+		return err
+	})
+	require.NoError(t, err)
+	require.Len(t, auction.Revealed, 2)
+	require.Equal(t, "SupermarketO", auction.Revealed[0].Bidder, "the supermarket claims to have revealed first")
+	require.Less(t, auction.Revealed[1].TxID, auction.Revealed[0].TxID)
+	require.Equal(t, "RetailerO", auction.Winner, "a tie goes to the bid revealed in the lower transaction ID")
+
+	err = stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		for _, bidder := range []struct{ id, org string }{{"RetailerO", "Org2MSP"}, {"SupermarketO", "Org3MSP"}, {"FarmerT", "Org1MSP"}} {
+			bidKey, err := ctx.GetStub().CreateCompositeKey("AuctionBid", []string{auction.ID, bidder.id})
+			require.NoError(t, err)
+			hash, err := ctx.GetStub().GetPrivateDataHash("_implicit_org_"+bidder.org, bidKey)
+			require.NoError(t, err)
+			require.Nil(t, hash, "the bid of %s is deleted on close", bidder.id)
+		}
+		bidders, err := ctx.GetStub().GetStateByPartialCompositeKey("AuctionBidder", []string{auction.ID})
+		require.NoError(t, err)
+		defer bidders.Close()
+		require.False(t, bidders.HasNext())
+		return nil
+	})
+	require.NoError(t, err)
+}
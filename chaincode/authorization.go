@@ -0,0 +1,43 @@
+package chaincode
+
+import (
+	"log"
+	"strings"
+)
+
+// transactionName returns the function called as contract:function. contractapi passes the function
+// without the contract name when it is called on the default contract.
+func (s *SmartContract) transactionName(ctx TransactionContextInterface) string {
+	function, _ := ctx.GetStub().GetFunctionAndParameters()
+	return s.GetName() + ":" + function[strings.LastIndex(function, ":")+1:]
+}
+
+// beforeTransaction runs before every transaction of the contracts. It resolves the submitting client
+// and checks that the policy lets it call the transaction, see policy.go.
+func (s *SmartContract) beforeTransaction(ctx TransactionContextInterface) error {
+	function := s.transactionName(ctx)
+	client, err := ctx.GetClient()
+	if err != nil {
+		return err
+	}
+	policy, err := readPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	err = policy.authorize(client, function)
+	if err != nil {
+		log.Printf("audit: %s denied to %s of %s in tx %s", function, client.ID, client.MSPID, ctx.GetStub().GetTxID())
+		return err
+	}
+	return nil
+}
+
+// afterTransaction runs after every transaction of the contracts that succeeded and logs who called it
+func (s *SmartContract) afterTransaction(ctx TransactionContextInterface) error {
+	client, err := ctx.GetClient()
+	if err != nil {
+		return err
+	}
+	log.Printf("audit: %s by %s of %s in tx %s", s.transactionName(ctx), client.ID, client.MSPID, ctx.GetStub().GetTxID())
+	return nil
+}
@@ -0,0 +1,175 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const sharedCollectionObjectType = "SharedCollection"
+
+// SharedCollection is a registry entry naming the private data collection that a
+// pair of organizations uses for buy requests between them.
+type SharedCollection struct {
+	Name string   `json:"name"`
+	Orgs []string `json:"orgs"`
+}
+
+// collectionDefinition is the part of a collections_config.json entry used to seed the registry
+type collectionDefinition struct {
+	Name   string `json:"name"`
+	Policy string `json:"policy"`
+}
+
+// collectionPolicyMember matches the principals of a collection policy, e.g. 'Org1MSP.member'
+var collectionPolicyMember = regexp.MustCompile(`'([^'.]+)\.(member|peer|client|admin)'`)
+
+// RegisterSharedCollections seeds the collection registry from a JSON array shaped like
+// collections_config.json. Every pair of organizations named in a collection policy is
+// mapped to that collection; pairs that are already registered are overwritten.
+func (s *MarketContract) RegisterSharedCollections(ctx contractapi.TransactionContextInterface, collectionsConfig string) error {
+	var definitions []collectionDefinition
+	err := json.Unmarshal([]byte(collectionsConfig), &definitions)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal collections config: %v", err)
+	}
+
+	registered := make(map[string]string)
+	for _, definition := range definitions {
+		if definition.Name == "" {
+			return fmt.Errorf("collection name must be a non-empty string")
+		}
+		orgs := collectionMembers(definition.Policy)
+		if len(orgs) < 2 {
+			return fmt.Errorf("collection %s must be shared by at least two organizations, policy is %s", definition.Name, definition.Policy)
+		}
+
+		for i := 0; i < len(orgs); i++ {
+			for j := i + 1; j < len(orgs); j++ {
+				pair := orgPair(orgs[i], orgs[j])
+				key, err := ctx.GetStub().CreateCompositeKey(sharedCollectionObjectType, pair)
+				if err != nil {
+					return fmt.Errorf("failed to create composite key: %v", err)
+				}
+				if previous, ok := registered[key]; ok && previous != definition.Name {
+					return fmt.Errorf("organizations %s and %s share both %s and %s", pair[0], pair[1], previous, definition.Name)
+				}
+				registered[key] = definition.Name
+
+				entryJSON, err := json.Marshal(SharedCollection{Name: definition.Name, Orgs: pair})
+				if err != nil {
+					return err
+				}
+				err = ctx.GetStub().PutState(key, entryJSON)
+				if err != nil {
+					return fmt.Errorf("failed to put shared collection to world state: %v", err)
+				}
+				log.Printf("RegisterSharedCollections: %v <-> %v uses %v", pair[0], pair[1], definition.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetSharedCollection returns the collection registered for two organizations, in either order.
+func (s *QueryContract) GetSharedCollection(ctx contractapi.TransactionContextInterface, orgA string, orgB string) (string, error) {
+	return s.getSharedCollection(ctx, orgA, orgB)
+}
+
+// getSharedCollection returns the collection registered for two organizations, in either order.
+func (s *SmartContract) getSharedCollection(ctx contractapi.TransactionContextInterface, orgA string, orgB string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(sharedCollectionObjectType, orgPair(orgA, orgB))
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	entryJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if entryJSON == nil {
+		return "", fmt.Errorf("no shared collection is registered between %s and %s", orgA, orgB)
+	}
+
+	var entry SharedCollection
+	err = json.Unmarshal(entryJSON, &entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	return entry.Name, nil
+}
+
+// GetAllSharedCollections returns every registered organization pair.
+func (s *QueryContract) GetAllSharedCollections(ctx contractapi.TransactionContextInterface) ([]*SharedCollection, error) {
+	return s.getAllSharedCollections(ctx)
+}
+
+// getAllSharedCollections returns every registered organization pair.
+func (s *SmartContract) getAllSharedCollections(ctx contractapi.TransactionContextInterface) ([]*SharedCollection, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(sharedCollectionObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var entries []*SharedCollection
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var entry SharedCollection
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
+// sharedCollectionsOf returns the distinct registered collections an organization is a member of.
+func (s *SmartContract) sharedCollectionsOf(ctx contractapi.TransactionContextInterface, org string) ([]string, error) {
+	entries, err := s.getAllSharedCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		for _, member := range entry.Orgs {
+			if member == org && !seen[entry.Name] {
+				seen[entry.Name] = true
+				names = append(names, entry.Name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// collectionMembers returns the distinct MSP IDs named in a collection policy.
+func collectionMembers(policy string) []string {
+	var orgs []string
+	seen := make(map[string]bool)
+	for _, match := range collectionPolicyMember.FindAllStringSubmatch(policy, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			orgs = append(orgs, match[1])
+		}
+	}
+	return orgs
+}
+
+// orgPair orders two MSP IDs so that a pair has the same key whichever side is the seller.
+func orgPair(orgA string, orgB string) []string {
+	pair := []string{orgA, orgB}
+	sort.Strings(pair)
+	return pair
+}
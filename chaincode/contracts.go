@@ -0,0 +1,58 @@
+package chaincode
+
+// The chaincode is made of three contracts, registered in main.go. Clients call a transaction with the
+// name of its contract in front, e.g. market:SetPrice or query:ReadAsset. The asset contract is the
+// default one, the one a transaction without a contract name goes to.
+const (
+	assetContractName  = "asset"
+	marketContractName = "market"
+	queryContractName  = "query"
+)
+
+// AssetContract manages the lifecycle of assets: creating, updating, splitting and merging lots, their
+// shelf life and sensor readings, and the registries of products and devices they depend on
+type AssetContract struct {
+	SmartContract
+}
+
+// MarketContract trades assets: asks, bids and buy requests kept in private data, negotiations,
+// auctions and the settlement token that pays for transfers
+type MarketContract struct {
+	SmartContract
+}
+
+// QueryContract holds the read-only functions of the chaincode, over both the world state and the
+// private data collections
+type QueryContract struct {
+	SmartContract
+}
+
+// setUp names the contract and has it run its transactions with the chaincode's transaction context,
+// between the authorization and audit hooks of authorization.go
+func (s *SmartContract) setUp(name string) {
+	s.Name = name
+	s.TransactionContextHandler = new(TransactionContext)
+	s.BeforeTransaction = s.beforeTransaction
+	s.AfterTransaction = s.afterTransaction
+}
+
+// NewAssetContract returns the asset contract, named asset
+func NewAssetContract() *AssetContract {
+	contract := new(AssetContract)
+	contract.setUp(assetContractName)
+	return contract
+}
+
+// NewMarketContract returns the market contract, named market
+func NewMarketContract() *MarketContract {
+	contract := new(MarketContract)
+	contract.setUp(marketContractName)
+	return contract
+}
+
+// NewQueryContract returns the query contract, named query
+func NewQueryContract() *QueryContract {
+	contract := new(QueryContract)
+	contract.setUp(queryContractName)
+	return contract
+}
@@ -0,0 +1,21 @@
+package chaincode_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+)
+
+func TestContracts(t *testing.T) {
+	// clients call market:SetPrice, query:ReadAsset and so on
+	require.Equal(t, "asset", chaincode.NewAssetContract().GetName())
+	require.Equal(t, "market", chaincode.NewMarketContract().GetName())
+	require.Equal(t, "query", chaincode.NewQueryContract().GetName())
+
+	// every contract embeds the shared helpers, an exported one would be a transaction of all three
+	require.Equal(t, reflect.TypeOf(&contractapi.Contract{}).NumMethod(), reflect.TypeOf(&chaincode.SmartContract{}).NumMethod())
+}
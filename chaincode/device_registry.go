@@ -0,0 +1,208 @@
+package chaincode
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const deviceObjectType = "Device"
+
+const (
+	deviceStatusActive  = "active"
+	deviceStatusRevoked = "revoked"
+)
+
+// Device is an IoT sensor whose public key is bound to the org that registered it
+type Device struct {
+	ID           string     `json:"deviceID"`
+	OwnerOrg     string     `json:"ownerOrg"`
+	PublicKey    string     `json:"publicKey"`
+	Status       string     `json:"status"`
+	RegisteredAt time.Time  `json:"registeredAt"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+}
+
+// ecdsaSignature is the ASN.1 structure of an ECDSA signature
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// RegisterDevice binds the PEM encoded ECDSA public key of a sensor to the org of the submitting admin
+func (s *AssetContract) RegisterDevice(ctx contractapi.TransactionContextInterface, deviceID string, publicKeyPEM string) error {
+	if deviceID == "" {
+		return fmt.Errorf("deviceID must be a non-empty string")
+	}
+	_, err := parseDevicePublicKey(publicKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.readDevice(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("device %s is already registered by %s", deviceID, existing.OwnerOrg)
+	}
+
+	clientOrgID, err := getClientMSPID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+	timestamp, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	device := &Device{
+		ID:           deviceID,
+		OwnerOrg:     clientOrgID,
+		PublicKey:    publicKeyPEM,
+		Status:       deviceStatusActive,
+		RegisteredAt: timestamp,
+	}
+	log.Printf("RegisterDevice: %v for %v", deviceID, clientOrgID)
+	return putDevice(ctx, device)
+}
+
+// RevokeDevice stops accepting readings signed by a device. Only an admin of the org that registered it can revoke it.
+func (s *AssetContract) RevokeDevice(ctx contractapi.TransactionContextInterface, deviceID string) error {
+	device, err := s.getDevice(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+
+	clientOrgID, err := getClientMSPID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+	if clientOrgID != device.OwnerOrg {
+		return fmt.Errorf("submitting client not authorized to revoke device %s, it is registered by %s", deviceID, device.OwnerOrg)
+	}
+	if device.Status == deviceStatusRevoked {
+		return fmt.Errorf("device %s is already revoked", deviceID)
+	}
+
+	timestamp, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	device.Status = deviceStatusRevoked
+	device.RevokedAt = &timestamp
+	log.Printf("RevokeDevice: %v of %v", deviceID, clientOrgID)
+	return putDevice(ctx, device)
+}
+
+// GetDevice returns a registered device
+func (s *QueryContract) GetDevice(ctx contractapi.TransactionContextInterface, deviceID string) (*Device, error) {
+	return s.getDevice(ctx, deviceID)
+}
+
+// getDevice returns a registered device
+func (s *SmartContract) getDevice(ctx contractapi.TransactionContextInterface, deviceID string) (*Device, error) {
+	device, err := s.readDevice(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, fmt.Errorf("device %s is not registered", deviceID)
+	}
+	return device, nil
+}
+
+// verifyDeviceSignature checks that payload was signed by an active device registered by orgMSP.
+// signature is the base64 encoded ASN.1 ECDSA signature of the SHA-256 hash of payload.
+func (s *SmartContract) verifyDeviceSignature(ctx contractapi.TransactionContextInterface, deviceID string, orgMSP string, payload []byte, signature string) error {
+	device, err := s.getDevice(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	if device.Status != deviceStatusActive {
+		return fmt.Errorf("device %s is %s", deviceID, device.Status)
+	}
+	if device.OwnerOrg != orgMSP {
+		return fmt.Errorf("device %s is registered by %s, not by %s", deviceID, device.OwnerOrg, orgMSP)
+	}
+
+	publicKey, err := parseDevicePublicKey(device.PublicKey)
+	if err != nil {
+		return err
+	}
+	signatureDER, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("failed to base64 decode signature: %v", err)
+	}
+	var sig ecdsaSignature
+	rest, err := asn1.Unmarshal(signatureDER, &sig)
+	if err != nil || len(rest) != 0 || sig.R == nil || sig.S == nil {
+		return fmt.Errorf("signature is not an ASN.1 encoded ECDSA signature")
+	}
+
+	hash := sha256.Sum256(payload)
+	if !ecdsa.Verify(publicKey, hash[:], sig.R, sig.S) {
+		return fmt.Errorf("signature of device %s is not valid", deviceID)
+	}
+	return nil
+}
+
+// readDevice returns the registered device or nil
+func (s *SmartContract) readDevice(ctx contractapi.TransactionContextInterface, deviceID string) (*Device, error) {
+	deviceKey, err := ctx.GetStub().CreateCompositeKey(deviceObjectType, []string{deviceID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	deviceJSON, err := ctx.GetStub().GetState(deviceKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if deviceJSON == nil {
+		return nil, nil
+	}
+
+	var device Device
+	err = json.Unmarshal(deviceJSON, &device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	return &device, nil
+}
+
+func putDevice(ctx contractapi.TransactionContextInterface, device *Device) error {
+	deviceKey, err := ctx.GetStub().CreateCompositeKey(deviceObjectType, []string{device.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	deviceJSON, err := json.Marshal(device)
+	if err != nil {
+		return fmt.Errorf("failed to marshal device into JSON: %v", err)
+	}
+	return ctx.GetStub().PutState(deviceKey, deviceJSON)
+}
+
+// parseDevicePublicKey decodes a PEM encoded PKIX ECDSA public key
+func parseDevicePublicKey(publicKeyPEM string) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("public key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an ECDSA key")
+	}
+	return publicKey, nil
+}
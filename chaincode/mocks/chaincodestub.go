@@ -0,0 +1,590 @@
+package mocks
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	minUnicodeRuneValue      = 0
+	maxUnicodeRuneValue      = utf8.MaxRune
+	compositeKeyNamespace    = "\x00"
+	emptyKeySubstitute       = "\x01"
+	implicitCollectionPrefix = "_implicit_org_"
+)
+
+// CollectionConfig is the part of a collections_config.json entry the stub needs
+// to decide which orgs may read and write a shared collection.
+type CollectionConfig struct {
+	Name            string   `json:"name"`
+	Policy          string   `json:"policy"`
+	MemberOnlyRead  bool     `json:"memberOnlyRead"`
+	MemberOnlyWrite bool     `json:"memberOnlyWrite"`
+	Members         []string `json:"-"`
+}
+
+var memberPolicy = regexp.MustCompile(`'([^'.]+)\.member'`)
+
+type write struct {
+	value    []byte
+	isDelete bool
+}
+
+// ChaincodeStub is an in-memory shim.ChaincodeStubInterface for running the
+// chaincode offline. It keeps a single channel ledger (world state, private
+// collections and key history) and simulates transactions the way a peer does:
+// reads see committed state only, writes are applied when the transaction is
+// committed and thrown away when it fails.
+// Stub methods that the chaincode never calls are left unimplemented and panic.
+type ChaincodeStub struct {
+	shim.ChaincodeStubInterface
+
+	ChannelID   string
+	Now         time.Time
+	Collections map[string]*CollectionConfig
+	Events      map[string][]byte
+	// RichQueries makes the world state answer rich queries like CouchDB, instead of failing like LevelDB.
+	RichQueries bool
+
+	txID        string
+	function    string
+	txTimestamp *timestamp.Timestamp
+	txCount     int
+	transient   map[string][]byte
+	clientMSPID string
+	peerMSPID   string
+	paginated   bool
+
+	state     map[string][]byte
+	private   map[string]map[string][]byte
+	history   map[string][]*queryresult.KeyModification
+	writes    map[string]*write
+	pvtWrites map[string]map[string]*write
+}
+
+// NewChaincodeStub returns an empty ledger whose clock starts at a fixed date.
+func NewChaincodeStub() *ChaincodeStub {
+	return &ChaincodeStub{
+		ChannelID:   "mychannel",
+		Now:         time.Date(2021, time.June, 1, 8, 0, 0, 0, time.UTC),
+		Collections: make(map[string]*CollectionConfig),
+		Events:      make(map[string][]byte),
+		state:       make(map[string][]byte),
+		private:     make(map[string]map[string][]byte),
+		history:     make(map[string][]*queryresult.KeyModification),
+	}
+}
+
+// LoadCollectionsConfig registers the shared collections defined in a
+// collections_config.json file. Members are taken from the 'OrgXMSP.member'
+// principals of each collection policy.
+func (s *ChaincodeStub) LoadCollectionsConfig(path string) error {
+	configJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var configs []*CollectionConfig
+	err = json.Unmarshal(configJSON, &configs)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal collections config: %v", err)
+	}
+	for _, config := range configs {
+		for _, match := range memberPolicy.FindAllStringSubmatch(config.Policy, -1) {
+			config.Members = append(config.Members, match[1])
+		}
+		s.Collections[config.Name] = config
+	}
+	return nil
+}
+
+// Submit runs fn as a transaction submitted by identity to a peer of the
+// identity's org. The writes are committed only when fn returns nil.
+func (s *ChaincodeStub) Submit(identity *ClientIdentity, transient map[string][]byte, fn func(ctx contractapi.TransactionContextInterface) error) error {
+	ctx := s.begin(identity, transient)
+	err := fn(ctx)
+	if err == nil {
+		s.commit()
+	}
+	s.end()
+	return err
+}
+
+// Evaluate runs fn as a query by identity. Writes are never committed.
+func (s *ChaincodeStub) Evaluate(identity *ClientIdentity, transient map[string][]byte, fn func(ctx contractapi.TransactionContextInterface) error) error {
+	ctx := s.begin(identity, transient)
+	err := fn(ctx)
+	s.end()
+	return err
+}
+
+// Advance moves the ledger clock used for transaction timestamps forward.
+func (s *ChaincodeStub) Advance(d time.Duration) {
+	s.Now = s.Now.Add(d)
+}
+
+func (s *ChaincodeStub) begin(identity *ClientIdentity, transient map[string][]byte) *contractapi.TransactionContext {
+	s.txCount++
+	s.txID = fmt.Sprintf("tx%d", s.txCount)
+	s.txTimestamp = &timestamp.Timestamp{Seconds: s.Now.Unix(), Nanos: int32(s.Now.Nanosecond())}
+	s.transient = transient
+	s.clientMSPID = identity.MSPID
+	s.peerMSPID = identity.MSPID
+	s.paginated = false
+	s.writes = make(map[string]*write)
+	s.pvtWrites = make(map[string]map[string]*write)
+	os.Setenv("CORE_PEER_LOCALMSPID", s.peerMSPID)
+
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(s)
+	ctx.SetClientIdentity(identity)
+	return ctx
+}
+
+func (s *ChaincodeStub) commit() {
+	for key, w := range s.writes {
+		if w.isDelete {
+			delete(s.state, key)
+		} else {
+			s.state[key] = w.value
+		}
+		s.history[key] = append(s.history[key], &queryresult.KeyModification{
+			TxId:      s.txID,
+			Value:     w.value,
+			Timestamp: s.txTimestamp,
+			IsDelete:  w.isDelete,
+		})
+	}
+	for collection, writes := range s.pvtWrites {
+		if s.private[collection] == nil {
+			s.private[collection] = make(map[string][]byte)
+		}
+		for key, w := range writes {
+			if w.isDelete {
+				delete(s.private[collection], key)
+			} else {
+				s.private[collection][key] = w.value
+			}
+		}
+	}
+}
+
+func (s *ChaincodeStub) end() {
+	s.writes = nil
+	s.pvtWrites = nil
+	s.transient = nil
+	s.function = ""
+	s.Advance(time.Minute)
+}
+
+// GetTxID returns the ID of the running transaction.
+func (s *ChaincodeStub) GetTxID() string {
+	return s.txID
+}
+
+// GetFunctionAndParameters returns the function set by Invoke. Transactions run
+// with Submit or Evaluate call the contract directly and have no function.
+func (s *ChaincodeStub) GetFunctionAndParameters() (string, []string) {
+	return s.function, nil
+}
+
+// GetChannelID returns the simulated channel name.
+func (s *ChaincodeStub) GetChannelID() string {
+	return s.ChannelID
+}
+
+// GetTxTimestamp returns the timestamp of the running transaction.
+func (s *ChaincodeStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return s.txTimestamp, nil
+}
+
+// GetTransient returns the transient map passed to Submit or Evaluate.
+func (s *ChaincodeStub) GetTransient() (map[string][]byte, error) {
+	if s.transient == nil {
+		return map[string][]byte{}, nil
+	}
+	return s.transient, nil
+}
+
+// SetEvent records the chaincode event of the running transaction.
+func (s *ChaincodeStub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return fmt.Errorf("event name can not be empty string")
+	}
+	s.Events[name] = payload
+	return nil
+}
+
+// GetState returns the committed value of key.
+func (s *ChaincodeStub) GetState(key string) ([]byte, error) {
+	return s.state[key], nil
+}
+
+// PutState writes key when the transaction commits.
+func (s *ChaincodeStub) PutState(key string, value []byte) error {
+	if err := s.checkNotPaginated(); err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	s.writes[key] = &write{value: value}
+	return nil
+}
+
+// DelState deletes key when the transaction commits.
+func (s *ChaincodeStub) DelState(key string) error {
+	if err := s.checkNotPaginated(); err != nil {
+		return err
+	}
+	s.writes[key] = &write{isDelete: true}
+	return nil
+}
+
+// GetStateByRange returns the simple keys in [startKey, endKey). Like the
+// peer, composite keys are never part of a range query.
+func (s *ChaincodeStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if strings.HasPrefix(startKey, compositeKeyNamespace) || strings.HasPrefix(endKey, compositeKeyNamespace) {
+		return nil, fmt.Errorf("range query keys must not be composite keys")
+	}
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	return newStateIterator(s.state, startKey, endKey), nil
+}
+
+// GetStateByPartialCompositeKey returns the composite keys that start with
+// objectType and keys.
+func (s *ChaincodeStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	partialKey, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return newStateIterator(s.state, partialKey, partialKey+string(maxUnicodeRuneValue)), nil
+}
+
+// GetStateByPartialCompositeKeyWithPagination returns a page of at most
+// pageSize composite keys that start with objectType and keys, starting at
+// bookmark. The bookmark is the first key of the next page, empty when there
+// are no more keys. Paginated queries are only allowed in read-only transactions.
+func (s *ChaincodeStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	partialKey, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	startKey := partialKey
+	if bookmark != "" && bookmark > startKey {
+		startKey = bookmark
+	}
+	return s.paginate(newStateIterator(s.state, startKey, partialKey+string(maxUnicodeRuneValue)), pageSize)
+}
+
+// GetStateByRangeWithPagination returns a page of at most pageSize simple keys
+// in [startKey, endKey), starting at bookmark. Like a LevelDB peer, the bookmark
+// is the first key of the next page, empty when there are no more keys.
+// Paginated queries are only allowed in read-only transactions.
+func (s *ChaincodeStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if bookmark != "" && bookmark > startKey {
+		startKey = bookmark
+	}
+	iterator, err := s.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.paginate(iterator.(*stateIterator), pageSize)
+}
+
+// paginate cuts the first page of pageSize results off it.
+func (s *ChaincodeStub) paginate(it *stateIterator, pageSize int32) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if len(s.writes) > 0 || len(s.pvtWrites) > 0 {
+		return nil, nil, fmt.Errorf("txid [%s]: paginated queries are not allowed in a read-write transaction", s.txID)
+	}
+	if pageSize <= 0 {
+		return nil, nil, fmt.Errorf("pageSize must be greater than zero")
+	}
+	s.paginated = true
+
+	metadata := &peer.QueryResponseMetadata{}
+	if len(it.results) > int(pageSize) {
+		metadata.Bookmark = it.results[pageSize].Key
+		it.results = it.results[:pageSize]
+	}
+	metadata.FetchedRecordsCount = int32(len(it.results))
+	return it, metadata, nil
+}
+
+// GetHistoryForKey returns the committed modifications of key, newest first.
+func (s *ChaincodeStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	modifications := s.history[key]
+	results := make([]*queryresult.KeyModification, 0, len(modifications))
+	for i := len(modifications) - 1; i >= 0; i-- {
+		results = append(results, modifications[i])
+	}
+	return &historyIterator{results: results}, nil
+}
+
+// CreateCompositeKey builds a composite key the same way the shim does.
+func (s *ChaincodeStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	if err := validateCompositeKeyAttribute(objectType); err != nil {
+		return "", err
+	}
+	ck := compositeKeyNamespace + objectType + string(rune(minUnicodeRuneValue))
+	for _, att := range attributes {
+		if err := validateCompositeKeyAttribute(att); err != nil {
+			return "", err
+		}
+		ck += att + string(rune(minUnicodeRuneValue))
+	}
+	return ck, nil
+}
+
+// SplitCompositeKey splits a composite key into its object type and attributes.
+func (s *ChaincodeStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	componentIndex := 1
+	components := []string{}
+	for i := 1; i < len(compositeKey); i++ {
+		if compositeKey[i] == minUnicodeRuneValue {
+			components = append(components, compositeKey[componentIndex:i])
+			componentIndex = i + 1
+		}
+	}
+	if len(components) == 0 {
+		return "", nil, fmt.Errorf("invalid composite key %q", compositeKey)
+	}
+	return components[0], components[1:], nil
+}
+
+// GetPrivateData returns the committed value of key in collection. The peer
+// must be a member of the collection.
+func (s *ChaincodeStub) GetPrivateData(collection, key string) ([]byte, error) {
+	if err := s.checkRead(collection); err != nil {
+		return nil, err
+	}
+	return s.private[collection][key], nil
+}
+
+// GetPrivateDataHash returns the SHA-256 hash of the committed value of key in
+// collection, or nil if the key does not exist. Any org can read the hash.
+func (s *ChaincodeStub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	if err := s.checkCollection(collection); err != nil {
+		return nil, err
+	}
+	value, ok := s.private[collection][key]
+	if !ok {
+		return nil, nil
+	}
+	hash := sha256.Sum256(value)
+	return hash[:], nil
+}
+
+// PutPrivateData writes key in collection when the transaction commits.
+func (s *ChaincodeStub) PutPrivateData(collection string, key string, value []byte) error {
+	if err := s.checkWrite(collection); err != nil {
+		return err
+	}
+	if err := s.checkNotPaginated(); err != nil {
+		return err
+	}
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	if len(value) == 0 {
+		return fmt.Errorf("value must not be empty")
+	}
+	s.privateWrites(collection)[key] = &write{value: value}
+	return nil
+}
+
+// DelPrivateData deletes key from collection when the transaction commits.
+func (s *ChaincodeStub) DelPrivateData(collection, key string) error {
+	if err := s.checkWrite(collection); err != nil {
+		return err
+	}
+	if err := s.checkNotPaginated(); err != nil {
+		return err
+	}
+	s.privateWrites(collection)[key] = &write{isDelete: true}
+	return nil
+}
+
+// GetPrivateDataByRange returns the simple keys of collection in [startKey, endKey).
+func (s *ChaincodeStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if err := s.checkRead(collection); err != nil {
+		return nil, err
+	}
+	if startKey == "" {
+		startKey = emptyKeySubstitute
+	}
+	return newStateIterator(s.private[collection], startKey, endKey), nil
+}
+
+// GetPrivateDataByPartialCompositeKey returns the composite keys of collection
+// that start with objectType and keys.
+func (s *ChaincodeStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	if err := s.checkRead(collection); err != nil {
+		return nil, err
+	}
+	partialKey, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return newStateIterator(s.private[collection], partialKey, partialKey+string(maxUnicodeRuneValue)), nil
+}
+
+// GetPrivateDataQueryResult behaves like a LevelDB state database, which has no rich queries.
+func (s *ChaincodeStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	if err := s.checkRead(collection); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("ExecuteQuery not supported for leveldb")
+}
+
+// checkNotPaginated fails a write after a paginated query, which the peer only allows in read-only transactions.
+func (s *ChaincodeStub) checkNotPaginated() error {
+	if s.paginated {
+		return fmt.Errorf("txid [%s]: the transaction has performed a paginated query, writes are not allowed", s.txID)
+	}
+	return nil
+}
+
+func (s *ChaincodeStub) privateWrites(collection string) map[string]*write {
+	if s.pvtWrites[collection] == nil {
+		s.pvtWrites[collection] = make(map[string]*write)
+	}
+	return s.pvtWrites[collection]
+}
+
+// members returns the orgs of collection; implicit collections belong to a single org.
+func (s *ChaincodeStub) members(collection string) ([]string, *CollectionConfig, error) {
+	if strings.HasPrefix(collection, implicitCollectionPrefix) {
+		return []string{strings.TrimPrefix(collection, implicitCollectionPrefix)}, nil, nil
+	}
+	config, ok := s.Collections[collection]
+	if !ok {
+		return nil, nil, fmt.Errorf("collection %s could not be found", collection)
+	}
+	return config.Members, config, nil
+}
+
+func (s *ChaincodeStub) checkCollection(collection string) error {
+	_, _, err := s.members(collection)
+	return err
+}
+
+func (s *ChaincodeStub) checkRead(collection string) error {
+	members, _, err := s.members(collection)
+	if err != nil {
+		return err
+	}
+	if !contains(members, s.peerMSPID) {
+		return fmt.Errorf("peer of org %s is not a member of collection %s", s.peerMSPID, collection)
+	}
+	return nil
+}
+
+// checkWrite applies memberOnlyWrite. Implicit collections accept writes from any org.
+func (s *ChaincodeStub) checkWrite(collection string) error {
+	members, config, err := s.members(collection)
+	if err != nil {
+		return err
+	}
+	if config != nil && config.MemberOnlyWrite && !contains(members, s.clientMSPID) {
+		return fmt.Errorf("tx creator does not have write access permission on privatedata in collection %s", collection)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validateCompositeKeyAttribute(str string) error {
+	if !utf8.ValidString(str) {
+		return fmt.Errorf("not a valid utf8 string: [%x]", str)
+	}
+	for index, runeValue := range str {
+		if runeValue == minUnicodeRuneValue || runeValue == maxUnicodeRuneValue {
+			return fmt.Errorf("input contains unicode %#U starting at position [%d]. %#U and %#U are not allowed in the input attribute of a composite key",
+				runeValue, index, minUnicodeRuneValue, maxUnicodeRuneValue)
+		}
+	}
+	return nil
+}
+
+// stateIterator iterates over a sorted snapshot of key/value pairs.
+type stateIterator struct {
+	results []*queryresult.KV
+	next    int
+}
+
+func newStateIterator(values map[string][]byte, startKey, endKey string) *stateIterator {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	iterator := &stateIterator{}
+	for _, key := range keys {
+		iterator.results = append(iterator.results, &queryresult.KV{Key: key, Value: values[key]})
+	}
+	return iterator
+}
+
+func (it *stateIterator) HasNext() bool {
+	return it.next < len(it.results)
+}
+
+func (it *stateIterator) Next() (*queryresult.KV, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+	it.next++
+	return it.results[it.next-1], nil
+}
+
+func (it *stateIterator) Close() error {
+	return nil
+}
+
+// historyIterator iterates over the modifications of a single key.
+type historyIterator struct {
+	results []*queryresult.KeyModification
+	next    int
+}
+
+func (it *historyIterator) HasNext() bool {
+	return it.next < len(it.results)
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if !it.HasNext() {
+		return nil, fmt.Errorf("no more results")
+	}
+	it.next++
+	return it.results[it.next-1], nil
+}
+
+func (it *historyIterator) Close() error {
+	return nil
+}
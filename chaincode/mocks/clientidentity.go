@@ -0,0 +1,72 @@
+package mocks
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// ClientIdentity is a cid.ClientIdentity for a client enrolled by the CA of its
+// org, with the attributes (e.g. farmer=true) that would be in its certificate.
+type ClientIdentity struct {
+	MSPID      string
+	Name       string
+	Attributes map[string]string
+}
+
+// NewClientIdentity returns the identity of client name from org mspID.
+func NewClientIdentity(mspID string, name string, attributes map[string]string) *ClientIdentity {
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	return &ClientIdentity{MSPID: mspID, Name: name, Attributes: attributes}
+}
+
+// DN returns the identity string in the form the peer builds it from the
+// certificate subject and issuer, before base64 encoding.
+func (c *ClientIdentity) DN() string {
+	domain := strings.ToLower(strings.TrimSuffix(c.MSPID, "MSP")) + ".example.com"
+	return fmt.Sprintf("x509::CN=%s,OU=client::CN=ca.%s,O=%s,L=Durham,ST=North Carolina,C=US", c.Name, domain, domain)
+}
+
+// GetID returns the base64 encoded identity string.
+func (c *ClientIdentity) GetID() (string, error) {
+	return base64.StdEncoding.EncodeToString([]byte(c.DN())), nil
+}
+
+// GetMSPID returns the MSP ID of the client's org.
+func (c *ClientIdentity) GetMSPID() (string, error) {
+	return c.MSPID, nil
+}
+
+// GetAttributeValue returns the value of a certificate attribute.
+func (c *ClientIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	value, found := c.Attributes[attrName]
+	return value, found, nil
+}
+
+// AssertAttributeValue fails unless the attribute is present with attrValue.
+func (c *ClientIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	value, found, err := c.GetAttributeValue(attrName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("Attribute '%s' was not found", attrName)
+	}
+	if value != attrValue {
+		return fmt.Errorf("Attribute '%s' equals '%s' instead of '%s'", attrName, value, attrValue)
+	}
+	return nil
+}
+
+// GetX509Certificate returns a certificate carrying only the subject and issuer names.
+func (c *ClientIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	domain := strings.ToLower(strings.TrimSuffix(c.MSPID, "MSP")) + ".example.com"
+	return &x509.Certificate{
+		Subject: pkix.Name{CommonName: c.Name, OrganizationalUnit: []string{"client"}},
+		Issuer:  pkix.Name{CommonName: "ca." + domain, Organization: []string{domain}},
+	}, nil
+}
@@ -0,0 +1,45 @@
+package mocks
+
+import (
+	"reflect"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Invoke returns fn run the way contractapi runs function of contract: with a new
+// transaction context of the contract's type, between the contract's before and
+// after transaction hooks. The stub reports contract:function as the function called.
+// fn has to run in a transaction started by Submit or Evaluate.
+func Invoke(contract contractapi.ContractInterface, function string, fn func(ctx contractapi.TransactionContextInterface) error) func(ctx contractapi.TransactionContextInterface) error {
+	return func(ctx contractapi.TransactionContextInterface) error {
+		stub := ctx.GetStub().(*ChaincodeStub)
+		stub.function = contract.GetName() + ":" + function
+
+		handler := contract.GetTransactionContextHandler()
+		txCtx := reflect.New(reflect.TypeOf(handler).Elem()).Interface().(contractapi.SettableTransactionContextInterface)
+		txCtx.SetStub(stub)
+		txCtx.SetClientIdentity(ctx.GetClientIdentity())
+
+		err := callHook(contract.GetBeforeTransaction(), txCtx)
+		if err != nil {
+			return err
+		}
+		err = fn(txCtx)
+		if err != nil {
+			return err
+		}
+		return callHook(contract.GetAfterTransaction(), txCtx)
+	}
+}
+
+// callHook calls a before or after transaction hook that takes the transaction context and may return an error
+func callHook(hook interface{}, ctx contractapi.TransactionContextInterface) error {
+	if hook == nil {
+		return nil
+	}
+	results := reflect.ValueOf(hook).Call([]reflect.Value{reflect.ValueOf(ctx)})
+	if len(results) > 0 && !results[len(results)-1].IsNil() {
+		return results[len(results)-1].Interface().(error)
+	}
+	return nil
+}
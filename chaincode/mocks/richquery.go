@@ -0,0 +1,203 @@
+package mocks
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// richQuery is the subset of the CouchDB query syntax the stub understands:
+// a selector of top-level fields that are either equal to a value or compared
+// with $eq, $ne, $gt, $gte, $lt and $lte, and a sort on top-level fields.
+type richQuery struct {
+	Selector map[string]interface{} `json:"selector"`
+	Sort     []interface{}          `json:"sort"`
+}
+
+type sortField struct {
+	name string
+	desc bool
+}
+
+// GetQueryResult runs a CouchDB query on the committed world state when
+// RichQueries is set, and fails like a LevelDB state database otherwise.
+// Like CouchDB, every JSON document in the namespace is queried, including
+// the ones stored under composite keys.
+func (s *ChaincodeStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	if !s.RichQueries {
+		return nil, fmt.Errorf("ExecuteQuery not supported for leveldb")
+	}
+	results, err := s.runRichQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stateIterator{results: results}, nil
+}
+
+// GetQueryResultWithPagination runs a CouchDB query like GetQueryResult and
+// returns a page of at most pageSize documents, starting at bookmark.
+func (s *ChaincodeStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if !s.RichQueries {
+		return nil, nil, fmt.Errorf("ExecuteQuery not supported for leveldb")
+	}
+	results, err := s.runRichQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if bookmark != "" {
+		start := len(results)
+		for i, result := range results {
+			if result.Key == bookmark {
+				start = i
+				break
+			}
+		}
+		results = results[start:]
+	}
+	return s.paginate(&stateIterator{results: results}, pageSize)
+}
+
+func (s *ChaincodeStub) runRichQuery(query string) ([]*queryresult.KV, error) {
+	var q richQuery
+	err := json.Unmarshal([]byte(query), &q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+	if q.Selector == nil {
+		return nil, fmt.Errorf("invalid query: selector is required")
+	}
+	sortFields, err := parseSort(q.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	type match struct {
+		kv  *queryresult.KV
+		doc map[string]interface{}
+	}
+	var matches []match
+	keys := make([]string, 0, len(s.state))
+	for key := range s.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var doc map[string]interface{}
+		if json.Unmarshal(s.state[key], &doc) != nil {
+			continue
+		}
+		ok, err := matchesSelector(doc, q.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, match{&queryresult.KV{Key: key, Value: s.state[key]}, doc})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		for _, field := range sortFields {
+			c, ok := compare(matches[i].doc[field.name], matches[j].doc[field.name])
+			if ok && c != 0 {
+				return (c < 0) != field.desc
+			}
+		}
+		return false
+	})
+
+	results := make([]*queryresult.KV, len(matches))
+	for i, m := range matches {
+		results[i] = m.kv
+	}
+	return results, nil
+}
+
+func parseSort(fields []interface{}) ([]sortField, error) {
+	var parsed []sortField
+	for _, field := range fields {
+		switch f := field.(type) {
+		case string:
+			parsed = append(parsed, sortField{name: f})
+		case map[string]interface{}:
+			for name, dir := range f {
+				parsed = append(parsed, sortField{name: name, desc: dir == "desc"})
+			}
+		default:
+			return nil, fmt.Errorf("invalid sort field %v", field)
+		}
+	}
+	return parsed, nil
+}
+
+func matchesSelector(doc map[string]interface{}, selector map[string]interface{}) (bool, error) {
+	for field, condition := range selector {
+		value, present := doc[field]
+		operators, isOperators := condition.(map[string]interface{})
+		if !isOperators {
+			operators = map[string]interface{}{"$eq": condition}
+		}
+		for operator, operand := range operators {
+			if !present {
+				return false, nil
+			}
+			c, comparable := compare(value, operand)
+			var ok bool
+			switch operator {
+			case "$eq":
+				ok = comparable && c == 0
+			case "$ne":
+				ok = !comparable || c != 0
+			case "$gt":
+				ok = comparable && c > 0
+			case "$gte":
+				ok = comparable && c >= 0
+			case "$lt":
+				ok = comparable && c < 0
+			case "$lte":
+				ok = comparable && c <= 0
+			default:
+				return false, fmt.Errorf("operator %s is not supported by the stub", operator)
+			}
+			if !ok {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// compare orders JSON values of the same type. ok is false for values of
+// different types, which are neither equal nor ordered.
+func compare(a, b interface{}) (c int, ok bool) {
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case float64:
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+	case bool:
+		if y, ok := b.(bool); ok && x == y {
+			return 0, true
+		}
+	case nil:
+		if b == nil {
+			return 0, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,476 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const negotiationObjectType = "Negotiation"
+const negotiationOfferObjectType = "NegotiationOffer"
+
+const (
+	negotiationOpen      = "open"
+	negotiationCountered = "countered"
+	negotiationAccepted  = "accepted"
+	negotiationWithdrawn = "withdrawn"
+	negotiationExpired   = "expired"
+)
+
+// Negotiation is a price negotiation between the owner of an asset and a buyer, kept under
+// Negotiation~assetID~buyerID in the collection their orgs share. The buyer opens it with an offer,
+// then the sides take turns countering until one of them accepts the last offer or either withdraws.
+// The offers are price terms, each for the weight the buyer would buy at its price. A negotiation whose
+// last offer has expired is over, PruneExpiredTradingState marks it as expired.
+type Negotiation struct {
+	AssetID   string              `json:"assetID"`
+	BuyerID   string              `json:"buyerID"`
+	BuyerOrg  string              `json:"buyerOrg"`
+	Seller    string              `json:"seller"`
+	SellerOrg string              `json:"sellerOrg"`
+	Status    string              `json:"status"`
+	Offers    []*NegotiationOffer `json:"offers"`
+	UpdatedAt time.Time           `json:"updatedAt"`
+}
+
+// NegotiationOffer is an offer of a negotiation. The price stays in the implicit collection of the
+// org that made it, the negotiation only has its hash.
+type NegotiationOffer struct {
+	Round     int       `json:"round"`
+	From      string    `json:"from"`
+	FromOrg   string    `json:"fromOrg"`
+	PriceHash string    `json:"priceHash"`
+	TxID      string    `json:"txID"`
+	Timestamp time.Time `json:"timestamp"`
+	// ValidUntil is the end of the validity window passed under valid_for, nil when the offer does not expire
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
+}
+
+// expiredAt reports that the negotiation is open or countered and its last offer has expired at now
+func (n *Negotiation) expiredAt(now time.Time) bool {
+	if n.Status != negotiationOpen && n.Status != negotiationCountered || len(n.Offers) == 0 {
+		return false
+	}
+	validUntil := n.Offers[len(n.Offers)-1].ValidUntil
+	return validUntil != nil && !now.Before(*validUntil)
+}
+
+// OpenNegotiation starts a negotiation with the owner of an asset. The buyer's first offer is
+// passed in the transient map under asset_price, like a bid. Offers take an optional validity window
+// under valid_for, like bids.
+func (s *MarketContract) OpenNegotiation(ctx contractapi.TransactionContextInterface, assetID string) error {
+	asset, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	err = verifyNotConsumed(asset)
+	if err != nil {
+		return err
+	}
+	err = verifyNotExpired(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	buyerID, err := s.getSubmittingClientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	buyerMSP, err := getClientMSPID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting the client's MSPID: %v", err)
+	}
+	sharedCollection, err := s.getSharedCollection(ctx, asset.OwnerOrg, buyerMSP)
+	if err != nil {
+		return err
+	}
+
+	negotiation, err := readNegotiation(ctx, sharedCollection, assetID, buyerID)
+	if err != nil {
+		return err
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if negotiation != nil && (negotiation.Status == negotiationOpen || negotiation.Status == negotiationCountered) && !negotiation.expiredAt(now) {
+		return fmt.Errorf("a negotiation of %s for asset %s is already %s", buyerID, assetID, negotiation.Status)
+	}
+
+	negotiation = &Negotiation{
+		AssetID:   assetID,
+		BuyerID:   buyerID,
+		BuyerOrg:  buyerMSP,
+		Seller:    asset.Owner,
+		SellerOrg: asset.OwnerOrg,
+		Status:    negotiationOpen,
+		Offers:    []*NegotiationOffer{},
+	}
+	err = s.addOffer(ctx, negotiation, asset)
+	if err != nil {
+		return err
+	}
+	log.Printf("OpenNegotiation: %v for %v with %v", buyerID, assetID, asset.Owner)
+	return putNegotiation(ctx, sharedCollection, negotiation)
+}
+
+// CounterOffer answers the last offer of a negotiation with a new price, passed in the transient map
+// under asset_price. Only the side that did not make the last offer can counter it.
+func (s *MarketContract) CounterOffer(ctx contractapi.TransactionContextInterface, assetID string, buyerID string) error {
+	negotiation, asset, sharedCollection, err := s.readActiveNegotiation(ctx, assetID, buyerID)
+	if err != nil {
+		return err
+	}
+	err = s.verifyTurn(ctx, negotiation)
+	if err != nil {
+		return err
+	}
+
+	err = s.addOffer(ctx, negotiation, asset)
+	if err != nil {
+		return err
+	}
+	negotiation.Status = negotiationCountered
+	return putNegotiation(ctx, sharedCollection, negotiation)
+}
+
+// AcceptOffer accepts the last offer of a negotiation. The side that did not make it passes the same
+// price terms under asset_price, which must match the hash of the offer. The agreed terms become the
+// seller's ask to this buyer, kept apart from the ask to other buyers, and the buyer's bid, both valid
+// as long as the offer, and the buyer's pending buy request is set to the quantity of the terms, or one
+// is placed, so the seller can complete the sale with TransferRequestedAsset.
+func (s *MarketContract) AcceptOffer(ctx contractapi.TransactionContextInterface, assetID string, buyerID string) error {
+	negotiation, asset, sharedCollection, err := s.readActiveNegotiation(ctx, assetID, buyerID)
+	if err != nil {
+		return err
+	}
+	err = s.verifyTurn(ctx, negotiation)
+	if err != nil {
+		return err
+	}
+
+	price, terms, err := getTransientPriceTerms(ctx)
+	if err != nil {
+		return err
+	}
+	lastOffer := negotiation.Offers[len(negotiation.Offers)-1]
+	if priceHash(price) != lastOffer.PriceHash {
+		return fmt.Errorf("asset_price does not match offer %d of the negotiation", lastOffer.Round)
+	}
+	err = verifyTermsQuantity(terms, asset)
+	if err != nil {
+		return err
+	}
+
+	// the agreed price is written as the ask and the bid that verifyAgreement compares, both valid as long as the offer
+	sellerCollection := "_implicit_org_" + negotiation.SellerOrg
+	assetPriceKey, err := negotiatedAskKey(ctx, assetID, buyerID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(sellerCollection, assetPriceKey, price)
+	if err != nil {
+		return fmt.Errorf("failed to put agreed price for seller: %v", err)
+	}
+	askValidityKey, err := validityKey(ctx, typeNegotiatedAsk, assetID, buyerID)
+	if err != nil {
+		return err
+	}
+	err = putOrgValidity(ctx, sellerCollection, askValidityKey, lastOffer.ValidUntil, negotiation.SellerOrg)
+	if err != nil {
+		return err
+	}
+
+	assetBidKey, err := bidKey(ctx, assetID, buyerID)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData("_implicit_org_"+negotiation.BuyerOrg, assetBidKey, price)
+	if err != nil {
+		return fmt.Errorf("failed to put agreed price for buyer: %v", err)
+	}
+	bidValidityKey, err := validityKey(ctx, typeAssetBid, assetID, buyerID)
+	if err != nil {
+		return err
+	}
+	err = putOrgValidity(ctx, sharedCollection, bidValidityKey, lastOffer.ValidUntil, negotiation.BuyerOrg)
+	if err != nil {
+		return err
+	}
+
+	// the buy request is for the quantity of the terms, a pending one for another quantity is changed to it
+	request, err := s.readRequestToBuy(ctx, assetID, buyerID, sharedCollection)
+	if err != nil {
+		return err
+	}
+	placed := request == nil || request.Status != buyRequestPending
+	if placed {
+		timestamp, err := getTxTime(ctx)
+		if err != nil {
+			return err
+		}
+		request = &RequestToBuyObject{
+			ID:        assetID,
+			BuyerID:   buyerID,
+			BuyerOrg:  negotiation.BuyerOrg,
+			Seller:    negotiation.Seller,
+			SellerOrg: negotiation.SellerOrg,
+			Status:    buyRequestPending,
+			Timestamp: timestamp,
+		}
+	}
+	if placed || !sameQuantity(request.Quantity, &terms.Quantity) {
+		request.Quantity = &terms.Quantity
+		err = putBuyRequest(ctx, sharedCollection, request)
+		if err != nil {
+			return err
+		}
+	}
+
+	negotiation.Status = negotiationAccepted
+	negotiation.UpdatedAt, err = getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("AcceptOffer: %v for %v at offer %d", buyerID, assetID, lastOffer.Round)
+	return putNegotiation(ctx, sharedCollection, negotiation)
+}
+
+// WithdrawNegotiation ends a negotiation that has not been accepted. Either side can withdraw, also
+// after the asset has changed hands.
+func (s *MarketContract) WithdrawNegotiation(ctx contractapi.TransactionContextInterface, assetID string, buyerID string) error {
+	negotiation, sharedCollection, err := s.findNegotiation(ctx, assetID, buyerID)
+	if err != nil {
+		return err
+	}
+	_, err = s.negotiationSide(ctx, negotiation)
+	if err != nil {
+		return err
+	}
+	if negotiation.Status != negotiationOpen && negotiation.Status != negotiationCountered {
+		return fmt.Errorf("the negotiation of %s for asset %s is %s", buyerID, assetID, negotiation.Status)
+	}
+
+	negotiation.Status = negotiationWithdrawn
+	negotiation.UpdatedAt, err = getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	return putNegotiation(ctx, sharedCollection, negotiation)
+}
+
+// GetNegotiation returns the negotiation of buyerID for an asset, to the buyer and to the owner
+func (s *QueryContract) GetNegotiation(ctx contractapi.TransactionContextInterface, assetID string, buyerID string) (*Negotiation, error) {
+	negotiation, _, err := s.findNegotiation(ctx, assetID, buyerID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.negotiationSide(ctx, negotiation)
+	if err != nil {
+		return nil, err
+	}
+	return negotiation, nil
+}
+
+// GetMyOfferPrice returns the price of an offer of the caller's org, from its implicit collection
+func (s *QueryContract) GetMyOfferPrice(ctx contractapi.TransactionContextInterface, assetID string, buyerID string, round int) (string, error) {
+	offerKey, err := ctx.GetStub().CreateCompositeKey(negotiationOfferObjectType, []string{assetID, buyerID, fmt.Sprint(round)})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return getAssetPrice(ctx, assetID, offerKey)
+}
+
+// addOffer keeps the price of the caller's offer in its implicit collection and appends its hash to the
+// negotiation. The terms must be for a quantity in the weight unit of the asset, like an ask or a bid.
+func (s *SmartContract) addOffer(ctx contractapi.TransactionContextInterface, negotiation *Negotiation, asset *Asset) error {
+	err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return err
+	}
+	price, terms, err := getTransientPriceTerms(ctx)
+	if err != nil {
+		return err
+	}
+	err = verifyTermsQuantity(terms, asset)
+	if err != nil {
+		return err
+	}
+	clientID, err := s.getSubmittingClientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	clientOrgID, err := getClientMSPID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+	timestamp, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	validUntil, err := validUntilFromTransient(ctx)
+	if err != nil {
+		return err
+	}
+
+	offer := &NegotiationOffer{
+		Round:      len(negotiation.Offers) + 1,
+		From:       clientID,
+		FromOrg:    clientOrgID,
+		PriceHash:  priceHash(price),
+		TxID:       ctx.GetStub().GetTxID(),
+		Timestamp:  timestamp,
+		ValidUntil: validUntil,
+	}
+	offerKey, err := ctx.GetStub().CreateCompositeKey(negotiationOfferObjectType, []string{negotiation.AssetID, negotiation.BuyerID, fmt.Sprint(offer.Round)})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	err = ctx.GetStub().PutPrivateData("_implicit_org_"+clientOrgID, offerKey, price)
+	if err != nil {
+		return fmt.Errorf("failed to put offer price: %v", err)
+	}
+
+	negotiation.Offers = append(negotiation.Offers, offer)
+	negotiation.UpdatedAt = timestamp
+	return nil
+}
+
+// readActiveNegotiation returns a negotiation that is open or countered and whose last offer has not expired,
+// on an asset that is still held by the seller
+func (s *SmartContract) readActiveNegotiation(ctx contractapi.TransactionContextInterface, assetID string, buyerID string) (*Negotiation, *Asset, string, error) {
+	negotiation, sharedCollection, err := s.findNegotiation(ctx, assetID, buyerID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if negotiation.Status != negotiationOpen && negotiation.Status != negotiationCountered {
+		return nil, nil, "", fmt.Errorf("the negotiation of %s for asset %s is %s", buyerID, assetID, negotiation.Status)
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if negotiation.expiredAt(now) {
+		lastOffer := negotiation.Offers[len(negotiation.Offers)-1]
+		return nil, nil, "", fmt.Errorf("offer %d of the negotiation of %s for asset %s expired at %v", lastOffer.Round, buyerID, assetID, lastOffer.ValidUntil.Format(time.RFC3339))
+	}
+
+	asset, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if asset.Owner != negotiation.Seller || asset.OwnerOrg != negotiation.SellerOrg {
+		return nil, nil, "", fmt.Errorf("asset %s is no longer held by %s", assetID, negotiation.Seller)
+	}
+	err = verifyNotConsumed(asset)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	err = verifyNotExpired(ctx, asset)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return negotiation, asset, sharedCollection, nil
+}
+
+// findNegotiation looks for the negotiation of buyerID for an asset in the collections of the caller's org
+func (s *SmartContract) findNegotiation(ctx contractapi.TransactionContextInterface, assetID string, buyerID string) (*Negotiation, string, error) {
+	clientOrgID, err := getClientMSPID(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+	collections, err := s.sharedCollectionsOf(ctx, clientOrgID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var found *Negotiation
+	var foundCollection string
+	for _, collection := range collections {
+		negotiation, err := readNegotiation(ctx, collection, assetID, buyerID)
+		if err != nil {
+			return nil, "", err
+		}
+		if negotiation == nil {
+			continue
+		}
+		if found != nil {
+			return nil, "", fmt.Errorf("%s negotiates asset %s in both %s and %s", buyerID, assetID, foundCollection, collection)
+		}
+		found, foundCollection = negotiation, collection
+	}
+	if found == nil {
+		return nil, "", fmt.Errorf("no negotiation of %s for asset %s exists", buyerID, assetID)
+	}
+	return found, foundCollection, nil
+}
+
+// negotiationSide returns the org of the caller in a negotiation, the buyer's or the seller's
+func (s *SmartContract) negotiationSide(ctx contractapi.TransactionContextInterface, negotiation *Negotiation) (string, error) {
+	clientID, err := s.getSubmittingClientIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+	clientOrgID, err := getClientMSPID(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+	if (clientID == negotiation.BuyerID && clientOrgID == negotiation.BuyerOrg) || (clientID == negotiation.Seller && clientOrgID == negotiation.SellerOrg) {
+		return clientOrgID, nil
+	}
+	return "", fmt.Errorf("submitting client is not a side of the negotiation of %s for asset %s", negotiation.BuyerID, negotiation.AssetID)
+}
+
+// verifyTurn checks that the caller is a side of the negotiation and did not make the last offer
+func (s *SmartContract) verifyTurn(ctx contractapi.TransactionContextInterface, negotiation *Negotiation) error {
+	clientOrgID, err := s.negotiationSide(ctx, negotiation)
+	if err != nil {
+		return err
+	}
+	// the buyer and the seller are always from different orgs, the ones sharing the collection
+	lastOffer := negotiation.Offers[len(negotiation.Offers)-1]
+	if lastOffer.FromOrg == clientOrgID {
+		return fmt.Errorf("%s made the last offer, it is the other side's turn", lastOffer.From)
+	}
+	return nil
+}
+
+func readNegotiation(ctx contractapi.TransactionContextInterface, sharedCollection string, assetID string, buyerID string) (*Negotiation, error) {
+	negotiationKey, err := ctx.GetStub().CreateCompositeKey(negotiationObjectType, []string{assetID, buyerID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	negotiationJSON, err := ctx.GetStub().GetPrivateData(sharedCollection, negotiationKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read negotiation from %s: %v", sharedCollection, err)
+	}
+	if negotiationJSON == nil {
+		return nil, nil
+	}
+
+	var negotiation Negotiation
+	err = json.Unmarshal(negotiationJSON, &negotiation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	return &negotiation, nil
+}
+
+func putNegotiation(ctx contractapi.TransactionContextInterface, sharedCollection string, negotiation *Negotiation) error {
+	negotiationKey, err := ctx.GetStub().CreateCompositeKey(negotiationObjectType, []string{negotiation.AssetID, negotiation.BuyerID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	negotiationJSON, err := json.Marshal(negotiation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal negotiation into JSON: %v", err)
+	}
+	err = ctx.GetStub().PutPrivateData(sharedCollection, negotiationKey, negotiationJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put negotiation to %s: %v", sharedCollection, err)
+	}
+	return nil
+}
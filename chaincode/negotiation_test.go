@@ -0,0 +1,222 @@
+package chaincode_test
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+	"phase2/chaincode/mocks"
+)
+
+func TestNegotiation(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+
+	open := func(ctx contractapi.TransactionContextInterface) error { return sc.OpenNegotiation(ctx, "asset1") }
+	counter := func(ctx contractapi.TransactionContextInterface) error {
+		return sc.CounterOffer(ctx, "asset1", "RetailerO")
+	}
+	accept := func(ctx contractapi.TransactionContextInterface) error {
+		return sc.AcceptOffer(ctx, "asset1", "RetailerO")
+	}
+	withdraw := func(ctx contractapi.TransactionContextInterface) error {
+		return sc.WithdrawNegotiation(ctx, "asset1", "RetailerO")
+	}
+	transfer := func(ctx contractapi.TransactionContextInterface) error { return sc.TransferRequestedAsset(ctx) }
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"offer needs a price", retailer, nil, open, "asset_price key not found"},
+		{"retailer offers 80", retailer, price("80", 15), open, ""},
+		{"negotiation is already open", retailer, price("85", 15), open, "already open"},
+		{"retailer cannot counter its own offer", retailer, price("85", 15), counter, "other side's turn"},
+		{"supermarket is not a side", supermarket, price("85", 15), counter, "no negotiation of RetailerO"},
+		{"farmer counters 100", farmer, price("100", 15), counter, ""},
+		{"farmer cannot accept its own offer", farmer, price("100", 15), accept, "other side's turn"},
+		{"retailer counters 90", retailer, price("90", 15), counter, ""},
+		{"accepted price must match the offer", farmer, price("95", 15), accept, "does not match offer 3"},
+		{"farmer accepts 90", farmer, price("90", 15), accept, ""},
+		{"accepted negotiation cannot be countered", retailer, price("85", 15), counter, "is accepted"},
+		{"accepted negotiation cannot be withdrawn", retailer, nil, withdraw, "is accepted"},
+	})
+
+	var negotiation *chaincode.Negotiation
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		negotiation, err = sc.GetNegotiation(ctx, "asset1", "RetailerO")
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, "accepted", negotiation.Status)
+	require.Len(t, negotiation.Offers, 3)
+	require.Equal(t, []string{"RetailerO", "FarmerO", "RetailerO"}, []string{negotiation.Offers[0].From, negotiation.Offers[1].From, negotiation.Offers[2].From})
+
+	err = stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		offer, err := sc.GetMyOfferPrice(ctx, "asset1", "RetailerO", 2)
+		require.Equal(t, string(price("100", 15)["asset_price"]), offer)
+		return err
+	})
+	require.NoError(t, err)
+
+	// the accepted offer is the ask and the bid of the existing transfer
+	run(t, stub, []step{
+		{"farmer transfers to retailer", farmer, transferTo(t, "asset1", retailer), transfer, ""},
+	})
+	require.Equal(t, "RetailerO", readAsset(t, stub, "asset1").Owner)
+
+	err = stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		receipts, err := sc.ListMyReceipts(ctx)
+		require.Len(t, receipts, 1)
+		require.Equal(t, chaincode.Money{Amount: 90 * 15, Currency: "EUR"}, receipts[0].Price)
+		return err
+	})
+	require.NoError(t, err)
+}
+
+func TestNegotiationWithdrawn(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"retailer offers 80", retailer, price("80", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.OpenNegotiation(ctx, "asset1")
+		}, ""},
+		{"other farmer cannot withdraw", farmer2, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.WithdrawNegotiation(ctx, "asset1", "RetailerO")
+		}, "not a side of the negotiation"},
+		{"farmer withdraws", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.WithdrawNegotiation(ctx, "asset1", "RetailerO")
+		}, ""},
+		{"withdrawn offer cannot be accepted", farmer, price("80", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AcceptOffer(ctx, "asset1", "RetailerO")
+		}, "is withdrawn"},
+		{"retailer opens a new negotiation", retailer, price("85", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.OpenNegotiation(ctx, "asset1")
+		}, ""},
+		{"farmer splits the lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SplitAsset(ctx, "asset1", []string{"lot1", "lot2"}, []float64{5, 10}, "kg")
+		}, ""},
+		{"offer on a split lot cannot be accepted", farmer, price("85", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AcceptOffer(ctx, "asset1", "RetailerO")
+		}, "can no longer be changed"},
+	})
+}
+
+func TestAcceptCounterOffer(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	retailer2 := mocks.NewClientIdentity("Org2MSP", "RetailerT", map[string]string{"retailer": "true"})
+	fund(t, stub, retailer2, 100000)
+	allow(t, stub, retailer2, farmer, 100000)
+	transfer := func(ctx contractapi.TransactionContextInterface) error { return sc.TransferRequestedAsset(ctx) }
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"farmer asks 120 for the lot", farmer, price("120", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, ""},
+		{"retailer requests 10 kg", retailer, quantity(nil, "10"), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, ""},
+		{"retailer offers 80 for 5 kg", retailer, price("80", 5), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.OpenNegotiation(ctx, "asset1")
+		}, ""},
+		{"farmer counters 90", farmer, price("90", 5), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CounterOffer(ctx, "asset1", "RetailerO")
+		}, ""},
+		{"more than the lot", retailer2, price("100", 20), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.OpenNegotiation(ctx, "asset1")
+		}, "terms are for 20 kg but only 15 kg of asset asset1 is left"},
+		{"retailer accepts the counter", retailer, price("90", 5), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AcceptOffer(ctx, "asset1", "RetailerO")
+		}, ""},
+		{"second retailer offers 100 for 5 kg", retailer2, price("100", 5), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.OpenNegotiation(ctx, "asset1")
+		}, ""},
+		{"farmer accepts the second retailer", farmer, price("100", 5), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AcceptOffer(ctx, "asset1", "RetailerT")
+		}, ""},
+	})
+
+	// the ask to the other buyers is left as it was and the pending request is for the agreed quantity
+	err := stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		ask, err := sc.GetAssetSalesPrice(ctx, "asset1")
+		require.Equal(t, string(price("120", 15)["asset_price"]), ask)
+		return err
+	})
+	require.NoError(t, err)
+	err = stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		requests, err := sc.ListBuyRequests(ctx, "asset1")
+		require.Len(t, requests, 2)
+		for _, request := range requests {
+			require.Equal(t, kg(5), *request.Quantity, request.BuyerID)
+		}
+		return err
+	})
+	require.NoError(t, err)
+
+	// each sale is at the price negotiated with its buyer
+	run(t, stub, []step{
+		{"farmer sells to retailer", farmer, transferTo(t, "asset1", retailer), transfer, ""},
+		{"farmer sells to second retailer", farmer, transferTo(t, "asset1", retailer2), transfer, ""},
+	})
+	require.Equal(t, 100000-90*5, balanceOf(t, stub, retailer))
+	require.Equal(t, 100000-100*5, balanceOf(t, stub, retailer2))
+	require.Equal(t, kg(5), readAsset(t, stub, "asset1").Weight)
+}
+
+func TestFullSaleDeletesEveryAsk(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	retailer2 := mocks.NewClientIdentity("Org2MSP", "RetailerT", map[string]string{"retailer": "true"})
+	fund(t, stub, retailer2, 100000)
+	allow(t, stub, retailer2, farmer, 100000)
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"farmer asks 120 for a day", farmer, validFor(price("120", 15), "24h"), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, ""},
+		{"retailer offers 90", retailer, price("90", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.OpenNegotiation(ctx, "asset1")
+		}, ""},
+		{"farmer accepts the retailer", farmer, price("90", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AcceptOffer(ctx, "asset1", "RetailerO")
+		}, ""},
+		{"second retailer offers 100 for a day", retailer2, validFor(price("100", 15), "24h"), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.OpenNegotiation(ctx, "asset1")
+		}, ""},
+		{"farmer accepts the second retailer", farmer, price("100", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AcceptOffer(ctx, "asset1", "RetailerT")
+		}, ""},
+		{"farmer sells the lot to the retailer", farmer, transferTo(t, "asset1", retailer), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferRequestedAsset(ctx)
+		}, ""},
+	})
+
+	// neither the ask of SetPrice, nor its window, nor the ask negotiated with the second retailer and its window is left
+	err := stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.GetAssetSalesPrice(ctx, "asset1")
+		require.ErrorContains(t, err, "does not exist")
+		for _, key := range [][]string{{"ValidUntil", "S", "asset1"}, {"NS", "asset1", "RetailerO"}, {"NS", "asset1", "RetailerT"}, {"ValidUntil", "NS", "asset1", "RetailerT"}} {
+			compositeKey, err := ctx.GetStub().CreateCompositeKey(key[0], key[1:])
+			require.NoError(t, err)
+			value, err := ctx.GetStub().GetPrivateData("_implicit_org_Org1MSP", compositeKey)
+			require.NoError(t, err)
+			require.Nil(t, value, key)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
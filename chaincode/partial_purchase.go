@@ -0,0 +1,137 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// A buyer can buy part of a lot. The price terms of the ask and the bid (see price_terms.go) are a price
+// per weight unit for a quantity, and the buyer passes the same weight under quantity in the transient map
+// of RequestToBuy, which keeps it off the public proposal. The transfer charges the price times the
+// quantity and carves a new lot of that weight for the buyer out of the seller's lot. The ask stays for
+// the rest of the lot, so several buyers can buy the same weight at the same price. A buy request without
+// a quantity is for the whole lot. The quantity is a weight and a unit, e.g. "2.5 lb",
+// or a number in the unit of the asset, and is kept converted to the unit of the asset.
+const quantityTransientKey = "quantity"
+
+// quantityFromTransient returns the quantity passed under quantity in the unit of the asset, nil when
+// the whole lot is wanted
+func quantityFromTransient(ctx contractapi.TransactionContextInterface, asset *Asset) (*Quantity, error) {
+	transMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("error getting transient: %v", err)
+	}
+	quantityBytes, ok := transMap[quantityTransientKey]
+	if !ok {
+		return nil, nil
+	}
+
+	weight, err := parseQuantity(string(quantityBytes), asset.Weight.Unit)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid: %v", quantityTransientKey, err)
+	}
+	quantity, err := weight.In(asset.Weight.Unit)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid: %v", quantityTransientKey, err)
+	}
+	if quantity.Value <= 0 {
+		return nil, fmt.Errorf("%s must be positive", quantityTransientKey)
+	}
+	err = verifyQuantityLeft(&quantity, asset)
+	if err != nil {
+		return nil, err
+	}
+	return &quantity, nil
+}
+
+// verifyQuantityLeft fails when quantity is more than the weight left of asset
+func verifyQuantityLeft(quantity *Quantity, asset *Asset) error {
+	if quantity == nil {
+		return nil
+	}
+	cmp, err := quantity.Compare(asset.Weight)
+	if err != nil {
+		return err
+	}
+	if cmp > 0 {
+		return fmt.Errorf("%s %v is more than the %v left of asset %s", quantityTransientKey, *quantity, asset.Weight, asset.ID)
+	}
+	return nil
+}
+
+// sameQuantity reports that two quantities of a buy request or a negotiation are the same, nil is the whole lot
+func sameQuantity(a, b *Quantity) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// carveLot moves quantity of a lot to a new lot owned by the buyer. The new lot is a child of the
+// seller's lot, which keeps the remaining weight.
+func (s *SmartContract) carveLot(ctx contractapi.TransactionContextInterface, parent *Asset, quantity Quantity, buyerID string, buyerMSP string) (*Asset, error) {
+	product, err := s.getProduct(ctx, parent.AssetType)
+	if err != nil {
+		return nil, err
+	}
+	quantity, err = quantity.In(parent.Weight.Unit)
+	if err != nil {
+		return nil, err
+	}
+	rest, err := parent.Weight.Minus(quantity)
+	if err != nil {
+		return nil, err
+	}
+	err = product.validateAsset(parent.Color, quantity)
+	if err != nil {
+		return nil, err
+	}
+	err = product.validateAsset(parent.Color, rest)
+	if err != nil {
+		return nil, fmt.Errorf("the rest of asset %s would not be a valid lot: %v", parent.ID, err)
+	}
+
+	childID := fmt.Sprintf("%s-%s", parent.ID, ctx.GetStub().GetTxID())
+	err = s.verifyNewAssetIDs(ctx, []string{childID})
+	if err != nil {
+		return nil, err
+	}
+	creatorDN, err := s.getSubmittingClientDN(ctx)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	child := &Asset{
+		AssetType:      parent.AssetType,
+		ID:             childID,
+		Color:          parent.Color,
+		Weight:         quantity,
+		Owner:          buyerID,
+		OwnerOrg:       buyerMSP,
+		Timestamp:      timestamp,
+		Creator:        creatorDN,
+		ExpirationDate: parent.ExpirationDate,
+		Parents:        []string{parent.ID},
+
+		ShelfLifeExtendedDays: parent.ShelfLifeExtendedDays,
+	}
+	err = putAsset(ctx, child)
+	if err != nil {
+		return nil, err
+	}
+
+	parent.Weight = rest
+	parent.Children = append(parent.Children, childID)
+	err = putAsset(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("carveLot: %v of %v to %v for %v", quantity, parent.ID, childID, buyerID)
+	return child, nil
+}
@@ -0,0 +1,92 @@
+package chaincode_test
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+	"phase2/chaincode/mocks"
+)
+
+func quantity(transient map[string][]byte, value string) map[string][]byte {
+	if transient == nil {
+		transient = map[string][]byte{}
+	}
+	transient["quantity"] = []byte(value)
+	return transient
+}
+
+func TestPartialPurchase(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	retailer2 := mocks.NewClientIdentity("Org2MSP", "RetailerT", map[string]string{"retailer": "true"})
+	fund(t, stub, retailer2, 100000)
+	allow(t, stub, retailer2, farmer, 100000)
+
+	setPrice := func(ctx contractapi.TransactionContextInterface) error { return sc.SetPrice(ctx, "asset1") }
+	agreeToBuy := func(ctx contractapi.TransactionContextInterface) error { return sc.AgreeToBuy(ctx, "asset1") }
+	requestToBuy := func(ctx contractapi.TransactionContextInterface) error { return sc.RequestToBuy(ctx, "asset1") }
+	transfer := func(ctx contractapi.TransactionContextInterface) error { return sc.TransferRequestedAsset(ctx) }
+
+	run(t, stub, []step{
+		{"farmer creates 15 kg lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"farmer asks 10 per kg for 5 kg", farmer, price("10", 5), setPrice, ""},
+		{"retailer bids 10 per kg for 5 kg", retailer, price("10", 5), agreeToBuy, ""},
+		{"quantity is more than the lot", retailer, quantity(nil, "20"), requestToBuy, "more than the 15 kg left"},
+		{"quantity is not a number", retailer, quantity(nil, "five"), requestToBuy, "is not a number"},
+		{"retailer requests 5 kg", retailer, quantity(nil, "5"), requestToBuy, ""},
+		{"second retailer bids 10 per kg for 5 kg", retailer2, price("10", 5), agreeToBuy, ""},
+		{"second retailer requests 6 kg", retailer2, quantity(nil, "6"), requestToBuy, ""},
+		{"farmer sells 5 kg to retailer", farmer, transferTo(t, "asset1", retailer), transfer, ""},
+	})
+
+	parent := readAsset(t, stub, "asset1")
+	require.Equal(t, "FarmerO", parent.Owner)
+	require.Equal(t, kg(10), parent.Weight)
+	require.False(t, parent.Consumed)
+	require.Len(t, parent.Children, 1)
+
+	child := readAsset(t, stub, parent.Children[0])
+	require.Equal(t, "RetailerO", child.Owner)
+	require.Equal(t, "Org2MSP", child.OwnerOrg)
+	require.Equal(t, kg(5), child.Weight)
+	require.Equal(t, []string{"asset1"}, child.Parents)
+	require.Equal(t, parent.ExpirationDate, child.ExpirationDate)
+
+	err := stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		receipts, err := sc.ListMyReceipts(ctx)
+		require.Len(t, receipts, 1)
+		require.Equal(t, child.ID, receipts[0].AssetID)
+		require.Equal(t, &chaincode.Quantity{Value: 5, Unit: "kg"}, receipts[0].Quantity)
+		require.Equal(t, &chaincode.Money{Amount: 10, Currency: "EUR"}, receipts[0].UnitPrice)
+		require.Equal(t, chaincode.Money{Amount: 50, Currency: "EUR"}, receipts[0].Price)
+
+		ask, err := sc.GetAssetSalesPrice(ctx, "asset1")
+		require.NoError(t, err, "the ask stays for the rest of the lot")
+		require.Equal(t, string(price("10", 5)["asset_price"]), ask)
+		return nil
+	})
+	require.NoError(t, err)
+
+	run(t, stub, []step{
+		{"the ask is not for the 6 kg of the second retailer", farmer, transferTo(t, "asset1", retailer2), transfer, "terms are for 5 kg but the buy request of RetailerT is for 6 kg"},
+		{"retailer requests 5 kg more", retailer, quantity(nil, "5"), requestToBuy, ""},
+		{"retailer bids again at the same ask", retailer, price("10", 5), agreeToBuy, ""},
+		{"farmer sells 5 kg more to retailer", farmer, transferTo(t, "asset1", retailer), transfer, ""},
+		{"retailer requests the rest", retailer, nil, requestToBuy, ""},
+		{"retailer bids for the rest", retailer, price("10", 5), agreeToBuy, ""},
+		{"farmer sells the rest to retailer", farmer, transferTo(t, "asset1", retailer), transfer, ""},
+	})
+
+	parent = readAsset(t, stub, "asset1")
+	require.Equal(t, "RetailerO", parent.Owner)
+	require.Equal(t, kg(5), parent.Weight)
+	require.Len(t, parent.Children, 2)
+	require.Equal(t, kg(5), readAsset(t, stub, parent.Children[1]).Weight)
+	require.Equal(t, 100000-10*15, balanceOf(t, stub, retailer))
+	require.Equal(t, 100000, balanceOf(t, stub, retailer2))
+}
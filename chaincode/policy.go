@@ -0,0 +1,252 @@
+package chaincode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// The policy maps roles to the transactions they may call. It is kept on the ledger, anyone can read
+// it with query:GetPolicy and an admin of the channel replaces it with asset:SetPolicy, so a new role is
+// onboarded without a new version of the chaincode. Until an admin sets one, defaultPolicy applies.
+const (
+	policyObjectType = "Policy"
+	// setPolicyTransaction is always reserved to clients of adminMSPID with the attribute admin=true,
+	// whatever the policy says, so that a policy cannot lock the admins out
+	setPolicyTransaction = assetContractName + ":SetPolicy"
+	// getPolicyTransaction is open to every client, whatever the policy says, so that no policy can hide
+	// the rules clients are checked against
+	getPolicyTransaction = queryContractName + ":GetPolicy"
+	adminAttribute       = "admin"
+	// adminMSPID is the org that administers the channel. An admin=true attribute issued by the CA of
+	// any other org does not make its holder an admin of the channel.
+	adminMSPID = "Org1MSP"
+	// mintTransaction issues settlement tokens. No role of the default policy may call it, the admin
+	// designates the issuing org by setting a policy with a role for it, see validate.
+	mintTransaction = marketContractName + ":Mint"
+)
+
+// Policy lists the roles of the channel
+type Policy struct {
+	Roles []PolicyRole `json:"roles"`
+}
+
+// PolicyRole is a kind of client and the transactions it may call. A client holds the role when it is
+// from MSPID and its certificate has the attribute Attribute=true and none of ExcludedAttributes; an
+// empty MSPID matches every org and an empty Attribute every client of the org. Functions are
+// contract:function, contract:* for every transaction of a contract, or * for every transaction of the
+// chaincode.
+type PolicyRole struct {
+	Name               string   `json:"name"`
+	MSPID              string   `json:"mspID,omitempty"`
+	Attribute          string   `json:"attribute,omitempty"`
+	ExcludedAttributes []string `json:"excludedAttributes,omitempty"`
+	Functions          []string `json:"functions"`
+}
+
+// defaultPolicy is the policy of a channel whose admin has not set one
+var defaultPolicy = Policy{Roles: []PolicyRole{
+	{Name: "admin", MSPID: adminMSPID, Attribute: adminAttribute, Functions: []string{
+		"asset:MigrateAssetKeys", "asset:UpgradeAssetRecords", "asset:IndexAssetExpirations", "asset:InitProductCatalog", "asset:PutProduct",
+		"market:RegisterSharedCollections",
+	}},
+	// devices are registered to the org of the admin that registers them, so every org runs its own
+	{Name: "orgAdmin", Attribute: adminAttribute, Functions: []string{"asset:RegisterDevice", "asset:RevokeDevice"}},
+	{Name: "farmer", MSPID: "Org1MSP", Attribute: "farmer", ExcludedAttributes: []string{"retailer"}, Functions: []string{"asset:InitLedger", "asset:CreateAsset"}},
+	{Name: "member", Functions: []string{
+		"asset:UpdateAsset", "asset:DeleteAsset", "asset:ExtendShelfLife", "asset:MarkExpired",
+		"asset:SplitAsset", "asset:MergeAssets", "asset:RecordSensorReading",
+		"market:DeleteBuyRequest", "market:SetPrice", "market:AgreeToBuy", "market:RequestToBuy",
+		"market:TransferRequestedAsset", "market:OpenNegotiation", "market:CounterOffer", "market:AcceptOffer",
+		"market:WithdrawNegotiation", "market:OpenAuction", "market:CommitBid", "market:RevealBid",
+		"market:CloseAuction", "market:TransferTokens", "market:Approve", "market:TransferTokensFrom",
+		"market:PruneExpiredTradingState",
+		"query:*",
+	}},
+}}
+
+// SetPolicy replaces the policy. policyJSON is a Policy in JSON, every function it names has to be a
+// transaction of the chaincode.
+func (s *AssetContract) SetPolicy(ctx contractapi.TransactionContextInterface, policyJSON string) error {
+	var policy Policy
+	decoder := json.NewDecoder(bytes.NewReader([]byte(policyJSON)))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&policy)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal policy: %v", err)
+	}
+	err = policy.validate()
+	if err != nil {
+		return err
+	}
+
+	policyKey, err := ctx.GetStub().CreateCompositeKey(policyObjectType, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	policyBytes, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy into JSON: %v", err)
+	}
+	err = ctx.GetStub().PutState(policyKey, policyBytes)
+	if err != nil {
+		return fmt.Errorf("failed to put policy to world state: %v", err)
+	}
+	log.Printf("SetPolicy: %d roles", len(policy.Roles))
+	return nil
+}
+
+// GetPolicy returns the policy transactions are checked against
+func (s *QueryContract) GetPolicy(ctx contractapi.TransactionContextInterface) (*Policy, error) {
+	return readPolicy(ctx)
+}
+
+// readPolicy returns the policy on the ledger, or the default one when none has been set
+func readPolicy(ctx contractapi.TransactionContextInterface) (*Policy, error) {
+	policyKey, err := ctx.GetStub().CreateCompositeKey(policyObjectType, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	policyBytes, err := ctx.GetStub().GetState(policyKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %v", err)
+	}
+	if policyBytes == nil {
+		policy := defaultPolicy
+		return &policy, nil
+	}
+	var policy Policy
+	err = json.Unmarshal(policyBytes, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	return &policy, nil
+}
+
+// authorize checks that client holds a role that may call function
+func (p *Policy) authorize(client *Client, function string) error {
+	if function == setPolicyTransaction {
+		if client.MSPID != adminMSPID || !client.HasAttribute(adminAttribute) {
+			return fmt.Errorf("%s of %s is not authorized to call %s, only an admin can change the policy", client.ID, client.MSPID, function)
+		}
+		return nil
+	}
+	if function == getPolicyTransaction {
+		return nil
+	}
+
+	var granting []string
+	var excluded string
+	for _, role := range p.Roles {
+		if !role.allows(function) {
+			continue
+		}
+		held, excludedBy := role.heldBy(client)
+		if held {
+			return nil
+		}
+		if excludedBy != "" {
+			excluded = excludedBy
+		}
+		granting = append(granting, role.Name)
+	}
+	if excluded != "" {
+		return fmt.Errorf("%s of %s is not authorized to call %s as a %s", client.ID, client.MSPID, function, excluded)
+	}
+	return fmt.Errorf("%s of %s is not authorized to call %s, it needs one of the roles %v", client.ID, client.MSPID, function, granting)
+}
+
+// heldBy returns true when client has the role. A client that is refused the role because it has one
+// of the excluded attributes gets that attribute back.
+func (r *PolicyRole) heldBy(client *Client) (bool, string) {
+	if r.MSPID != "" && r.MSPID != client.MSPID {
+		return false, ""
+	}
+	if r.Attribute != "" && !client.HasAttribute(r.Attribute) {
+		return false, ""
+	}
+	for _, attribute := range r.ExcludedAttributes {
+		if client.HasAttribute(attribute) {
+			return false, attribute
+		}
+	}
+	return true, ""
+}
+
+// allows returns true when the role may call function, given as contract:function
+func (r *PolicyRole) allows(function string) bool {
+	contract := function[:strings.Index(function, ":")+1]
+	for _, allowed := range r.Functions {
+		if allowed == "*" || allowed == function || allowed == contract+"*" {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks that the roles are named once and only name transactions of the chaincode, and that
+// a role that may mint is held by one org only
+func (p *Policy) validate() error {
+	if len(p.Roles) == 0 {
+		return fmt.Errorf("policy must have at least one role")
+	}
+	transactions := contractTransactions()
+	names := make(map[string]bool)
+	for _, role := range p.Roles {
+		if role.Name == "" {
+			return fmt.Errorf("every role must have a name")
+		}
+		if names[role.Name] {
+			return fmt.Errorf("role %s is defined twice", role.Name)
+		}
+		names[role.Name] = true
+		for _, attribute := range role.ExcludedAttributes {
+			if attribute == "" || attribute == role.Attribute {
+				return fmt.Errorf("excluded attribute %q of role %s is empty or the attribute of the role", attribute, role.Name)
+			}
+		}
+
+		for _, function := range role.Functions {
+			if function == "*" {
+				continue
+			}
+			parts := strings.SplitN(function, ":", 2)
+			if len(parts) != 2 || transactions[parts[0]] == nil {
+				return fmt.Errorf("function %q of role %s is not contract:function of one of the contracts asset, market or query", function, role.Name)
+			}
+			if parts[1] != "*" && !transactions[parts[0]][parts[1]] {
+				return fmt.Errorf("function %q of role %s is not a transaction of contract %s", function, role.Name, parts[0])
+			}
+		}
+		if role.allows(mintTransaction) && role.MSPID == "" {
+			return fmt.Errorf("role %s may call %s, so it must name the mspID of the issuing org", role.Name, mintTransaction)
+		}
+	}
+	return nil
+}
+
+// contractTransactions returns the transactions of every contract, by contract name
+func contractTransactions() map[string]map[string]bool {
+	base := reflect.TypeOf(new(contractapi.Contract))
+	transactions := make(map[string]map[string]bool)
+	for name, contract := range map[string]interface{}{
+		assetContractName:  new(AssetContract),
+		marketContractName: new(MarketContract),
+		queryContractName:  new(QueryContract),
+	} {
+		transactions[name] = make(map[string]bool)
+		contractType := reflect.TypeOf(contract)
+		for i := 0; i < contractType.NumMethod(); i++ {
+			method := contractType.Method(i).Name
+			if _, ok := base.MethodByName(method); !ok {
+				transactions[name][method] = true
+			}
+		}
+	}
+	return transactions
+}
@@ -0,0 +1,83 @@
+package chaincode_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+	"phase2/chaincode/mocks"
+)
+
+func TestPolicy(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	setPolicy := func(policy string) txFunc {
+		return mocks.Invoke(chaincode.NewAssetContract(), "SetPolicy", func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPolicy(ctx, policy)
+		})
+	}
+	getPolicy := func(client *mocks.ClientIdentity) *chaincode.Policy {
+		var policy *chaincode.Policy
+		err := stub.Evaluate(client, nil, mocks.Invoke(chaincode.NewQueryContract(), "GetPolicy", func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			policy, err = sc.GetPolicy(ctx)
+			return err
+		}))
+		require.NoError(t, err)
+		return policy
+	}
+	create := func(id string) txFunc {
+		return mocks.Invoke(chaincode.NewAssetContract(), "CreateAsset", func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, id, "red", 15, "apples")
+		})
+	}
+
+	// anyone reads the policy, the default one until an admin sets one
+	policy := getPolicy(supermarket)
+	require.Len(t, policy.Roles, 4)
+
+	// a packer of Org2 is onboarded by adding a role
+	packer := mocks.NewClientIdentity("Org2MSP", "PackerO", map[string]string{"packer": "true"})
+	supermarketAdmin := mocks.NewClientIdentity("Org3MSP", "SupermarketAdmin", map[string]string{"admin": "true"})
+	policy.Roles = append(policy.Roles, chaincode.PolicyRole{Name: "packer", MSPID: "Org2MSP", Attribute: "packer", Functions: []string{"asset:CreateAsset"}})
+	onboarded, err := json.Marshal(policy)
+	require.NoError(t, err)
+
+	run(t, stub, []step{
+		{"packer is not onboarded yet", packer, nil, create("asset1"), "needs one of the roles [farmer]"},
+		{"farmer cannot change the policy", farmer, nil, setPolicy(string(onboarded)), "only an admin can change the policy"},
+		{"admin of Org2 cannot change the policy", retailAdmin, nil, setPolicy(string(onboarded)), "only an admin can change the policy"},
+		{"admin of Org3 cannot change the policy", supermarketAdmin, nil, setPolicy(string(onboarded)), "only an admin can change the policy"},
+		{"admin of Org2 cannot run the catalog", retailAdmin, nil, mocks.Invoke(chaincode.NewAssetContract(), "InitProductCatalog", func(ctx contractapi.TransactionContextInterface) error {
+			return sc.InitProductCatalog(ctx)
+		}), "needs one of the roles [admin]"},
+		{"unknown field", admin, nil, setPolicy(`{"roles":[{"name":"a","functions":["query:*"],"deny":["x"]}]}`), "unknown field"},
+		{"no roles", admin, nil, setPolicy(`{"roles":[]}`), "at least one role"},
+		{"role without name", admin, nil, setPolicy(`{"roles":[{"functions":["query:*"]}]}`), "must have a name"},
+		{"role defined twice", admin, nil, setPolicy(`{"roles":[{"name":"a","functions":["query:*"]},{"name":"a","functions":["market:*"]}]}`), "defined twice"},
+		{"unknown contract", admin, nil, setPolicy(`{"roles":[{"name":"a","functions":["trade:SetPrice"]}]}`), "not contract:function"},
+		{"excluded attribute of the role", admin, nil, setPolicy(`{"roles":[{"name":"a","attribute":"a","excludedAttributes":["a"],"functions":["query:*"]}]}`), "excluded attribute"},
+		{"issuer of every org", admin, nil, setPolicy(`{"roles":[{"name":"issuer","attribute":"issuer","functions":["market:Mint"]}]}`), "must name the mspID of the issuing org"},
+		{"unknown function", admin, nil, setPolicy(`{"roles":[{"name":"a","functions":["asset:CreateAssets"]}]}`), "not a transaction of contract asset"},
+		{"admin onboards packers", admin, nil, setPolicy(string(onboarded)), ""},
+		{"packer creates lot", packer, nil, create("asset1"), ""},
+	})
+	require.Equal(t, policy, getPolicy(retailer))
+
+	// every transaction is checked, one no role lists is refused
+	run(t, stub, []step{
+		{"admin keeps only the packers", admin, nil, setPolicy(`{"roles":[{"name":"packer","mspID":"Org2MSP","attribute":"packer","functions":["asset:*"]}]}`), ""},
+		{"farmer cannot create anymore", farmer, nil, create("asset2"), "needs one of the roles [packer]"},
+		{"nobody may set a price", packer, price("100", 15), mocks.Invoke(chaincode.NewMarketContract(), "SetPrice", func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}), "needs one of the roles []"},
+	})
+	// the policy stays readable by anyone, even when no role lists query:GetPolicy
+	require.Len(t, getPolicy(farmer).Roles, 1)
+	run(t, stub, []step{
+		{"admins still set the policy", admin, nil, setPolicy(string(onboarded)), ""},
+	})
+}
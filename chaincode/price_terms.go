@@ -0,0 +1,196 @@
+package chaincode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Asks, bids and negotiation offers are price terms, passed in the transient map under asset_price as
+// canonical JSON: the fields of PriceTerms in their order, without spaces, e.g.
+//
+//	{"price":10,"currency":"EUR","quantity":{"value":5,"unit":"kg"},"salt":"9f86d081884c7d659a2feaa0c55ad015"}
+//
+// The price is in the minor units of the ISO 4217 currency (see units.go), e.g. cents for EUR, per unit of
+// the quantity, and the quantity is the weight sold in the weight unit of the asset, so the hash commits to
+// what is sold as well as to its price. A sale is only made on terms for the weight of the buy request, or
+// of the whole lot for a request without one; buyers who want the quantity of an ask can each buy a part
+// of the lot at it. Both sides of a trade pass the same terms, salt included, so that the private data
+// hashes compared by verifyAgreement match. The salt is random and agreed off chain, it keeps the price of
+// an ask, a bid or an offer from being guessed from its hash on the channel. It does not hide the price of
+// a sale: the payment moves the price times the quantity between token balances kept in the public world
+// state (see token.go), so every member of the channel sees the total and, with the sold weight, the price.
+// Each sale records the hash of its terms on the public ledger, so a party can later prove the terms to an
+// auditor with VerifyAgreedTerms.
+const (
+	agreedTermsObjectType = "AgreedTerms"
+	minSaltLength         = 16
+)
+
+// PriceTerms are the terms of an ask, a bid or an offer. Price is per unit of Quantity, so the buyer
+// pays Price times the quantity.
+type PriceTerms struct {
+	Price    int      `json:"price"`
+	Currency string   `json:"currency"`
+	Quantity Quantity `json:"quantity"`
+	Salt     string   `json:"salt"`
+}
+
+// AgreedTerms is the public record of the terms of a sale. It holds only the hash of the terms and the
+// weight sold, which is public anyway in the sold lot.
+type AgreedTerms struct {
+	AssetID   string    `json:"assetID"`
+	TxID      string    `json:"txID"`
+	TermsHash string    `json:"termsHash"`
+	Quantity  Quantity  `json:"quantity"`
+	SellerOrg string    `json:"sellerOrg"`
+	BuyerOrg  string    `json:"buyerOrg"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// unitPrice returns the price per weight unit
+func (t *PriceTerms) unitPrice() Money {
+	return Money{Amount: t.Price, Currency: t.Currency}
+}
+
+// total returns the amount the buyer pays for the quantity, rounded to the nearest minor unit
+func (t *PriceTerms) total() (Money, error) {
+	return t.unitPrice().Times(t.Quantity.Value)
+}
+
+// VerifyAgreedTerms returns the sale of an asset whose terms are the given ones, so that an auditor
+// given the terms by a party can check them against the ledger
+func (s *QueryContract) VerifyAgreedTerms(ctx contractapi.TransactionContextInterface, assetID string, terms string) (*AgreedTerms, error) {
+	_, err := parsePriceTerms([]byte(terms))
+	if err != nil {
+		return nil, err
+	}
+	hash := priceHash([]byte(terms))
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(agreedTermsObjectType, []string{assetID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agreed terms: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var agreed AgreedTerms
+		err = json.Unmarshal(queryResponse.Value, &agreed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+		}
+		if agreed.TermsHash == hash {
+			return &agreed, nil
+		}
+	}
+	return nil, fmt.Errorf("no sale of %s was agreed on these terms", assetID)
+}
+
+// parsePriceTerms reads and validates canonical price terms
+func parsePriceTerms(termsBytes []byte) (*PriceTerms, error) {
+	var terms PriceTerms
+	decoder := json.NewDecoder(bytes.NewReader(termsBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&terms)
+	if err != nil {
+		return nil, fmt.Errorf("asset_price must be price terms {\"price\",\"currency\",\"quantity\",\"salt\"}: %v", err)
+	}
+
+	if terms.Price <= 0 {
+		return nil, fmt.Errorf("price must be positive")
+	}
+	err = validateCurrency(terms.Currency)
+	if err != nil {
+		return nil, err
+	}
+	err = validateWeightUnit(terms.Quantity.Unit)
+	if err != nil {
+		return nil, err
+	}
+	if terms.Quantity.Value <= 0 {
+		return nil, fmt.Errorf("quantity must be positive")
+	}
+	if newQuantity(terms.Quantity.Value, terms.Quantity.Unit) != terms.Quantity {
+		return nil, fmt.Errorf("quantity must be given to a thousandth of its unit")
+	}
+	if len(terms.Salt) < minSaltLength {
+		return nil, fmt.Errorf("salt must have at least %d characters, otherwise the price can be guessed from its hash", minSaltLength)
+	}
+
+	// both sides must send the same bytes for their hashes to match, so only the canonical form is accepted
+	canonical, err := json.Marshal(terms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal price terms into JSON: %v", err)
+	}
+	if !bytes.Equal(canonical, termsBytes) {
+		return nil, fmt.Errorf("price terms are not canonical, send %s", canonical)
+	}
+	return &terms, nil
+}
+
+// getTransientPriceTerms returns the price terms passed under asset_price, as they were sent and parsed
+func getTransientPriceTerms(ctx contractapi.TransactionContextInterface) ([]byte, *PriceTerms, error) {
+	transMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting transient: %v", err)
+	}
+	termsBytes, ok := transMap["asset_price"]
+	if !ok {
+		return nil, nil, fmt.Errorf("asset_price key not found in the transient map")
+	}
+	terms, err := parsePriceTerms(termsBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return termsBytes, terms, nil
+}
+
+// verifyTermsQuantity checks that the terms are for a quantity in the weight unit of an asset and no
+// more than is left of it. Terms are hashed as they are sent, so they are not converted.
+func verifyTermsQuantity(terms *PriceTerms, asset *Asset) error {
+	if terms.Quantity.Unit != asset.Weight.Unit {
+		return fmt.Errorf("terms are in %s but asset %s is weighed in %s", terms.Quantity.Unit, asset.ID, asset.Weight.Unit)
+	}
+	cmp, err := terms.Quantity.Compare(asset.Weight)
+	if err != nil {
+		return err
+	}
+	if cmp > 0 {
+		return fmt.Errorf("terms are for %v but only %v of asset %s is left", terms.Quantity, asset.Weight, asset.ID)
+	}
+	return nil
+}
+
+// priceHash returns the hash of price terms, the same one GetPrivateDataHash returns for them
+func priceHash(price []byte) string {
+	hash := sha256.Sum256(price)
+	return hex.EncodeToString(hash[:])
+}
+
+// putAgreedTerms records the hash of the terms of a sale on the public ledger
+func putAgreedTerms(ctx contractapi.TransactionContextInterface, agreed *AgreedTerms) error {
+	agreedKey, err := ctx.GetStub().CreateCompositeKey(agreedTermsObjectType, []string{agreed.AssetID, agreed.TxID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	agreedJSON, err := json.Marshal(agreed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agreed terms into JSON: %v", err)
+	}
+	err = ctx.GetStub().PutState(agreedKey, agreedJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put agreed terms to world state: %v", err)
+	}
+	log.Printf("AgreedTerms: %v in tx %v", agreed.AssetID, agreed.TxID)
+	return nil
+}
@@ -0,0 +1,70 @@
+package chaincode_test
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+)
+
+func terms(value string) map[string][]byte {
+	return map[string][]byte{"asset_price": []byte(value)}
+}
+
+func TestPriceTerms(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	setPrice := func(ctx contractapi.TransactionContextInterface) error { return sc.SetPrice(ctx, "asset1") }
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"bare price", farmer, terms("100"), setPrice, "must be price terms"},
+		{"unknown field", farmer, terms(`{"price":100,"currency":"EUR","quantity":{"value":15,"unit":"kg"},"salt":"` + testSalt + `","note":"x"}`), setPrice, "unknown field"},
+		{"no salt", farmer, terms(`{"price":100,"currency":"EUR","quantity":{"value":15,"unit":"kg"}}`), setPrice, "salt must have at least 16 characters"},
+		{"short salt", farmer, terms(`{"price":100,"currency":"EUR","quantity":{"value":15,"unit":"kg"},"salt":"abc"}`), setPrice, "salt must have at least 16 characters"},
+		{"bad currency", farmer, terms(`{"price":100,"currency":"euro","quantity":{"value":15,"unit":"kg"},"salt":"` + testSalt + `"}`), setPrice, "not a supported ISO 4217 code"},
+		{"no price", farmer, terms(`{"price":0,"currency":"EUR","quantity":{"value":15,"unit":"kg"},"salt":"` + testSalt + `"}`), setPrice, "price must be positive"},
+		{"no quantity", farmer, terms(`{"price":100,"currency":"EUR","salt":"` + testSalt + `"}`), setPrice, "weight unit \"\" is not supported"},
+		{"unit without a quantity", farmer, terms(`{"price":100,"currency":"EUR","unit":"kg","salt":"` + testSalt + `"}`), setPrice, "unknown field"},
+		{"no weight", farmer, terms(`{"price":100,"currency":"EUR","quantity":{"value":0,"unit":"kg"},"salt":"` + testSalt + `"}`), setPrice, "quantity must be positive"},
+		{"weight finer than a gram", farmer, terms(`{"price":100,"currency":"EUR","quantity":{"value":1.0005,"unit":"kg"},"salt":"` + testSalt + `"}`), setPrice, "to a thousandth of its unit"},
+		{"more than the lot", farmer, price("100", 20), setPrice, "terms are for 20 kg but only 15 kg of asset asset1 is left"},
+		{"fields out of order", farmer, terms(`{"currency":"EUR","price":100,"quantity":{"value":15,"unit":"kg"},"salt":"` + testSalt + `"}`), setPrice, "not canonical"},
+		{"spaces", farmer, terms(`{"price": 100, "currency": "EUR", "quantity": {"value": 15, "unit": "kg"}, "salt": "` + testSalt + `"}`), setPrice, "not canonical"},
+		{"farmer asks 100", farmer, price("100", 15), setPrice, ""},
+		{"retailer bids 100 with another salt", retailer, terms(`{"price":100,"currency":"EUR","quantity":{"value":15,"unit":"kg"},"salt":"ffffffffffffffffffffffffffffffff"}`), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AgreeToBuy(ctx, "asset1")
+		}, ""},
+		{"retailer requests", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, ""},
+		{"salts differ", farmer, transferTo(t, "asset1", retailer), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferRequestedAsset(ctx)
+		}, "hash for appraised value"},
+		{"retailer bids on the same terms", retailer, price("100", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AgreeToBuy(ctx, "asset1")
+		}, ""},
+		{"farmer transfers to retailer", farmer, transferTo(t, "asset1", retailer), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferRequestedAsset(ctx)
+		}, ""},
+	})
+
+	// an auditor given the terms by either side can check them against the public record of the sale
+	auditor := supermarket
+	err := stub.Evaluate(auditor, nil, func(ctx contractapi.TransactionContextInterface) error {
+		agreed, err := sc.VerifyAgreedTerms(ctx, "asset1", string(price("100", 15)["asset_price"]))
+		require.NoError(t, err)
+		require.Equal(t, "Org1MSP", agreed.SellerOrg)
+		require.Equal(t, "Org2MSP", agreed.BuyerOrg)
+		require.Equal(t, chaincode.Quantity{Value: 15, Unit: "kg"}, agreed.Quantity)
+
+		_, err = sc.VerifyAgreedTerms(ctx, "asset1", string(price("90", 15)["asset_price"]))
+		require.Error(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+}
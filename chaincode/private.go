@@ -7,9 +7,7 @@ import (
   "bytes"
   //"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
   "github.com/hyperledger/fabric-contract-api-go/contractapi"
-  //"time"
-  
-  //"github.com/golang/protobuf/ptypes"
+  "time"
 
   "github.com/hyperledger/fabric-chaincode-go/shim"
   
@@ -19,92 +17,184 @@ import (
 const (
 	typeAssetForSale     = "S"
 	typeAssetBid         = "B"
+	typeNegotiatedAsk    = "NS"
 )
-const assetCollection = "assetCollection"
-const assetCollection23 = "assetCollection23"
 const requestToBuyObjectType = "BuyRequest"
+const (
+	buyRequestPending  = "pending"
+	buyRequestAccepted = "accepted"
+	buyRequestRejected = "rejected"
+)
 
 type AssetPrivateDetails struct {
 	ID             string `json:"assetID"`
 	// ObjectType	   string `json:"objectType"`
-	Price 		   int    `json:"price"`
+	Price 		   Money  `json:"price"`
 }
 
 
+// RequestToBuyObject is a buyer's request for an asset, kept under BuyRequest~assetID~buyerID in the
+// collection shared by the buyer's org and the seller's org. Once the seller transfers the asset the
+// chosen request is accepted and the other pending ones are rejected.
 type RequestToBuyObject struct {
-	ID      string `json:"assetID"`
-	BuyerID string `json:"buyerID"`
+	ID        string    `json:"assetID"`
+	BuyerID   string    `json:"buyerID"`
+	BuyerOrg  string    `json:"buyerOrg"`
+	Seller    string    `json:"seller"`
+	SellerOrg string    `json:"sellerOrg"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+	// ValidUntil is the end of the validity window passed under valid_for, nil when the request does not expire
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
+	// Quantity is the weight wanted in the unit of the asset, nil for the whole lot
+	Quantity *Quantity `json:"quantity,omitempty"`
+}
+
+// expiredAt reports that the validity window of the request has ended at now
+func (r *RequestToBuyObject) expiredAt(now time.Time) bool {
+	return r.ValidUntil != nil && !now.Before(*r.ValidUntil)
 }
 
+const receiptObjectType = "Receipt"
+const (
+	receiptTypeSale     = "sale"
+	receiptTypePurchase = "purchase"
+)
 
+// Receipt records a completed transfer in the implicit collection of the seller and of the buyer
+type Receipt struct {
+	AssetID         string    `json:"assetID"`
+	Type            string    `json:"type"`
+	Counterparty    string    `json:"counterparty"`
+	CounterpartyOrg string    `json:"counterpartyOrg"`
+	Price           Money     `json:"price"`
+	Quantity        *Quantity `json:"quantity,omitempty"`
+	UnitPrice       *Money    `json:"unitPrice,omitempty"`
+	TermsHash       string    `json:"termsHash,omitempty"`
+	TxID            string    `json:"txID"`
+	Timestamp       time.Time `json:"timestamp"`
+}
 
-//Puts Price to Org1 implicit collection
-func (s *SmartContract) SetPrice(ctx contractapi.TransactionContextInterface, assetID string) error {
-	asset, err := s.ReadAsset(ctx, assetID)
+
+
+//Puts the price terms of the ask to Org1 implicit collection, see price_terms.go
+func (s *MarketContract) SetPrice(ctx contractapi.TransactionContextInterface, assetID string) error {
+	asset, err := s.readAsset(ctx, assetID)
 	if err != nil {
 		return err
 	}
 
-	clientID, err := s.GetSubmittingClientIdentity(ctx)
+	// Verify that this client actually owns the asset.
+	err = s.verifyAssetOwner(ctx, asset)
 	if err != nil {
-		return fmt.Errorf("failed to get verified OrgID: %v", err)
+		return err
 	}
-
-	// Verify that this client  actually owns the asset.
-	if clientID != asset.Owner {
-		return fmt.Errorf("a client from %s cannot sell an asset owned by %s", clientID, asset.Owner)
+	err = verifyNotConsumed(asset)
+	if err != nil {
+		return err
 	}
-
-	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+	err = verifyNotExpired(ctx, asset)
 	if err != nil {
-		return  fmt.Errorf("failed getting client's orgID: %v", err)
+		return err
 	}
 
-	if clientOrgID != asset.OwnerOrg {
-		return fmt.Errorf("submitting client not from the same Org.Clients org is %s and buyers is %s", clientOrgID, asset.OwnerOrg)
+	assetPriceKey, err := askKey(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	err = SaveToCollection(ctx, assetPriceKey, asset)
+	if err != nil {
+		return err
 	}
 
-	return SaveToCollection(ctx, assetID, typeAssetForSale)
+	// an optional validity window is kept next to the ask
+	validUntil, err := validUntilFromTransient(ctx)
+	if err != nil {
+		return err
+	}
+	collection, err := buildCollectionName(ctx)
+	if err != nil {
+		return err
+	}
+	askValidityKey, err := validityKey(ctx, typeAssetForSale, assetID)
+	if err != nil {
+		return err
+	}
+	return putValidity(ctx, collection, askValidityKey, validUntil)
 }
 
 
 
-// AgreeToBuy adds buyer's bid price to buyer's implicit private data collection
-func (s *SmartContract) AgreeToBuy(ctx contractapi.TransactionContextInterface, assetID string) error {
-	return SaveToCollection(ctx, assetID, typeAssetBid)
+// AgreeToBuy adds the price terms of buyer's bid to buyer's implicit private data collection
+func (s *MarketContract) AgreeToBuy(ctx contractapi.TransactionContextInterface, assetID string) error {
+	asset, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	err = verifyNotExpired(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	buyerID, err := s.getSubmittingClientIdentity(ctx)
+	if err != nil {
+		return err
+	}
+	assetBidKey, err := bidKey(ctx, assetID, buyerID)
+	if err != nil {
+		return err
+	}
+	err = SaveToCollection(ctx, assetBidKey, asset)
+	if err != nil {
+		return err
+	}
+
+	// an optional validity window is kept in the collection shared with the owner, so that the owner's transfer can read it
+	validUntil, err := validUntilFromTransient(ctx)
+	if err != nil {
+		return err
+	}
+	clientMSPID, err := getClientMSPID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting the client's MSPID: %v", err)
+	}
+	sharedCollection, err := s.getSharedCollection(ctx, asset.OwnerOrg, clientMSPID)
+	if err != nil {
+		if validUntil == nil {
+			return nil
+		}
+		return err
+	}
+	bidValidityKey, err := validityKey(ctx, typeAssetBid, assetID, buyerID)
+	if err != nil {
+		return err
+	}
+	return putValidity(ctx, sharedCollection, bidValidityKey, validUntil)
 }
 
-// SaveToCollection adds a bid or ask price,as a composite key to caller's implicit private data collection
-func SaveToCollection(ctx contractapi.TransactionContextInterface, assetID string, priceType string) error {
+// SaveToCollection adds the price terms of a bid or ask for an asset, under the key built by askKey or bidKey, to caller's implicit private data collection
+func SaveToCollection(ctx contractapi.TransactionContextInterface, assetPriceKey string, asset *Asset) error {
 	// In this scenario, client is only authorized to read/write private data from its own peer.
 	err := verifyClientOrgMatchesPeerOrg(ctx)
 	if err != nil {
 		return fmt.Errorf("Could not be verified. : Error %v", err)
 	}
 
-	transMap, err := ctx.GetStub().GetTransient()
+	// Asset price must be retrieved from the transient field as they are private
+	price, terms, err := getTransientPriceTerms(ctx)
 	if err != nil {
-		return fmt.Errorf("error getting transient: %v", err)
+		return err
 	}
-
-	// Asset price must be retrieved from the transient field as they are private
-	price, ok := transMap["asset_price"]
-	if !ok {
-		return fmt.Errorf("asset_price key not found in the transient map")
+	err = verifyTermsQuantity(terms, asset)
+	if err != nil {
+		return err
 	}
 
 	collection ,err:= buildCollectionName(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to infer private collection name for the org: %v", err)
 	}
-	// Persist the agreed to price in a collection sub-namespace based on priceType key prefix,
-	// to avoid collisions between private asset properties, sell price, and buy price
-	assetPriceKey, err := ctx.GetStub().CreateCompositeKey(priceType, []string{assetID})
-	if err != nil {
-		return fmt.Errorf("failed to create composite key: %v", err)
-	}
-
-	// The Price hash will be verified later, therefore always pass and persist price bytes as is,
+	// The Price hash will be verified later, therefore always persist the canonical terms bytes as they were passed,
 	// so that there is no risk of nondeterministic marshaling.
 	err = ctx.GetStub().PutPrivateData(collection, assetPriceKey, price)
 	if err != nil {
@@ -114,60 +204,93 @@ func SaveToCollection(ctx contractapi.TransactionContextInterface, assetID strin
 	return nil
 }
 
-//Puts Buy request on shared Private Collection
-func (s *SmartContract) RequestToBuy(ctx contractapi.TransactionContextInterface,assetID string ) error {
+//Puts Buy request on the Private Collection shared between the buyer and the asset owner
+func (s *MarketContract) RequestToBuy(ctx contractapi.TransactionContextInterface,assetID string ) error {
+
+	asset, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	err = verifyNotConsumed(asset)
+	if err != nil {
+		return err
+	}
+	err = verifyNotExpired(ctx, asset)
+	if err != nil {
+		return err
+	}
 
 	// Get ID of submitting client identity
-	buyerID, err := s.GetSubmittingClientIdentity(ctx)
+	buyerID, err := s.getSubmittingClientIdentity(ctx)
 	if err != nil {
 		return err
 	}
 
-	clientMSPID,err:=ctx.GetClientIdentity().GetMSPID()
+	clientMSPID,err:=getClientMSPID(ctx)
 	if err != nil {
 		return fmt.Errorf("failed getting the client's MSPID: %v", err)
 	}
 
-	// Create agreeement that indicates which identity has agreed to purchase
-	// In a more realistic transfer scenario, a transfer agreement would be secured to ensure that it cannot
-	// be overwritten by another channel member
-	buyRequestKey, err := ctx.GetStub().CreateCompositeKey(requestToBuyObjectType, []string{assetID})
+	temp, err := s.getSharedCollection(ctx, asset.OwnerOrg, clientMSPID)
 	if err != nil {
-		return fmt.Errorf("failed to create composite key: %v", err)
-	}
-	temp:=assetCollection
-	if clientMSPID =="Org3MSP"{
-		temp=assetCollection23
+		return err
 	}
 
-	//check if a request already exists,so users cant override requests
-	exists, err := s.RequestToBuyExists(ctx, assetID,temp)
+	//check if this buyer already has a pending request,so users cant override requests.
+	//Requests are kept per buyer, other buyers can request the same asset at the same time
+	exists, err := s.requestToBuyExists(ctx, assetID, buyerID, temp)
 	if err != nil {
 		return err
 	}
 	if exists {
-		return fmt.Errorf("A request for the asset %s already exists", assetID)
+		return fmt.Errorf("A request of %s for the asset %s already exists", buyerID, assetID)
 	}
-	//I could change it to make it as function input
-	err = ctx.GetStub().PutPrivateData(temp,buyRequestKey, []byte(buyerID))
+
+	timestamp, err := getTxTime(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to put asset bid: %v", err)
+		return err
 	}
-	log.Printf("Request To Buy : collection %v, ID %v, from %v", temp, assetID,clientMSPID)
+	validUntil, err := validUntilFromTransient(ctx)
+	if err != nil {
+		return err
+	}
+	quantity, err := quantityFromTransient(ctx, asset)
+	if err != nil {
+		return err
+	}
+	request := &RequestToBuyObject{
+		ID:        assetID,
+		BuyerID:   buyerID,
+		BuyerOrg:  clientMSPID,
+		Seller:    asset.Owner,
+		SellerOrg: asset.OwnerOrg,
+		Status:     buyRequestPending,
+		Timestamp:  timestamp,
+		ValidUntil: validUntil,
+		Quantity:   quantity,
+	}
+	err = putBuyRequest(ctx, temp, request)
+	if err != nil {
+		return err
+	}
+	log.Printf("Request To Buy : collection %v, ID %v, from %v %v", temp, assetID, buyerID, clientMSPID)
 
 
 	return nil
 }
 
-//Transfers asset , deletes price keys from sellers & buyers collections, deletes buyRequest from shared collection and creates Receipts for both orgs
-func (s *SmartContract) TransferRequestedAsset(ctx contractapi.TransactionContextInterface) error {
+//Transfers asset to the buyer chosen by the seller, deletes price keys from sellers & buyers collections, accepts the buyer's request,
+//rejects the other pending requests for the asset and creates Receipts for both orgs.
+//A request for part of the lot carves a new lot for the buyer instead, the seller keeps the rest and its ask to the other buyers.
+//The hash of the agreed price terms is recorded on the public ledger, see price_terms.go.
+func (s *MarketContract) TransferRequestedAsset(ctx contractapi.TransactionContextInterface) error {
 
 	transientMap, err := ctx.GetStub().GetTransient()
 	if err != nil {
 		return fmt.Errorf("error getting transient %v", err)
 	}
 
-	// get Transient data , includes assetID and BuyerMSP
+	// get Transient data , includes assetID, BuyerMSP and the BuyerID of the chosen request
 	transientTransferJSON, ok := transientMap["asset_owner"]
 	if !ok {
 		return fmt.Errorf("asset owner not found in the transient map")
@@ -176,6 +299,7 @@ func (s *SmartContract) TransferRequestedAsset(ctx contractapi.TransactionContex
 	type assetTransferTransientInput struct {
 		ID       string `json:"assetID"`
 		BuyerMSP string `json:"buyerMSP"`
+		BuyerID  string `json:"buyerID"`
 	}
 
 	var assetTransferInput assetTransferTransientInput
@@ -190,14 +314,26 @@ func (s *SmartContract) TransferRequestedAsset(ctx contractapi.TransactionContex
 	if len(assetTransferInput.BuyerMSP) == 0 {
 		return fmt.Errorf("buyerMSP field must be a non-empty string")
 	}
+	if len(assetTransferInput.BuyerID) == 0 {
+		return fmt.Errorf("buyerID field must be a non-empty string")
+	}
 	log.Printf("TransferAsset: verify asset exists ID %v", assetTransferInput.ID)
 	// Read asset from world State
-	asset, err := s.ReadAsset(ctx, assetTransferInput.ID)
+	asset, err := s.readAsset(ctx, assetTransferInput.ID)
 	if err != nil {
 		return fmt.Errorf("error reading asset: %v", err)
 	}
-	if asset == nil {
-		return fmt.Errorf("%v does not exist", assetTransferInput.ID)
+	err = verifyNotConsumed(asset)
+	if err != nil {
+		return err
+	}
+	err = verifyNotExpired(ctx, asset)
+	if err != nil {
+		return err
+	}
+	err = s.verifyNoOpenAuction(ctx, asset.ID)
+	if err != nil {
+		return err
 	}
 	// Verify that the client is submitting request to peer in their organization
 	err = verifyClientOrgMatchesPeerOrg(ctx)
@@ -206,41 +342,32 @@ func (s *SmartContract) TransferRequestedAsset(ctx contractapi.TransactionContex
 	}
 
 	// Verify transfer details and transfer owner
-	err = s.verifyAgreement(ctx, asset.ID, asset.Owner,asset.OwnerOrg, assetTransferInput.BuyerMSP)
+	err = s.verifyAgreement(ctx, asset, assetTransferInput.BuyerMSP, assetTransferInput.BuyerID)
 	if err != nil {
 		return fmt.Errorf("failed transfer verification: %v", err)
 	}
-	//we have to chose the correct collection
-	clientMSPID,err:=ctx.GetClientIdentity().GetMSPID()
+	//the buy request is in the collection shared between the seller and the buyer
+	temp, err := s.getSharedCollection(ctx, asset.OwnerOrg, assetTransferInput.BuyerMSP)
 	if err != nil {
-		return fmt.Errorf("failed getting the client's MSPID: %v", err)
-	}
-	//this might need to be changed so Org3 can sell to its clients or create another function
-	temp:=assetCollection
-	if clientMSPID =="Org2MSP"{
-		temp=assetCollection23
+		return err
 	}
-	buyRequest, err := s.ReadRequestToBuy(ctx, asset.ID,temp)
+	buyRequest, err := s.readRequestToBuy(ctx, asset.ID, assetTransferInput.BuyerID, temp)
 	if err != nil {
 		return fmt.Errorf("failed ReadRequestToBuy to find buyerID: %v", err)
 	}
-	if buyRequest.BuyerID == "" {
-		return fmt.Errorf("BuyerID not found in buyRequest for %v", asset.ID)
+	if buyRequest == nil {
+		return fmt.Errorf("no buy request of %v for %v in %v", assetTransferInput.BuyerID, asset.ID, temp)
 	}
-
-	//change ownership
-	asset.Owner = buyRequest.BuyerID
-	asset.OwnerOrg = assetTransferInput.BuyerMSP
-	assetJSONasBytes, err := json.Marshal(asset)
-	if err != nil {
-		return fmt.Errorf("failed marshalling asset %v: %v", asset.ID, err)
+	if buyRequest.Status != buyRequestPending {
+		return fmt.Errorf("buy request of %v for %v is %v", buyRequest.BuyerID, asset.ID, buyRequest.Status)
 	}
-
-	//rewrite the asset
-	err = ctx.GetStub().PutState( asset.ID, assetJSONasBytes) 
+	now, err := getTxTime(ctx)
 	if err != nil {
 		return err
 	}
+	if buyRequest.expiredAt(now) {
+		return fmt.Errorf("buy request of %v for %v expired at %v", buyRequest.BuyerID, asset.ID, buyRequest.ValidUntil.Format(time.RFC3339))
+	}
 
 	// Get collection name for this organization
 	collectionSeller, err := buildCollectionName(ctx)
@@ -248,21 +375,150 @@ func (s *SmartContract) TransferRequestedAsset(ctx contractapi.TransactionContex
 		return fmt.Errorf("failed to infer private collection name for the org: %v", err)
 	}
 
+	// The agreed terms are the seller's ask, verifyAgreement checked that the bid hash matches it
+	assetPriceKey, negotiated, err := sellerAskKey(ctx, collectionSeller, asset.ID, buyRequest.BuyerID)
+	if err != nil {
+		return err
+	}
+	agreedPrice, err := getAssetPrice(ctx, asset.ID, assetPriceKey)
+	if err != nil {
+		return fmt.Errorf("failed to read agreed price: %v", err)
+	}
+	terms, err := parsePriceTerms([]byte(agreedPrice))
+	if err != nil {
+		return err
+	}
+	err = verifyTermsQuantity(terms, asset)
+	if err != nil {
+		return err
+	}
+
+	// The terms must be for the quantity of the request, a request without one is for the whole lot
+	soldWeight := asset.Weight
+	if buyRequest.Quantity != nil {
+		soldWeight, err = buyRequest.Quantity.In(asset.Weight.Unit)
+		if err != nil {
+			return err
+		}
+	}
+	cmp, err := soldWeight.Compare(asset.Weight)
+	if err != nil {
+		return err
+	}
+	if cmp > 0 {
+		return fmt.Errorf("buy request of %v is for %v but only %v of %v is left", buyRequest.BuyerID, soldWeight, asset.Weight, asset.ID)
+	}
+	partial := cmp < 0
+	cmp, err = terms.Quantity.Compare(soldWeight)
+	if err != nil {
+		return err
+	}
+	if cmp != 0 {
+		return fmt.Errorf("terms are for %v but the buy request of %v is for %v", terms.Quantity, buyRequest.BuyerID, soldWeight)
+	}
+	unitPrice := terms.unitPrice()
+	price, err := terms.total()
+	if err != nil {
+		return err
+	}
+	sellerID, sellerMSP := asset.Owner, asset.OwnerOrg
+
+	// Pay the seller in the same transaction as the lot changes owner, out of what the buyer approved for the seller
+	err = settlePayment(ctx, buyRequest.BuyerID, assetTransferInput.BuyerMSP, sellerID, sellerMSP, price)
+	if err != nil {
+		return err
+	}
 
-	// Delete the price records for seller
-	assetPriceKey, err := ctx.GetStub().CreateCompositeKey(typeAssetForSale, []string{asset.ID})
+	soldID := asset.ID
+	if partial {
+		child, err := s.carveLot(ctx, asset, soldWeight, buyRequest.BuyerID, assetTransferInput.BuyerMSP)
+		if err != nil {
+			return err
+		}
+		soldID = child.ID
+	} else {
+		//change ownership
+		asset.Owner = buyRequest.BuyerID
+		asset.OwnerOrg = assetTransferInput.BuyerMSP
+
+		//rewrite the asset
+		err = putAsset(ctx, asset)
+		if err != nil {
+			return err
+		}
+	}
+
+	// After a partial sale the ask stays for the other buyers of the rest of the lot, only an ask negotiated
+	// with this buyer was for this sale. Once the whole lot is sold no ask for it is left, so a later owner
+	// in the same org starts without one.
+	if partial {
+		if negotiated {
+			askValidityKey, err := validityKey(ctx, typeNegotiatedAsk, asset.ID, buyRequest.BuyerID)
+			if err != nil {
+				return err
+			}
+			err = deletePrivateData(ctx, collectionSeller, assetPriceKey, askValidityKey)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		err = deleteAsks(ctx, collectionSeller, asset.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete the bid of the buyer
+	assetBidKey, err := bidKey(ctx, asset.ID, buyRequest.BuyerID)
 	if err != nil {
-		return fmt.Errorf("failed to create composite key for seller: %v", err)
+		return err
+	}
+	err = ctx.GetStub().DelPrivateData("_implicit_org_"+assetTransferInput.BuyerMSP, assetBidKey)
+	if err != nil {
+		return fmt.Errorf("failed to delete asset bid from implicit private data collection for buyer: %v", err)
+	}
+	bidValidityKey, err := validityKey(ctx, typeAssetBid, asset.ID, buyRequest.BuyerID)
+	if err != nil {
+		return err
+	}
+	err = putValidity(ctx, temp, bidValidityKey, nil)
+	if err != nil {
+		return err
 	}
-	 //price:=assetPriceKey.price
 
-	//anyone can delete the data??? Probaby solved with access control
-	err = ctx.GetStub().DelPrivateData(collectionSeller, assetPriceKey)
+	// Accept the chosen request and reject the other pending ones, so every buyer can see the outcome.
+	// After a partial sale the rest of the lot is still for sale to them.
+	buyRequest.Status = buyRequestAccepted
+	err = putBuyRequest(ctx, temp, buyRequest)
 	if err != nil {
-		return fmt.Errorf("failed to delete asset price from implicit private data collection for seller: %v", err)
+		return err
+	}
+	if !partial {
+		err = s.rejectBuyRequests(ctx, asset.ID, sellerMSP, buyRequest)
+		if err != nil {
+			return err
+		}
 	}
 
+	// Record the hash of the agreed terms, so that either side can prove them to an auditor later
+	hash := priceHash([]byte(agreedPrice))
+	err = putAgreedTerms(ctx, &AgreedTerms{AssetID: soldID, TxID: ctx.GetStub().GetTxID(), TermsHash: hash, Quantity: soldWeight, SellerOrg: sellerMSP, BuyerOrg: assetTransferInput.BuyerMSP, Timestamp: now})
+	if err != nil {
+		return err
+	}
 
+	// Write a receipt of the sale for the seller and of the purchase for the buyer
+	sale := Receipt{AssetID: soldID, Type: receiptTypeSale, Counterparty: buyRequest.BuyerID, CounterpartyOrg: assetTransferInput.BuyerMSP, Price: price, Quantity: &soldWeight, UnitPrice: &unitPrice, TermsHash: hash}
+	err = writeReceipt(ctx, sellerMSP, sale)
+	if err != nil {
+		return err
+	}
+	purchase := Receipt{AssetID: soldID, Type: receiptTypePurchase, Counterparty: sellerID, CounterpartyOrg: sellerMSP, Price: price, Quantity: &soldWeight, UnitPrice: &unitPrice, TermsHash: hash}
+	err = writeReceipt(ctx, assetTransferInput.BuyerMSP, purchase)
+	if err != nil {
+		return err
+	}
 
 	return nil
 
@@ -272,37 +528,171 @@ func (s *SmartContract) TransferRequestedAsset(ctx contractapi.TransactionContex
 
 /*============================HELPER FUNCTIONS=============================================*/
 
+// askKey returns the key of the seller's ask in the seller's implicit collection
+func askKey(ctx contractapi.TransactionContextInterface, assetID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(typeAssetForSale, []string{assetID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return key, nil
+}
 
-// verifyAgreement is an internal helper function used by TransferAsset to verify
-// that the transfer is being initiated by the owner and that the buyer has agreed
-// to the same appraisal value as the owner
-func (s *SmartContract) verifyAgreement(ctx contractapi.TransactionContextInterface, assetID string, owner string,ownerOrg string, buyerMSP string) error {
+// negotiatedAskKey returns the key of the ask a negotiation with buyerID agreed on, in the seller's
+// implicit collection. It is kept apart from the ask of SetPrice, so that accepting an offer neither
+// replaces the ask to the other buyers nor the terms agreed with another buyer.
+func negotiatedAskKey(ctx contractapi.TransactionContextInterface, assetID string, buyerID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(typeNegotiatedAsk, []string{assetID, buyerID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return key, nil
+}
 
-	// Check 1: verify that the transfer is being initiatied by the owner
+// sellerAskKey returns the key of the ask that applies to buyerID, the negotiated one if the seller's
+// collection has it and otherwise the ask of SetPrice, and whether it is the negotiated one
+func sellerAskKey(ctx contractapi.TransactionContextInterface, collectionSeller string, assetID string, buyerID string) (string, bool, error) {
+	negotiatedKey, err := negotiatedAskKey(ctx, assetID, buyerID)
+	if err != nil {
+		return "", false, err
+	}
+	hash, err := ctx.GetStub().GetPrivateDataHash(collectionSeller, negotiatedKey)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get negotiated price hash: %v", err)
+	}
+	if hash != nil {
+		return negotiatedKey, true, nil
+	}
+	key, err := askKey(ctx, assetID)
+	return key, false, err
+}
 
-	// Get ID of submitting client identity
-	clientID, err := s.GetSubmittingClientIdentity(ctx)
+// deleteAsks removes every ask for an asset from the seller's implicit collection: the ask of SetPrice
+// and the asks negotiated with each buyer, with their validity records
+func deleteAsks(ctx contractapi.TransactionContextInterface, collectionSeller string, assetID string) error {
+	assetPriceKey, err := askKey(ctx, assetID)
+	if err != nil {
+		return err
+	}
+	askValidityKey, err := validityKey(ctx, typeAssetForSale, assetID)
 	if err != nil {
 		return err
 	}
+	keys := []string{assetPriceKey, askValidityKey}
+
+	for _, prefix := range [][]string{{typeNegotiatedAsk, assetID}, {validityObjectType, typeNegotiatedAsk, assetID}} {
+		resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collectionSeller, prefix[0], prefix[1:])
+		if err != nil {
+			return fmt.Errorf("failed to read negotiated asks from %s: %v", collectionSeller, err)
+		}
+		for resultsIterator.HasNext() {
+			queryResponse, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return err
+			}
+			keys = append(keys, queryResponse.Key)
+		}
+		resultsIterator.Close()
+	}
+	return deletePrivateData(ctx, collectionSeller, keys...)
+}
 
-	if clientID != owner {
-		return fmt.Errorf("error: submitting client identity does not own asset")
+// bidKey returns the key of a buyer's bid in the buyer's implicit collection. Bids are kept per
+// buyer, so that buyers of the same org can bid on the same asset.
+func bidKey(ctx contractapi.TransactionContextInterface, assetID string, buyerID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(typeAssetBid, []string{assetID, buyerID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
 	}
+	return key, nil
+}
 
-	//added this to avoid same name but different orgs
-	clientOrgID, err := ctx.GetClientIdentity().GetMSPID()
+// putBuyRequest writes a buy request to a shared collection under BuyRequest~assetID~buyerID
+func putBuyRequest(ctx contractapi.TransactionContextInterface, sharedCollection string, request *RequestToBuyObject) error {
+	requestKey, err := ctx.GetStub().CreateCompositeKey(requestToBuyObjectType, []string{request.ID, request.BuyerID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	requestJSON, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal buy request into JSON: %v", err)
+	}
+	err = ctx.GetStub().PutPrivateData(sharedCollection, requestKey, requestJSON)
 	if err != nil {
-		return  fmt.Errorf("failed getting client's orgID: %v", err)
+		return fmt.Errorf("failed to put buy request to %s: %v", sharedCollection, err)
 	}
+	return nil
+}
 
-	if clientOrgID != ownerOrg {
-		return fmt.Errorf("submitting client not from the same Org.Clients org is %s and buyers is %s",clientOrgID,ownerOrg)
+// rejectBuyRequests rejects the pending requests for an asset in every collection of the seller org,
+// except the accepted one. A nil accepted request rejects all of them, e.g. after an auction.
+func (s *SmartContract) rejectBuyRequests(ctx contractapi.TransactionContextInterface, assetID string, sellerMSP string, accepted *RequestToBuyObject) error {
+	collections, err := s.sharedCollectionsOf(ctx, sellerMSP)
+	if err != nil {
+		return err
 	}
+	for _, collection := range collections {
+		requests, err := getBuyRequests(ctx, collection, assetID)
+		if err != nil {
+			return err
+		}
+		for _, request := range requests {
+			if request.Status != buyRequestPending || (accepted != nil && request.BuyerID == accepted.BuyerID && request.BuyerOrg == accepted.BuyerOrg) {
+				continue
+			}
+			request.Status = buyRequestRejected
+			err = putBuyRequest(ctx, collection, request)
+			if err != nil {
+				return err
+			}
+			log.Printf("Buy request of %v for %v rejected", request.BuyerID, assetID)
+		}
+	}
+	return nil
+}
 
 
+// writeReceipt completes a receipt with the transaction details and puts it in the
+// implicit collection of orgMSP
+func writeReceipt(ctx contractapi.TransactionContextInterface, orgMSP string, receipt Receipt) error {
+	timestamp, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	receipt.TxID = ctx.GetStub().GetTxID()
+	receipt.Timestamp = timestamp
 
-	// Check 2: verify that the buyer has agreed to the appraised value
+	receiptKey, err := ctx.GetStub().CreateCompositeKey(receiptObjectType, []string{receipt.AssetID, receipt.TxID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	receiptJSON, err := json.Marshal(receipt)
+	if err != nil {
+		return fmt.Errorf("failed to marshal receipt into JSON: %v", err)
+	}
+	err = ctx.GetStub().PutPrivateData("_implicit_org_"+orgMSP, receiptKey, receiptJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put receipt for %s: %v", orgMSP, err)
+	}
+	log.Printf("Receipt: %v of %v for %v, tx %v", receipt.Type, receipt.AssetID, orgMSP, receipt.TxID)
+	return nil
+}
+
+// verifyAgreement is an internal helper function used by TransferAsset to verify
+// that the transfer is being initiated by the owner and that the buyer has agreed
+// to the same appraisal value as the owner
+func (s *SmartContract) verifyAgreement(ctx contractapi.TransactionContextInterface, asset *Asset, buyerMSP string, buyerID string) error {
+	assetID := asset.ID
+
+	// Check 1: verify that the transfer is being initiatied by the owner, from the owner's org
+	err := s.verifyAssetOwner(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+
+
+	// Check 2: verify that the chosen buyer has agreed to the appraised value
 
 	// Get collection names
 	collectionSeller, err := buildCollectionName(ctx) // get owner collection from caller identity
@@ -312,10 +702,10 @@ func (s *SmartContract) verifyAgreement(ctx contractapi.TransactionContextInterf
 
 	collectionBuyer :="_implicit_org_"+ buyerMSP  // get buyers collection
 
-	// Get sellers asking price
-	assetForSaleKey, err := ctx.GetStub().CreateCompositeKey(typeAssetForSale, []string{assetID})
+	// Get sellers asking price, the one agreed on in a negotiation with the buyer if there is one
+	assetForSaleKey, negotiated, err := sellerAskKey(ctx, collectionSeller, assetID, buyerID)
 	if err != nil {
-		return fmt.Errorf("failed to create composite key: %v", err)
+		return err
 	}
 	sellerPriceHash, err := ctx.GetStub().GetPrivateDataHash(collectionSeller, assetForSaleKey)
 	if err != nil {
@@ -327,9 +717,9 @@ func (s *SmartContract) verifyAgreement(ctx contractapi.TransactionContextInterf
 
 	// Get buyers bid price
 	
-	assetBidKey, err := ctx.GetStub().CreateCompositeKey(typeAssetBid, []string{assetID})
+	assetBidKey, err := bidKey(ctx, assetID, buyerID)
 	if err != nil {
-		return fmt.Errorf("failed to create composite key: %v", err)
+		return err
 	}
 	buyerPriceHash, err := ctx.GetStub().GetPrivateDataHash(collectionBuyer, assetBidKey)
 	if err != nil {
@@ -344,6 +734,32 @@ func (s *SmartContract) verifyAgreement(ctx contractapi.TransactionContextInterf
 		return fmt.Errorf("hash for appraised value for owner %x does not value for seller %x", sellerPriceHash, buyerPriceHash)
 	}
 
+	// Check 3: verify that neither price has expired, the window of the bid is in the collection shared with the buyer's org.
+	// A negotiated ask has the window of the accepted offer.
+	askValidityKey, err := validityKey(ctx, typeAssetForSale, assetID)
+	if negotiated {
+		askValidityKey, err = validityKey(ctx, typeNegotiatedAsk, assetID, buyerID)
+	}
+	if err != nil {
+		return err
+	}
+	err = verifyStillValid(ctx, collectionSeller, askValidityKey, "seller price for "+assetID)
+	if err != nil {
+		return err
+	}
+	sharedCollection, err := s.getSharedCollection(ctx, asset.OwnerOrg, buyerMSP)
+	if err != nil {
+		return err
+	}
+	bidValidityKey, err := validityKey(ctx, typeAssetBid, assetID, buyerID)
+	if err != nil {
+		return err
+	}
+	err = verifyStillValid(ctx, sharedCollection, bidValidityKey, "buyer price for "+assetID)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -353,7 +769,7 @@ func (s *SmartContract) verifyAgreement(ctx contractapi.TransactionContextInterf
 
 // verifyClientOrgMatchesPeerOrg is an internal function used verify client org id and matches peer org id.
 func verifyClientOrgMatchesPeerOrg(ctx contractapi.TransactionContextInterface) error {
-	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	clientMSPID, err := getClientMSPID(ctx)
 	if err != nil {
 		return fmt.Errorf("failed getting the client's MSPID: %v", err)
 	}
@@ -371,7 +787,7 @@ func verifyClientOrgMatchesPeerOrg(ctx contractapi.TransactionContextInterface)
 
 func buildCollectionName(ctx contractapi.TransactionContextInterface) (string, error) {
 	// Get the MSP ID of submitting client identity
-	clientMSPID, err := ctx.GetClientIdentity().GetMSPID()
+	clientMSPID, err := getClientMSPID(ctx)
 	if err != nil {
 		return "", fmt.Errorf("failed to get verified MSPID: %v", err)
 	}
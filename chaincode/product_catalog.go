@@ -0,0 +1,206 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const productObjectType = "Product"
+
+// Product is the catalog entry of an asset type. Assets of the type are validated against it
+// and take their expiration date and cold-chain thresholds from it.
+type Product struct {
+	AssetType                 string             `json:"assetType"`
+	Colors                    []string           `json:"colors"`
+	MinWeight                 int                `json:"minWeight"`
+	MaxWeight                 int                `json:"maxWeight"`
+	WeightUnit                string             `json:"weightUnit"`
+	ShelfLifeDays             int                `json:"shelfLifeDays"`
+	MaxShelfLifeExtensionDays int                `json:"maxShelfLifeExtensionDays"`
+	ColdChain                 ColdChainThreshold `json:"coldChain"`
+}
+
+// defaultProducts are the products traded on the channel when the catalog is first initialized
+var defaultProducts = []Product{
+	{
+		AssetType: "berries", Colors: []string{"blue", "black", "red"}, MinWeight: 1, MaxWeight: 20, WeightUnit: "kg",
+		ShelfLifeDays: 7, MaxShelfLifeExtensionDays: 2,
+		ColdChain: ColdChainThreshold{MinTemperature: 0, MaxTemperature: 4, MinHumidity: 85, MaxHumidity: 95},
+	},
+	{
+		AssetType: "apples", Colors: []string{"green", "yellow", "red"}, MinWeight: 1, MaxWeight: 50, WeightUnit: "kg",
+		ShelfLifeDays: 7, MaxShelfLifeExtensionDays: 21,
+		ColdChain: ColdChainThreshold{MinTemperature: -1, MaxTemperature: 4, MinHumidity: 85, MaxHumidity: 95},
+	},
+	{
+		AssetType: "grapes", Colors: []string{"white", "red", "black"}, MinWeight: 1, MaxWeight: 30, WeightUnit: "kg",
+		ShelfLifeDays: 7, MaxShelfLifeExtensionDays: 5,
+		ColdChain: ColdChainThreshold{MinTemperature: -1, MaxTemperature: 2, MinHumidity: 85, MaxHumidity: 95},
+	},
+}
+
+// InitProductCatalog adds the default products to the catalog, leaving the ones already defined untouched
+func (s *AssetContract) InitProductCatalog(ctx contractapi.TransactionContextInterface) error {
+	for i := range defaultProducts {
+		existing, err := readProduct(ctx, defaultProducts[i].AssetType)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			continue
+		}
+		err = putProduct(ctx, &defaultProducts[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PutProduct adds a product to the catalog or replaces it. productJSON is a Product in JSON.
+// Assets that already exist keep their expiration date.
+func (s *AssetContract) PutProduct(ctx contractapi.TransactionContextInterface, productJSON string) error {
+	var product Product
+	err := json.Unmarshal([]byte(productJSON), &product)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal product: %v", err)
+	}
+	err = product.validate()
+	if err != nil {
+		return err
+	}
+	return putProduct(ctx, &product)
+}
+
+// GetProduct returns the catalog entry of an asset type
+func (s *QueryContract) GetProduct(ctx contractapi.TransactionContextInterface, assetType string) (*Product, error) {
+	return s.getProduct(ctx, assetType)
+}
+
+// getProduct returns the catalog entry of an asset type
+func (s *SmartContract) getProduct(ctx contractapi.TransactionContextInterface, assetType string) (*Product, error) {
+	product, err := readProduct(ctx, assetType)
+	if err != nil {
+		return nil, err
+	}
+	if product == nil {
+		return nil, fmt.Errorf("asset type %s is not in the product catalog", assetType)
+	}
+	return product, nil
+}
+
+// GetAllProducts returns the whole product catalog
+func (s *QueryContract) GetAllProducts(ctx contractapi.TransactionContextInterface) ([]*Product, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(productObjectType, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	products := []*Product{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var product Product
+		err = json.Unmarshal(queryResponse.Value, &product)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, &product)
+	}
+
+	return products, nil
+}
+
+// validate checks that a catalog entry is consistent
+func (p *Product) validate() error {
+	if p.AssetType == "" {
+		return fmt.Errorf("assetType must be a non-empty string")
+	}
+	if len(p.Colors) == 0 {
+		return fmt.Errorf("product %s must allow at least one color", p.AssetType)
+	}
+	if p.MinWeight <= 0 || p.MaxWeight < p.MinWeight {
+		return fmt.Errorf("weight range [%d, %d] of product %s is not valid", p.MinWeight, p.MaxWeight, p.AssetType)
+	}
+	err := validateWeightUnit(p.WeightUnit)
+	if err != nil {
+		return fmt.Errorf("weightUnit of product %s is not valid: %v", p.AssetType, err)
+	}
+	if p.ShelfLifeDays <= 0 {
+		return fmt.Errorf("shelf life of product %s must be a positive number of days", p.AssetType)
+	}
+	if p.MaxShelfLifeExtensionDays < 0 {
+		return fmt.Errorf("shelf life extension of product %s cannot be negative", p.AssetType)
+	}
+	if p.ColdChain.MinTemperature > p.ColdChain.MaxTemperature {
+		return fmt.Errorf("temperature range of product %s is not valid", p.AssetType)
+	}
+	if p.ColdChain.MinHumidity < 0 || p.ColdChain.MaxHumidity > 100 || p.ColdChain.MinHumidity > p.ColdChain.MaxHumidity {
+		return fmt.Errorf("humidity range of product %s is not valid", p.AssetType)
+	}
+	return nil
+}
+
+// validateAsset checks the color and weight of an asset against the catalog entry, in the unit of the entry
+func (p *Product) validateAsset(color string, weight Quantity) error {
+	allowed := false
+	for _, c := range p.Colors {
+		if c == color {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("color %s is not allowed for %s, allowed colors are %v", color, p.AssetType, p.Colors)
+	}
+	converted, err := weight.In(p.WeightUnit)
+	if err != nil {
+		return err
+	}
+	if converted.Value < float64(p.MinWeight) || converted.Value > float64(p.MaxWeight) {
+		return fmt.Errorf("weight of %s must be between %d and %d %s, got %v", p.AssetType, p.MinWeight, p.MaxWeight, p.WeightUnit, weight)
+	}
+	return nil
+}
+
+// readProduct returns the catalog entry of an asset type or nil
+func readProduct(ctx contractapi.TransactionContextInterface, assetType string) (*Product, error) {
+	productKey, err := ctx.GetStub().CreateCompositeKey(productObjectType, []string{assetType})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	productJSON, err := ctx.GetStub().GetState(productKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if productJSON == nil {
+		return nil, nil
+	}
+
+	var product Product
+	err = json.Unmarshal(productJSON, &product)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	return &product, nil
+}
+
+func putProduct(ctx contractapi.TransactionContextInterface, product *Product) error {
+	productKey, err := ctx.GetStub().CreateCompositeKey(productObjectType, []string{product.AssetType})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	productJSON, err := json.Marshal(product)
+	if err != nil {
+		return fmt.Errorf("failed to marshal product into JSON: %v", err)
+	}
+	log.Printf("PutProduct: %v", product.AssetType)
+	return ctx.GetStub().PutState(productKey, productJSON)
+}
@@ -0,0 +1,106 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+	"phase2/chaincode/mocks"
+)
+
+func TestProductCatalog(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	putProduct := func(productJSON string) txFunc {
+		return func(ctx contractapi.TransactionContextInterface) error {
+			return sc.PutProduct(ctx, productJSON)
+		}
+	}
+	create := func(id string, color string, weight float64, assetType string) txFunc {
+		return func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, id, color, weight, assetType)
+		}
+	}
+	cherries := `{"assetType":"cherries","colors":["red","black"],"minWeight":2,"maxWeight":10,"weightUnit":"kg",
+		"shelfLifeDays":3,"maxShelfLifeExtensionDays":1,
+		"coldChain":{"minTemperature":0,"maxTemperature":2,"minHumidity":90,"maxHumidity":95}}`
+
+	run(t, stub, []step{
+		{"not an admin", farmer, nil, mocks.Invoke(chaincode.NewAssetContract(), "PutProduct", putProduct(cherries)), "not authorized to call asset:PutProduct"},
+		{"no colors", admin, nil, putProduct(`{"assetType":"cherries","minWeight":1,"maxWeight":2,"weightUnit":"kg","shelfLifeDays":3}`), "at least one color"},
+		{"bad weight range", admin, nil, putProduct(`{"assetType":"cherries","colors":["red"],"minWeight":5,"maxWeight":2,"weightUnit":"kg","shelfLifeDays":3}`), "weight range"},
+		{"no shelf life", admin, nil, putProduct(`{"assetType":"cherries","colors":["red"],"minWeight":1,"maxWeight":2,"weightUnit":"kg"}`), "shelf life"},
+		{"unknown asset type", farmer, nil, create("asset1", "red", 5, "cherries"), "not in the product catalog"},
+		{"admin adds cherries", admin, nil, putProduct(cherries), ""},
+		{"color not allowed", farmer, nil, create("asset1", "green", 5, "cherries"), "color green is not allowed"},
+		{"too light", farmer, nil, create("asset1", "red", 1, "cherries"), "between 2 and 10 kg"},
+		{"too heavy", farmer, nil, create("asset1", "red", 11, "cherries"), "between 2 and 10 kg"},
+		{"farmer creates cherries", farmer, nil, create("asset1", "red", 5, "cherries"), ""},
+		{"weights need not be whole", farmer, nil, create("asset2", "black", 2.5, "cherries"), ""},
+		{"update to a color not allowed", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.UpdateAsset(ctx, "asset1", "yellow", 5)
+		}, "color yellow is not allowed"},
+		{"update over the max weight", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.UpdateAsset(ctx, "asset1", "black", 20)
+		}, "between 2 and 10 kg"},
+		{"split below the min weight", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SplitAsset(ctx, "asset1", []string{"a", "b"}, []float64{4, 1}, "kg")
+		}, "between 2 and 10 kg"},
+		{"extend over the product limit", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.ExtendShelfLife(ctx, "asset1", 2)
+		}, "extended by 1 days in total"},
+	})
+
+	asset := readAsset(t, stub, "asset1")
+	require.Equal(t, asset.Timestamp.AddDate(0, 0, 3), asset.ExpirationDate, "expiration comes from the product shelf life")
+
+	var products []*chaincode.Product
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		products, err = sc.GetAllProducts(ctx)
+		return err
+	})
+	require.NoError(t, err)
+	require.Len(t, products, 4)
+
+	var product *chaincode.Product
+	err = stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		product, err = sc.GetProduct(ctx, "cherries")
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, "kg", product.WeightUnit)
+	require.Equal(t, 2.0, product.ColdChain.MaxTemperature)
+}
+
+func TestInitProductCatalogKeepsExistingEntries(t *testing.T) {
+	sc := contracts{}
+	stub := mocks.NewChaincodeStub()
+	run(t, stub, []step{
+		{"farmer cannot initialize the catalog", farmer, nil, mocks.Invoke(chaincode.NewAssetContract(), "InitProductCatalog", func(ctx contractapi.TransactionContextInterface) error {
+			return sc.InitProductCatalog(ctx)
+		}), "not authorized to call asset:InitProductCatalog"},
+		{"ledger cannot be initialized without a catalog", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.InitLedger(ctx)
+		}, "not in the product catalog"},
+		{"admin defines apples", admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.PutProduct(ctx, `{"assetType":"apples","colors":["green","yellow","red"],"minWeight":1,"maxWeight":50,
+				"weightUnit":"kg","shelfLifeDays":30,"maxShelfLifeExtensionDays":0}`)
+		}, ""},
+		{"admin initializes the catalog", admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.InitProductCatalog(ctx)
+		}, ""},
+		{"farmer initializes the ledger", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.InitLedger(ctx)
+		}, ""},
+	})
+
+	apples := readAsset(t, stub, "asset3")
+	require.Equal(t, apples.Timestamp.Add(30*24*time.Hour), apples.ExpirationDate)
+	berries := readAsset(t, stub, "asset1")
+	require.Equal(t, berries.Timestamp.Add(7*24*time.Hour), berries.ExpirationDate)
+}
@@ -0,0 +1,246 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const sensorReadingObjectType = "SensorReading"
+
+// sensorTimeLayout is a fixed width layout, so readings of an asset sort by time in their keys
+const sensorTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// maxSensorClockSkew is how far ahead of the transaction timestamp a reading may be
+const maxSensorClockSkew = 5 * time.Minute
+
+// SensorReading is a cold-chain measurement of an asset, stored under its own key
+// so the asset document does not grow with every reading
+type SensorReading struct {
+	AssetID     string    `json:"assetID"`
+	DeviceID    string    `json:"deviceID"`
+	Temperature float64   `json:"temperature"`
+	Humidity    float64   `json:"humidity"`
+	Timestamp   time.Time `json:"timestamp"`
+	RecordedBy  string    `json:"recordedBy"`
+	TxID        string    `json:"txID"`
+	Payload     string    `json:"payload"`
+	Signature   string    `json:"signature"`
+}
+
+// sensorPayload is a reading as it is signed by the device
+type sensorPayload struct {
+	AssetID     string  `json:"assetID"`
+	DeviceID    string  `json:"deviceID"`
+	Temperature float64 `json:"temperature"`
+	Humidity    float64 `json:"humidity"`
+	Timestamp   string  `json:"timestamp"`
+}
+
+// ColdChainThreshold is the allowed range of temperature (Celsius) and relative humidity (%) for an asset type
+type ColdChainThreshold struct {
+	MinTemperature float64 `json:"minTemperature"`
+	MaxTemperature float64 `json:"maxTemperature"`
+	MinHumidity    float64 `json:"minHumidity"`
+	MaxHumidity    float64 `json:"maxHumidity"`
+}
+
+// ColdChainSummary reports the readings of an asset that are outside the thresholds of its asset type
+type ColdChainSummary struct {
+	AssetID        string             `json:"assetID"`
+	AssetType      string             `json:"assetType"`
+	Thresholds     ColdChainThreshold `json:"thresholds"`
+	Readings       int                `json:"readings"`
+	MinTemperature float64            `json:"minTemperature"`
+	MaxTemperature float64            `json:"maxTemperature"`
+	Violations     []*SensorReading   `json:"violations"`
+	Compliant      bool               `json:"compliant"`
+}
+
+// RecordSensorReading appends a reading to an asset. payload is the JSON reading
+// {"assetID","deviceID","temperature","humidity","timestamp"} exactly as signed by the device,
+// with the timestamp in RFC 3339, and signature is the base64 encoded ECDSA signature of it.
+// Readings can only be recorded by the org that holds the asset, from a device registered by that org.
+func (s *AssetContract) RecordSensorReading(ctx contractapi.TransactionContextInterface, payload string, signature string) error {
+	var input sensorPayload
+	err := json.Unmarshal([]byte(payload), &input)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal sensor payload: %v", err)
+	}
+
+	asset, err := s.readAsset(ctx, input.AssetID)
+	if err != nil {
+		return err
+	}
+	err = verifyNotConsumed(asset)
+	if err != nil {
+		return err
+	}
+
+	clientOrgID, err := getClientMSPID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+	if clientOrgID != asset.OwnerOrg {
+		return fmt.Errorf("submitting client not authorized to record readings of asset %s, it is held by %s", asset.ID, asset.OwnerOrg)
+	}
+
+	if input.DeviceID == "" {
+		return fmt.Errorf("deviceID must be a non-empty string")
+	}
+	err = s.verifyDeviceSignature(ctx, input.DeviceID, clientOrgID, []byte(payload), signature)
+	if err != nil {
+		return err
+	}
+	if input.Humidity < 0 || input.Humidity > 100 {
+		return fmt.Errorf("humidity %v is not a percentage", input.Humidity)
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, input.Timestamp)
+	if err != nil {
+		return fmt.Errorf("timestamp must be an RFC 3339 timestamp: %v", err)
+	}
+	txTime, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if timestamp.After(txTime.Add(maxSensorClockSkew)) {
+		return fmt.Errorf("reading taken at %v is later than the transaction time %v", timestamp, txTime)
+	}
+
+	reading := SensorReading{
+		AssetID:     asset.ID,
+		DeviceID:    input.DeviceID,
+		Temperature: input.Temperature,
+		Humidity:    input.Humidity,
+		Timestamp:   timestamp.UTC(),
+		RecordedBy:  clientOrgID,
+		TxID:        ctx.GetStub().GetTxID(),
+		Payload:     payload,
+		Signature:   signature,
+	}
+	return putSensorReading(ctx, &reading)
+}
+
+// GetSensorReadings returns the readings of an asset taken in [from, to), oldest first.
+// from and to are RFC 3339 timestamps, an empty string leaves that end of the range open.
+func (s *QueryContract) GetSensorReadings(ctx contractapi.TransactionContextInterface, assetID string, from string, to string) ([]*SensorReading, error) {
+	var fromTime, toTime time.Time
+	var err error
+	if from != "" {
+		fromTime, err = time.Parse(time.RFC3339Nano, from)
+		if err != nil {
+			return nil, fmt.Errorf("from must be an RFC 3339 timestamp: %v", err)
+		}
+	}
+	if to != "" {
+		toTime, err = time.Parse(time.RFC3339Nano, to)
+		if err != nil {
+			return nil, fmt.Errorf("to must be an RFC 3339 timestamp: %v", err)
+		}
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(sensorReadingObjectType, []string{assetID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	readings := []*SensorReading{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var reading SensorReading
+		err = json.Unmarshal(queryResponse.Value, &reading)
+		if err != nil {
+			return nil, err
+		}
+		if from != "" && reading.Timestamp.Before(fromTime) {
+			continue
+		}
+		if to != "" && !reading.Timestamp.Before(toTime) {
+			continue
+		}
+		readings = append(readings, &reading)
+	}
+
+	return readings, nil
+}
+
+// GetColdChainSummary checks every reading of an asset against the cold-chain thresholds of its product
+func (s *QueryContract) GetColdChainSummary(ctx contractapi.TransactionContextInterface, assetID string) (*ColdChainSummary, error) {
+	asset, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	product, err := s.getProduct(ctx, asset.AssetType)
+	if err != nil {
+		return nil, err
+	}
+	thresholds := product.ColdChain
+
+	readings, err := s.GetSensorReadings(ctx, assetID, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &ColdChainSummary{
+		AssetID:    assetID,
+		AssetType:  asset.AssetType,
+		Thresholds: thresholds,
+		Readings:   len(readings),
+		Violations: []*SensorReading{},
+	}
+	for i, reading := range readings {
+		if i == 0 || reading.Temperature < summary.MinTemperature {
+			summary.MinTemperature = reading.Temperature
+		}
+		if i == 0 || reading.Temperature > summary.MaxTemperature {
+			summary.MaxTemperature = reading.Temperature
+		}
+		if !thresholds.allows(reading) {
+			summary.Violations = append(summary.Violations, reading)
+		}
+	}
+	summary.Compliant = len(summary.Violations) == 0
+
+	return summary, nil
+}
+
+// allows reports whether a reading is within the thresholds
+func (t ColdChainThreshold) allows(reading *SensorReading) bool {
+	return reading.Temperature >= t.MinTemperature && reading.Temperature <= t.MaxTemperature &&
+		reading.Humidity >= t.MinHumidity && reading.Humidity <= t.MaxHumidity
+}
+
+// putSensorReading stores a reading under SensorReading~assetID~timestamp~deviceID
+func putSensorReading(ctx contractapi.TransactionContextInterface, reading *SensorReading) error {
+	readingKey, err := ctx.GetStub().CreateCompositeKey(sensorReadingObjectType,
+		[]string{reading.AssetID, reading.Timestamp.Format(sensorTimeLayout), reading.DeviceID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	existing, err := ctx.GetStub().GetState(readingKey)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("device %s already recorded a reading of %s at %v", reading.DeviceID, reading.AssetID, reading.Timestamp)
+	}
+
+	readingJSON, err := json.Marshal(reading)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sensor reading into JSON: %v", err)
+	}
+	err = ctx.GetStub().PutState(readingKey, readingJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put sensor reading to world state: %v", err)
+	}
+	log.Printf("RecordSensorReading: asset %v, device %v, %v C, %v %%", reading.AssetID, reading.DeviceID, reading.Temperature, reading.Humidity)
+	return nil
+}
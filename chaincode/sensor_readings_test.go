@@ -0,0 +1,196 @@
+package chaincode_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+	"phase2/chaincode/mocks"
+)
+
+// sensor is an IoT device that signs its readings
+type sensor struct {
+	id  string
+	key *ecdsa.PrivateKey
+}
+
+func newSensor(t *testing.T, id string) *sensor {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &sensor{id: id, key: key}
+}
+
+func (d *sensor) publicKeyPEM(t *testing.T) string {
+	der, err := x509.MarshalPKIXPublicKey(&d.key.PublicKey)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// reading returns the signed payload of a reading and its signature
+func (d *sensor) reading(t *testing.T, assetID string, temperature, humidity float64, readAt string) (string, string) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"assetID": assetID, "deviceID": d.id, "temperature": temperature, "humidity": humidity, "timestamp": readAt,
+	})
+	require.NoError(t, err)
+	hash := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, d.key, hash[:])
+	require.NoError(t, err)
+	return string(payload), base64.StdEncoding.EncodeToString(signature)
+}
+
+func registerDevice(sc *contracts, d *sensor, publicKeyPEM string) txFunc {
+	return func(ctx contractapi.TransactionContextInterface) error {
+		return sc.RegisterDevice(ctx, d.id, publicKeyPEM)
+	}
+}
+
+func TestSensorReadings(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	device := newSensor(t, "sensor-7")
+	start := stub.Now.Add(-time.Hour)
+	at := func(d time.Duration) string { return start.Add(d).Format(time.RFC3339) }
+	record := func(temperature, humidity float64, readAt string) txFunc {
+		payload, signature := device.reading(t, "asset1", temperature, humidity, readAt)
+		return func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RecordSensorReading(ctx, payload, signature)
+		}
+	}
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"farm registers sensor", farmAdmin, nil, registerDevice(&sc, device, device.publicKeyPEM(t)), ""},
+		{"unknown asset", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			payload, signature := device.reading(t, "asset9", 2, 90, at(0))
+			return sc.RecordSensorReading(ctx, payload, signature)
+		}, "does not exist"},
+		{"org that does not hold the asset", retailer, nil, record(2, 90, at(0)), "held by Org1MSP"},
+		{"bad timestamp", farmer, nil, record(2, 90, "yesterday"), "RFC 3339"},
+		{"reading from the future", farmer, nil, record(2, 90, at(2*time.Hour)), "later than the transaction time"},
+		{"humidity out of range", farmer, nil, record(2, 120, at(0)), "not a percentage"},
+		{"reading 1", farmer, nil, record(2, 90, at(0)), ""},
+		{"same device and time", farmer, nil, record(3, 90, at(0)), "already recorded"},
+		{"reading 2 too warm", farmer, nil, record(7.5, 90, at(10*time.Minute)), ""},
+		{"reading 3", farmer, nil, record(1, 88, at(20*time.Minute)), ""},
+		{"reading 4 too dry", farmer, nil, record(3, 60, at(30*time.Minute)), ""},
+	})
+
+	var readings []*chaincode.SensorReading
+	getReadings := func(from, to string) {
+		err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			readings, err = sc.GetSensorReadings(ctx, "asset1", from, to)
+			return err
+		})
+		require.NoError(t, err)
+	}
+
+	getReadings("", "")
+	require.Len(t, readings, 4)
+	require.Equal(t, 2.0, readings[0].Temperature)
+	require.Equal(t, "sensor-7", readings[0].DeviceID)
+	require.Equal(t, "Org1MSP", readings[0].RecordedBy)
+	require.True(t, readings[0].Timestamp.Equal(start))
+	require.NotEmpty(t, readings[0].Signature)
+
+	getReadings(at(10*time.Minute), at(30*time.Minute))
+	require.Len(t, readings, 2)
+	require.Equal(t, 7.5, readings[0].Temperature)
+	require.Equal(t, 1.0, readings[1].Temperature)
+
+	getReadings(at(15*time.Minute), "")
+	require.Len(t, readings, 2)
+
+	var summary *chaincode.ColdChainSummary
+	err := stub.Evaluate(supermarket, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		summary, err = sc.GetColdChainSummary(ctx, "asset1")
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, summary.Readings)
+	require.Equal(t, 1.0, summary.MinTemperature)
+	require.Equal(t, 7.5, summary.MaxTemperature)
+	require.False(t, summary.Compliant)
+	require.Len(t, summary.Violations, 2)
+	require.Equal(t, 7.5, summary.Violations[0].Temperature)
+	require.Equal(t, 60.0, summary.Violations[1].Humidity)
+}
+
+func TestDeviceRegistry(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	device := newSensor(t, "sensor-7")
+	impostor := newSensor(t, "sensor-7")
+	truck := newSensor(t, "truck-2")
+	readAt := stub.Now.Format(time.RFC3339)
+
+	record := func(d *sensor, temperature float64) txFunc {
+		payload, signature := d.reading(t, "asset1", temperature, 90, readAt)
+		return func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RecordSensorReading(ctx, payload, signature)
+		}
+	}
+	rsaKey := "-----BEGIN PUBLIC KEY-----\nMFwwDQYJKoZIhvcNAQEBBQADSwAwSAJBAK7V1mEBzrcjrmM/F8ynrbBTDx6sK5wZ\nBtRkGbNrLwOTTIm3OlSUfr8Q7l2LvAFFfdT9PCFSPp7zGiujCk+DP1sCAwEAAQ==\n-----END PUBLIC KEY-----\n"
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"unregistered device", farmer, nil, record(device, 2), "not registered"},
+		{"farmer is not an admin", farmer, nil, mocks.Invoke(chaincode.NewAssetContract(), "RegisterDevice", registerDevice(&sc, device, device.publicKeyPEM(t))), "not authorized to call asset:RegisterDevice"},
+		{"key is not PEM", farmAdmin, nil, registerDevice(&sc, device, "not a key"), "not PEM encoded"},
+		{"key is not ECDSA", farmAdmin, nil, registerDevice(&sc, device, rsaKey), "not an ECDSA key"},
+	})
+
+	stub = newLedger(t)
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"farm registers sensor", farmAdmin, nil, registerDevice(&sc, device, device.publicKeyPEM(t)), ""},
+		{"retailer registers truck sensor", retailAdmin, nil, registerDevice(&sc, truck, truck.publicKeyPEM(t)), ""},
+		{"device ID is taken", retailAdmin, nil, registerDevice(&sc, impostor, impostor.publicKeyPEM(t)), "already registered by Org1MSP"},
+		{"signed by another key", farmer, nil, record(impostor, 2), "not valid"},
+		{"payload changed after signing", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			payload, signature := device.reading(t, "asset1", 9, 90, readAt)
+			tampered := []byte(payload)
+			tampered[len(`{"assetID":"asset1","deviceID":"sensor-7","humidity":90,"temperature":`)] = '2'
+			return sc.RecordSensorReading(ctx, string(tampered), signature)
+		}, "not valid"},
+		{"device of another org", farmer, nil, record(truck, 2), "registered by Org2MSP"},
+		{"signed reading", farmer, nil, record(device, 2), ""},
+		{"retailer cannot revoke the farm sensor", retailAdmin, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RevokeDevice(ctx, "sensor-7")
+		}, "registered by Org1MSP"},
+		{"farm revokes sensor", farmAdmin, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RevokeDevice(ctx, "sensor-7")
+		}, ""},
+		{"revoked device", farmer, nil, record(device, 3), "is revoked"},
+	})
+
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		device, err := sc.GetDevice(ctx, "sensor-7")
+		if err != nil {
+			return err
+		}
+		require.Equal(t, "Org1MSP", device.OwnerOrg)
+		require.Equal(t, "revoked", device.Status)
+		require.NotNil(t, device.RevokedAt)
+		return nil
+	})
+	require.NoError(t, err)
+}
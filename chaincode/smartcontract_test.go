@@ -0,0 +1,643 @@
+package chaincode_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+	"phase2/chaincode/mocks"
+)
+
+var (
+	farmer      = mocks.NewClientIdentity("Org1MSP", "FarmerO", map[string]string{"farmer": "true"})
+	farmer2     = mocks.NewClientIdentity("Org1MSP", "FarmerT", map[string]string{"farmer": "true"})
+	retailer    = mocks.NewClientIdentity("Org2MSP", "RetailerO", map[string]string{"retailer": "true"})
+	supermarket = mocks.NewClientIdentity("Org3MSP", "SupermarketO", map[string]string{"supermarket": "true"})
+	admin       = mocks.NewClientIdentity("Org1MSP", "Admin", map[string]string{"admin": "true"})
+	farmAdmin   = mocks.NewClientIdentity("Org1MSP", "FarmAdmin", map[string]string{"admin": "true"})
+	retailAdmin = mocks.NewClientIdentity("Org2MSP", "RetailAdmin", map[string]string{"admin": "true"})
+	issuer      = mocks.NewClientIdentity("Org2MSP", "Issuer", map[string]string{"issuer": "true"})
+)
+
+// contracts gives the tests the transactions of every contract of the chaincode.
+// A transaction defined in two contracts would be ambiguous and not compile.
+type contracts struct {
+	chaincode.AssetContract
+	chaincode.MarketContract
+	chaincode.QueryContract
+}
+
+type txFunc func(ctx contractapi.TransactionContextInterface) error
+
+// step is one transaction of a scenario, submitted by identity.
+type step struct {
+	name      string
+	identity  *mocks.ClientIdentity
+	transient map[string][]byte
+	tx        txFunc
+	wantErr   string
+}
+
+// newLedger returns a ledger with the collections of collections_config.json
+// defined on the channel and registered in the collection registry, the
+// default product catalog, and tokens to pay with for every trading identity.
+func newLedger(t *testing.T) *mocks.ChaincodeStub {
+	stub := mocks.NewChaincodeStub()
+	require.NoError(t, stub.LoadCollectionsConfig("../collections_config.json"))
+	collectionsConfig, err := ioutil.ReadFile("../collections_config.json")
+	require.NoError(t, err)
+	err = stub.Submit(admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+		return (&contracts{}).RegisterSharedCollections(ctx, string(collectionsConfig))
+	})
+	require.NoError(t, err)
+	err = stub.Submit(admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+		return (&contracts{}).InitProductCatalog(ctx)
+	})
+	require.NoError(t, err)
+	err = stub.Submit(admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := (&contracts{}).IndexAssetExpirations(ctx, 100, "")
+		return err
+	})
+	require.NoError(t, err)
+	clients := []*mocks.ClientIdentity{farmer, farmer2, retailer, supermarket}
+	for _, client := range clients {
+		fund(t, stub, client, 100000)
+	}
+	// every client lets the others take payments for what it buys from them
+	for _, buyer := range clients {
+		for _, seller := range clients {
+			if buyer != seller {
+				allow(t, stub, buyer, seller, 100000)
+			}
+		}
+	}
+	return stub
+}
+
+// fund mints amount tokens to the account of client
+func fund(t *testing.T, stub *mocks.ChaincodeStub, client *mocks.ClientIdentity, amount int) {
+	err := stub.Submit(issuer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		return (&contracts{}).Mint(ctx, client.Name, client.MSPID, amount, "EUR")
+	})
+	require.NoError(t, err)
+}
+
+// allow has buyer approve seller to take up to amount EUR out of its account
+func allow(t *testing.T, stub *mocks.ChaincodeStub, buyer *mocks.ClientIdentity, seller *mocks.ClientIdentity, amount int) {
+	err := stub.Submit(buyer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		return (&contracts{}).Approve(ctx, seller.Name, seller.MSPID, amount, "EUR")
+	})
+	require.NoError(t, err)
+}
+
+func run(t *testing.T, stub *mocks.ChaincodeStub, steps []step) {
+	for _, st := range steps {
+		err := stub.Submit(st.identity, st.transient, st.tx)
+		if st.wantErr == "" {
+			require.NoError(t, err, st.name)
+		} else {
+			require.Error(t, err, st.name)
+			require.Contains(t, err.Error(), st.wantErr, st.name)
+		}
+	}
+}
+
+// testSalt is the salt both sides of the trades in the tests agree on
+const testSalt = "0123456789abcdef0123456789abcdef"
+
+// price returns the transient map of an ask or a bid of value per kg for weight kg,
+// as the canonical price terms both sides have to pass
+func price(value string, weight float64) map[string][]byte {
+	return map[string][]byte{"asset_price": []byte(fmt.Sprintf(`{"price":%s,"currency":"EUR","quantity":{"value":%v,"unit":"kg"},"salt":"%s"}`, value, weight, testSalt))}
+}
+
+// kg returns a weight of value kg
+func kg(value float64) chaincode.Quantity {
+	return chaincode.Quantity{Value: value, Unit: "kg"}
+}
+
+func transferTo(t *testing.T, assetID string, buyer *mocks.ClientIdentity) map[string][]byte {
+	input, err := json.Marshal(map[string]string{"assetID": assetID, "buyerMSP": buyer.MSPID, "buyerID": buyer.Name})
+	require.NoError(t, err)
+	return map[string][]byte{"asset_owner": input}
+}
+
+func readAsset(t *testing.T, stub *mocks.ChaincodeStub, id string) *chaincode.Asset {
+	var asset *chaincode.Asset
+	err := stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		asset, err = (&contracts{}).ReadAsset(ctx, id)
+		return err
+	})
+	require.NoError(t, err)
+	return asset
+}
+
+func TestInitLedger(t *testing.T) {
+	sc := contracts{}
+	initLedger := mocks.Invoke(chaincode.NewAssetContract(), "InitLedger", func(ctx contractapi.TransactionContextInterface) error { return sc.InitLedger(ctx) })
+
+	tests := []struct {
+		name     string
+		identity *mocks.ClientIdentity
+		wantErr  string
+	}{
+		{"farmer", farmer, ""},
+		{"retailer", retailer, "RetailerO of Org2MSP is not authorized to call asset:InitLedger, it needs one of the roles [farmer]"},
+		{"no role", mocks.NewClientIdentity("Org1MSP", "Clerk", nil), "Clerk of Org1MSP is not authorized to call asset:InitLedger, it needs one of the roles [farmer]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newLedger(t)
+			run(t, stub, []step{{tt.name, tt.identity, nil, initLedger, tt.wantErr}})
+		})
+	}
+
+	stub := newLedger(t)
+	run(t, stub, []step{{"init", farmer, nil, initLedger, ""}})
+	var assets []*chaincode.Asset
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		assets, err = sc.GetAllAssets(ctx)
+		return err
+	})
+	require.NoError(t, err)
+	require.Len(t, assets, 6)
+	require.Equal(t, "FarmerO", assets[0].Owner)
+	require.Equal(t, "Org1MSP", assets[0].OwnerOrg)
+	require.Equal(t, assets[0].Timestamp.AddDate(0, 0, 7), assets[0].ExpirationDate)
+}
+
+func TestCreateAsset(t *testing.T) {
+	sc := contracts{}
+	create := func(id string) txFunc {
+		return mocks.Invoke(chaincode.NewAssetContract(), "CreateAsset", func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, id, "red", 15, "apples")
+		})
+	}
+
+	tests := []struct {
+		name     string
+		identity *mocks.ClientIdentity
+		wantErr  string
+	}{
+		{"farmer of Org1", farmer, ""},
+		{"retailer attribute", mocks.NewClientIdentity("Org1MSP", "Mixed", map[string]string{"farmer": "true", "retailer": "true"}), "as a retailer"},
+		{"missing farmer attribute", mocks.NewClientIdentity("Org1MSP", "Clerk", nil), "needs one of the roles [farmer]"},
+		{"farmer of Org2", mocks.NewClientIdentity("Org2MSP", "FarmerO", map[string]string{"farmer": "true"}), "needs one of the roles [farmer]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newLedger(t)
+			run(t, stub, []step{{tt.name, tt.identity, nil, create("asset1"), tt.wantErr}})
+		})
+	}
+
+	stub := newLedger(t)
+	run(t, stub, []step{
+		{"create", farmer, nil, create("asset1"), ""},
+		{"create duplicate", farmer2, nil, create("asset1"), "already exists"},
+	})
+	asset := readAsset(t, stub, "asset1")
+	require.Equal(t, "apples", asset.AssetType)
+	require.Equal(t, kg(15), asset.Weight)
+	require.Equal(t, "FarmerO", asset.Owner)
+	require.Equal(t, farmer.DN(), asset.Creator)
+}
+
+func TestUpdateAndDeleteAsset(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	run(t, stub, []step{
+		{"create", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"update by other farmer", farmer2, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.UpdateAsset(ctx, "asset1", "green", 10)
+		}, "does not own asset"},
+		{"update by owner", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.UpdateAsset(ctx, "asset1", "green", 10)
+		}, ""},
+		{"update missing asset", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.UpdateAsset(ctx, "asset9", "green", 10)
+		}, "does not exist"},
+		{"delete by other farmer", farmer2, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.DeleteAsset(ctx, "asset1")
+		}, "does not own asset"},
+	})
+	asset := readAsset(t, stub, "asset1")
+	require.Equal(t, "green", asset.Color)
+	require.Equal(t, kg(10), asset.Weight)
+
+	run(t, stub, []step{
+		{"delete by owner", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.DeleteAsset(ctx, "asset1")
+		}, ""},
+	})
+	err := stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		exists, err := sc.AssetExists(ctx, "asset1")
+		require.False(t, exists)
+		return err
+	})
+	require.NoError(t, err)
+}
+
+// TestFarmToSupermarket follows a lot from the farmer (Org1) to the retailer
+// (Org2) over assetCollection, and from the retailer to the supermarket (Org3)
+// over assetCollection23.
+func TestFarmToSupermarket(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+
+	setPrice := func(ctx contractapi.TransactionContextInterface) error { return sc.SetPrice(ctx, "asset1") }
+	agreeToBuy := func(ctx contractapi.TransactionContextInterface) error { return sc.AgreeToBuy(ctx, "asset1") }
+	requestToBuy := func(ctx contractapi.TransactionContextInterface) error { return sc.RequestToBuy(ctx, "asset1") }
+	transfer := func(ctx contractapi.TransactionContextInterface) error { return sc.TransferRequestedAsset(ctx) }
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"retailer cannot set price", retailer, price("100", 15), setPrice, "does not own asset"},
+		{"price missing from transient", farmer, nil, setPrice, "asset_price key not found"},
+		{"farmer asks 100", farmer, price("100", 15), setPrice, ""},
+		{"retailer bids 100", retailer, price("100", 15), agreeToBuy, ""},
+		{"retailer requests to buy", retailer, nil, requestToBuy, ""},
+		{"second request is rejected", retailer, nil, requestToBuy, "already exists"},
+		{"retailer cannot transfer", retailer, transferTo(t, "asset1", retailer), transfer, "does not own asset"},
+		{"transfer without bid from Org3", farmer, transferTo(t, "asset1", supermarket), transfer, "buyer price for asset1 does not exist"},
+		{"farmer transfers to retailer", farmer, transferTo(t, "asset1", retailer), transfer, ""},
+	})
+
+	asset := readAsset(t, stub, "asset1")
+	require.Equal(t, "RetailerO", asset.Owner)
+	require.Equal(t, "Org2MSP", asset.OwnerOrg)
+
+	err := stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.GetAssetSalesPrice(ctx, "asset1")
+		return err
+	})
+	require.Error(t, err, "ask must be removed after the transfer")
+
+	err = stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.GetAssetBidPrice(ctx, "asset1")
+		require.Error(t, err, "bid must be removed after the transfer")
+		request, err := sc.ReadRequestToBuy(ctx, "asset1", "RetailerO", "assetCollection")
+		require.Equal(t, "accepted", request.Status)
+		return err
+	})
+	require.NoError(t, err)
+
+	run(t, stub, []step{
+		{"farmer cannot resell", farmer, price("90", 15), setPrice, "does not own asset"},
+		{"retailer asks 150", retailer, price("150", 15), setPrice, ""},
+		{"supermarket bids 140", supermarket, price("140", 15), agreeToBuy, ""},
+		{"supermarket requests to buy", supermarket, nil, requestToBuy, ""},
+		{"request cannot be repeated", supermarket, nil, requestToBuy, "already exists"},
+		{"prices do not match", retailer, transferTo(t, "asset1", supermarket), transfer, "hash for appraised value"},
+		{"supermarket bids 150", supermarket, price("150", 15), agreeToBuy, ""},
+		{"retailer transfers to supermarket", retailer, transferTo(t, "asset1", supermarket), transfer, ""},
+	})
+
+	asset = readAsset(t, stub, "asset1")
+	require.Equal(t, "SupermarketO", asset.Owner)
+
+	run(t, stub, []step{
+		{"bid was settled", supermarket, nil, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := sc.GetAssetBidPrice(ctx, "asset1")
+			return err
+		}, "asset price does not exist"},
+	})
+	err = stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		request, err := sc.ReadRequestToBuy(ctx, "asset1", "SupermarketO", "assetCollection23")
+		require.Equal(t, "accepted", request.Status)
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Org3MSP", asset.OwnerOrg)
+
+	var history []chaincode.HistoryQueryResult
+	err = stub.Evaluate(supermarket, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		history, err = sc.GetAssetHistory(ctx, "asset1")
+		return err
+	})
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	require.Equal(t, "SupermarketO", history[0].Record.Owner)
+	require.Equal(t, "RetailerO", history[1].Record.Owner)
+	require.Equal(t, "FarmerO", history[2].Record.Owner)
+}
+
+func TestPrivateDataVisibility(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"farmer asks 100", farmer, price("100", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, ""},
+		{"retailer requests to buy", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, ""},
+	})
+
+	var ask string
+	err := stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		ask, err = sc.GetAssetSalesPrice(ctx, "asset1")
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, string(price("100", 15)["asset_price"]), ask)
+
+	err = stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.GetAssetSalesPrice(ctx, "asset1")
+		return err
+	})
+	require.Error(t, err, "retailer has no ask of its own")
+
+	tests := []struct {
+		name       string
+		identity   *mocks.ClientIdentity
+		collection string
+		wantBuyer  string
+		wantErr    bool
+	}{
+		{"retailer reads assetCollection", retailer, "assetCollection", "RetailerO", false},
+		{"farmer reads assetCollection", farmer, "assetCollection", "RetailerO", false},
+		{"supermarket cannot read assetCollection", supermarket, "assetCollection", "", true},
+		{"nothing in assetCollection23", retailer, "assetCollection23", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := stub.Evaluate(tt.identity, nil, func(ctx contractapi.TransactionContextInterface) error {
+				request, err := sc.ReadRequestToBuy(ctx, "asset1", "RetailerO", tt.collection)
+				if err != nil {
+					return err
+				}
+				if tt.wantBuyer == "" {
+					require.Nil(t, request)
+				} else {
+					require.Equal(t, tt.wantBuyer, request.BuyerID)
+				}
+				return nil
+			})
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSharedCollectionRegistry(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+
+	tests := []struct {
+		orgA, orgB string
+		want       string
+	}{
+		{"Org1MSP", "Org2MSP", "assetCollection"},
+		{"Org2MSP", "Org1MSP", "assetCollection"},
+		{"Org2MSP", "Org3MSP", "assetCollection23"},
+		{"Org3MSP", "Org2MSP", "assetCollection23"},
+		{"Org1MSP", "Org3MSP", ""},
+	}
+	for _, tt := range tests {
+		err := stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			collection, err := sc.GetSharedCollection(ctx, tt.orgA, tt.orgB)
+			require.Equal(t, tt.want, collection)
+			return err
+		})
+		if tt.want == "" {
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "no shared collection is registered")
+		} else {
+			require.NoError(t, err)
+		}
+	}
+
+	register := func(config string) txFunc {
+		return func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RegisterSharedCollections(ctx, config)
+		}
+	}
+	stub.Collections["assetCollection13"] = &mocks.CollectionConfig{
+		Name: "assetCollection13", MemberOnlyWrite: true, Members: []string{"Org1MSP", "Org3MSP"},
+	}
+	run(t, stub, []step{
+		{"farmer cannot register", farmer, nil, mocks.Invoke(chaincode.NewMarketContract(), "RegisterSharedCollections", register(`[{"name":"assetCollection13","policy":"OR('Org1MSP.member','Org3MSP.member')"}]`)), "not authorized to call market:RegisterSharedCollections"},
+		{"collection of one org", admin, nil, register(`[{"name":"solo","policy":"OR('Org1MSP.member')"}]`), "at least two organizations"},
+		{"pair in two collections", admin, nil, register(`[{"name":"a","policy":"OR('Org1MSP.member','Org3MSP.member')"},{"name":"b","policy":"OR('Org3MSP.member','Org1MSP.member')"}]`), "share both"},
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"supermarket has no collection with the farmer", supermarket, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, "no shared collection is registered between Org1MSP and Org3MSP"},
+		{"admin registers Org1-Org3", admin, nil, register(`[{"name":"assetCollection13","policy":"OR('Org1MSP.member','Org3MSP.member')"}]`), ""},
+		{"supermarket requests from the farmer", supermarket, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, ""},
+		{"retailer has no request to delete", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.DeleteBuyRequest(ctx, "asset1")
+		}, "no buy request"},
+		{"supermarket deletes its request", supermarket, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.DeleteBuyRequest(ctx, "asset1")
+		}, ""},
+	})
+
+	var entries []*chaincode.SharedCollection
+	err := stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		entries, err = sc.GetAllSharedCollections(ctx)
+		return err
+	})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+}
+
+func TestPurchaseReceipts(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	terms := price("110", 15)
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"farmer asks 110", farmer, terms, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, ""},
+		{"retailer bids 110", retailer, terms, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AgreeToBuy(ctx, "asset1")
+		}, ""},
+		{"retailer requests to buy", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, ""},
+		{"farmer transfers to retailer", farmer, transferTo(t, "asset1", retailer), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferRequestedAsset(ctx)
+		}, ""},
+	})
+
+	listReceipts := func(identity *mocks.ClientIdentity) []*chaincode.Receipt {
+		var receipts []*chaincode.Receipt
+		err := stub.Evaluate(identity, nil, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			receipts, err = sc.ListMyReceipts(ctx)
+			return err
+		})
+		require.NoError(t, err)
+		return receipts
+	}
+
+	sales := listReceipts(farmer)
+	require.Len(t, sales, 1)
+	require.Equal(t, "sale", sales[0].Type)
+	require.Equal(t, "RetailerO", sales[0].Counterparty)
+	require.Equal(t, "Org2MSP", sales[0].CounterpartyOrg)
+	require.Equal(t, &chaincode.Money{Amount: 110, Currency: "EUR"}, sales[0].UnitPrice)
+	require.Equal(t, &chaincode.Quantity{Value: 15, Unit: "kg"}, sales[0].Quantity)
+	require.Equal(t, chaincode.Money{Amount: 1650, Currency: "EUR"}, sales[0].Price)
+	require.NotEmpty(t, sales[0].TxID)
+	require.False(t, sales[0].Timestamp.IsZero())
+
+	purchases := listReceipts(retailer)
+	require.Len(t, purchases, 1)
+	require.Equal(t, "purchase", purchases[0].Type)
+	require.Equal(t, "FarmerO", purchases[0].Counterparty)
+	require.Equal(t, "Org1MSP", purchases[0].CounterpartyOrg)
+	require.Equal(t, sales[0].TxID, purchases[0].TxID)
+	require.Equal(t, sales[0].Timestamp, purchases[0].Timestamp)
+	require.NotEmpty(t, sales[0].TermsHash)
+	require.Equal(t, sales[0].TermsHash, purchases[0].TermsHash)
+
+	require.Empty(t, listReceipts(supermarket))
+
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		receipt, err := sc.GetReceipt(ctx, "asset1", purchases[0].TxID)
+		if err != nil {
+			return err
+		}
+		require.Equal(t, purchases[0], receipt)
+		_, err = sc.GetReceipt(ctx, "asset1", "unknown")
+		require.Error(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// TestRepeatedTradeAfterSettlement checks that a transfer leaves nothing behind that
+// blocks the next trade of the same asset between the same orgs.
+func TestRepeatedTradeAfterSettlement(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	trade := func(seller, buyer *mocks.ClientIdentity, amount string) []step {
+		return []step{
+			{"ask", seller, price(amount, 15), func(ctx contractapi.TransactionContextInterface) error {
+				return sc.SetPrice(ctx, "asset1")
+			}, ""},
+			{"bid", buyer, price(amount, 15), func(ctx contractapi.TransactionContextInterface) error {
+				return sc.AgreeToBuy(ctx, "asset1")
+			}, ""},
+			{"request", buyer, nil, func(ctx contractapi.TransactionContextInterface) error {
+				return sc.RequestToBuy(ctx, "asset1")
+			}, ""},
+			{"transfer", seller, transferTo(t, "asset1", buyer), func(ctx contractapi.TransactionContextInterface) error {
+				return sc.TransferRequestedAsset(ctx)
+			}, ""},
+		}
+	}
+
+	run(t, stub, []step{{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+	}, ""}})
+	run(t, stub, trade(farmer, retailer, "100"))
+	run(t, stub, trade(retailer, supermarket, "120"))
+	run(t, stub, trade(supermarket, retailer, "90"))
+	run(t, stub, trade(retailer, supermarket, "95"))
+	require.Equal(t, "SupermarketO", readAsset(t, stub, "asset1").Owner)
+}
+
+func TestConcurrentBuyRequests(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	retailer2 := mocks.NewClientIdentity("Org2MSP", "RetailerT", map[string]string{"retailer": "true"})
+	fund(t, stub, retailer2, 100000)
+	allow(t, stub, retailer2, farmer, 100000)
+
+	agreeToBuy := func(ctx contractapi.TransactionContextInterface) error { return sc.AgreeToBuy(ctx, "asset1") }
+	requestToBuy := func(ctx contractapi.TransactionContextInterface) error { return sc.RequestToBuy(ctx, "asset1") }
+	transfer := func(ctx contractapi.TransactionContextInterface) error { return sc.TransferRequestedAsset(ctx) }
+	noBuyer, err := json.Marshal(map[string]string{"assetID": "asset1", "buyerMSP": "Org2MSP"})
+	require.NoError(t, err)
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"farmer asks 100", farmer, price("100", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, ""},
+		{"retailer bids 90", retailer, price("90", 15), agreeToBuy, ""},
+		{"retailer requests to buy", retailer, nil, requestToBuy, ""},
+		{"second retailer bids 100", retailer2, price("100", 15), agreeToBuy, ""},
+		{"second retailer requests to buy", retailer2, nil, requestToBuy, ""},
+		{"second retailer cannot repeat its request", retailer2, nil, requestToBuy, "already exists"},
+	})
+
+	listBuyRequests := func(identity *mocks.ClientIdentity) ([]*chaincode.RequestToBuyObject, error) {
+		var requests []*chaincode.RequestToBuyObject
+		err := stub.Evaluate(identity, nil, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			requests, err = sc.ListBuyRequests(ctx, "asset1")
+			return err
+		})
+		return requests, err
+	}
+
+	requests, err := listBuyRequests(farmer)
+	require.NoError(t, err)
+	require.Len(t, requests, 2)
+	for _, request := range requests {
+		require.Equal(t, "pending", request.Status)
+		require.Equal(t, "Org2MSP", request.BuyerOrg)
+		require.Equal(t, "FarmerO", request.Seller)
+	}
+	_, err = listBuyRequests(retailer)
+	require.Error(t, err, "only the owner lists the requests")
+
+	run(t, stub, []step{
+		{"buyer is required", farmer, map[string][]byte{"asset_owner": noBuyer}, transfer, "buyerID field must be a non-empty string"},
+		{"retailer bid does not match", farmer, transferTo(t, "asset1", retailer), transfer, "hash for appraised value"},
+		{"farmer transfers to second retailer", farmer, transferTo(t, "asset1", retailer2), transfer, ""},
+	})
+	require.Equal(t, "RetailerT", readAsset(t, stub, "asset1").Owner)
+
+	statuses := make(map[string]string)
+	err = stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		for _, buyer := range []string{"RetailerO", "RetailerT"} {
+			request, err := sc.ReadRequestToBuy(ctx, "asset1", buyer, "assetCollection")
+			if err != nil {
+				return err
+			}
+			statuses[buyer] = request.Status
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"RetailerO": "rejected", "RetailerT": "accepted"}, statuses)
+
+	requests, err = listBuyRequests(retailer2)
+	require.NoError(t, err)
+	require.Empty(t, requests, "requests made to the previous owner are not listed")
+}
@@ -0,0 +1,312 @@
+package chaincode
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// The settlement token pays for assets. There is a token per ISO 4217 currency, see units.go, and
+// amounts are in its minor units. An account is a client identity, as returned by
+// getSubmittingClientIdentity, in an org, and holds a balance per currency. Balances, allowances and
+// the total supply are kept in the public world state, so the amount of every payment, and with it
+// the agreed price, is visible to the channel members.
+const (
+	tokenBalanceObjectType   = "TokenBalance"
+	tokenAllowanceObjectType = "TokenAllowance"
+	tokenSupplyObjectType    = "TokenSupply"
+)
+
+// Mint creates amount tokens of currency in an account. Only a role the admin gave market:Mint in the
+// policy can mint, see policy.go.
+func (s *MarketContract) Mint(ctx contractapi.TransactionContextInterface, account string, accountOrg string, amount int, currency string) error {
+	if amount <= 0 {
+		return fmt.Errorf("mint amount must be a positive number")
+	}
+	if account == "" || accountOrg == "" {
+		return fmt.Errorf("account and accountOrg must be non-empty strings")
+	}
+	err := validateCurrency(currency)
+	if err != nil {
+		return err
+	}
+
+	supplyKey, err := ctx.GetStub().CreateCompositeKey(tokenSupplyObjectType, []string{currency})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	supply, err := readTokenAmount(ctx, supplyKey, currency)
+	if err != nil {
+		return err
+	}
+	supply, err = supply.Add(Money{Amount: amount, Currency: currency})
+	if err != nil {
+		return err
+	}
+	err = putTokenAmount(ctx, supplyKey, supply)
+	if err != nil {
+		return err
+	}
+
+	err = creditAccount(ctx, account, accountOrg, Money{Amount: amount, Currency: currency})
+	if err != nil {
+		return err
+	}
+	log.Printf("Mint: %d %v to %v of %v", amount, currency, account, accountOrg)
+	return nil
+}
+
+// TotalSupply returns the number of tokens of currency minted
+func (s *QueryContract) TotalSupply(ctx contractapi.TransactionContextInterface, currency string) (int, error) {
+	supplyKey, err := ctx.GetStub().CreateCompositeKey(tokenSupplyObjectType, []string{currency})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	supply, err := readTokenAmount(ctx, supplyKey, currency)
+	if err != nil {
+		return 0, err
+	}
+	return supply.Amount, nil
+}
+
+// BalanceOf returns the balance of an account in currency
+func (s *QueryContract) BalanceOf(ctx contractapi.TransactionContextInterface, account string, accountOrg string, currency string) (int, error) {
+	balance, err := readBalance(ctx, account, accountOrg, currency)
+	if err != nil {
+		return 0, err
+	}
+	return balance.Amount, nil
+}
+
+// readBalance returns the balance of an account in currency
+func readBalance(ctx contractapi.TransactionContextInterface, account string, accountOrg string, currency string) (Money, error) {
+	balanceKey, err := tokenBalanceKey(ctx, account, accountOrg, currency)
+	if err != nil {
+		return Money{}, err
+	}
+	return readTokenAmount(ctx, balanceKey, currency)
+}
+
+// ClientAccountBalance returns the balance of the caller's account in currency
+func (s *QueryContract) ClientAccountBalance(ctx contractapi.TransactionContextInterface, currency string) (int, error) {
+	clientID, clientOrgID, err := s.clientAccount(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return s.BalanceOf(ctx, clientID, clientOrgID, currency)
+}
+
+// TransferTokens moves amount tokens of currency from the caller's account to the recipient's
+func (s *MarketContract) TransferTokens(ctx contractapi.TransactionContextInterface, recipient string, recipientOrg string, amount int, currency string) error {
+	clientID, clientOrgID, err := s.clientAccount(ctx)
+	if err != nil {
+		return err
+	}
+	return moveTokens(ctx, clientID, clientOrgID, recipient, recipientOrg, Money{Amount: amount, Currency: currency})
+}
+
+// Approve allows spender to move up to amount tokens of currency out of the caller's account with
+// TransferTokensFrom. A new approval replaces the previous one.
+func (s *MarketContract) Approve(ctx contractapi.TransactionContextInterface, spender string, spenderOrg string, amount int, currency string) error {
+	allowance := Money{Amount: amount, Currency: currency}
+	err := allowance.validate()
+	if err != nil {
+		return fmt.Errorf("allowance is not valid: %v", err)
+	}
+	clientID, clientOrgID, err := s.clientAccount(ctx)
+	if err != nil {
+		return err
+	}
+	allowanceKey, err := tokenAllowanceKey(ctx, clientID, clientOrgID, spender, spenderOrg, currency)
+	if err != nil {
+		return err
+	}
+	log.Printf("Approve: %v of %v allows %v of %v %v", clientID, clientOrgID, spender, spenderOrg, allowance)
+	return putTokenAmount(ctx, allowanceKey, allowance)
+}
+
+// Allowance returns the number of tokens of currency spender can still move out of the owner's account
+func (s *QueryContract) Allowance(ctx contractapi.TransactionContextInterface, owner string, ownerOrg string, spender string, spenderOrg string, currency string) (int, error) {
+	allowanceKey, err := tokenAllowanceKey(ctx, owner, ownerOrg, spender, spenderOrg, currency)
+	if err != nil {
+		return 0, err
+	}
+	allowance, err := readTokenAmount(ctx, allowanceKey, currency)
+	if err != nil {
+		return 0, err
+	}
+	return allowance.Amount, nil
+}
+
+// TransferTokensFrom moves amount tokens of currency from an account that approved the caller to the recipient's
+func (s *MarketContract) TransferTokensFrom(ctx contractapi.TransactionContextInterface, from string, fromOrg string, recipient string, recipientOrg string, amount int, currency string) error {
+	clientID, clientOrgID, err := s.clientAccount(ctx)
+	if err != nil {
+		return err
+	}
+	err = spendAllowance(ctx, from, fromOrg, clientID, clientOrgID, Money{Amount: amount, Currency: currency})
+	if err != nil {
+		return err
+	}
+	return moveTokens(ctx, from, fromOrg, recipient, recipientOrg, Money{Amount: amount, Currency: currency})
+}
+
+// settlePayment pays the seller the agreed price out of the buyer's account in the currency of the price.
+// It is called in the transaction that changes the owner of the asset, so the asset and the payment move
+// together. The buyer authorizes the payment by approving the seller for at least the price with Approve
+// before the sale, and the payment uses up that much of the allowance.
+func settlePayment(ctx contractapi.TransactionContextInterface, buyer string, buyerOrg string, seller string, sellerOrg string, price Money) error {
+	if price.Amount == 0 {
+		return nil
+	}
+	err := spendAllowance(ctx, buyer, buyerOrg, seller, sellerOrg, price)
+	if err != nil {
+		return fmt.Errorf("failed to settle payment of %v: %v", price, err)
+	}
+	err = moveTokens(ctx, buyer, buyerOrg, seller, sellerOrg, price)
+	if err != nil {
+		return fmt.Errorf("failed to settle payment of %v: %v", price, err)
+	}
+	return nil
+}
+
+// canPay reports whether buyer holds amount and has approved seller for it, so settlePayment would succeed
+func canPay(ctx contractapi.TransactionContextInterface, buyer string, buyerOrg string, seller string, sellerOrg string, amount Money) (bool, error) {
+	balance, err := readBalance(ctx, buyer, buyerOrg, amount.Currency)
+	if err != nil {
+		return false, err
+	}
+	allowanceKey, err := tokenAllowanceKey(ctx, buyer, buyerOrg, seller, sellerOrg, amount.Currency)
+	if err != nil {
+		return false, err
+	}
+	allowance, err := readTokenAmount(ctx, allowanceKey, amount.Currency)
+	if err != nil {
+		return false, err
+	}
+	return balance.Amount >= amount.Amount && allowance.Amount >= amount.Amount, nil
+}
+
+// spendAllowance takes amount off what the owner allowed spender to move out of the owner's account
+func spendAllowance(ctx contractapi.TransactionContextInterface, owner string, ownerOrg string, spender string, spenderOrg string, amount Money) error {
+	allowanceKey, err := tokenAllowanceKey(ctx, owner, ownerOrg, spender, spenderOrg, amount.Currency)
+	if err != nil {
+		return err
+	}
+	allowance, err := readTokenAmount(ctx, allowanceKey, amount.Currency)
+	if err != nil {
+		return err
+	}
+	if allowance.Amount < amount.Amount {
+		return fmt.Errorf("%s of %s is allowed %d tokens of %s, not %d", spender, spenderOrg, allowance.Amount, owner, amount.Amount)
+	}
+	allowance.Amount -= amount.Amount
+	return putTokenAmount(ctx, allowanceKey, allowance)
+}
+
+// clientAccount returns the account of the caller
+func (s *SmartContract) clientAccount(ctx contractapi.TransactionContextInterface) (string, string, error) {
+	client, err := getClient(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	return client.ID, client.MSPID, nil
+}
+
+func moveTokens(ctx contractapi.TransactionContextInterface, from string, fromOrg string, to string, toOrg string, amount Money) error {
+	err := amount.validate()
+	if err != nil {
+		return err
+	}
+	if amount.Amount == 0 {
+		return fmt.Errorf("transfer amount must be a positive number")
+	}
+	if to == "" || toOrg == "" {
+		return fmt.Errorf("recipient and recipientOrg must be non-empty strings")
+	}
+	if from == to && fromOrg == toOrg {
+		return fmt.Errorf("cannot transfer to the same account")
+	}
+
+	fromKey, err := tokenBalanceKey(ctx, from, fromOrg, amount.Currency)
+	if err != nil {
+		return err
+	}
+	balance, err := readTokenAmount(ctx, fromKey, amount.Currency)
+	if err != nil {
+		return err
+	}
+	if balance.Amount < amount.Amount {
+		return fmt.Errorf("insufficient funds: %s of %s has %v, needs %v", from, fromOrg, balance, amount)
+	}
+	balance.Amount -= amount.Amount
+	err = putTokenAmount(ctx, fromKey, balance)
+	if err != nil {
+		return err
+	}
+
+	err = creditAccount(ctx, to, toOrg, amount)
+	if err != nil {
+		return err
+	}
+	log.Printf("moveTokens: %v from %v of %v to %v of %v", amount, from, fromOrg, to, toOrg)
+	return nil
+}
+
+func creditAccount(ctx contractapi.TransactionContextInterface, account string, accountOrg string, amount Money) error {
+	balanceKey, err := tokenBalanceKey(ctx, account, accountOrg, amount.Currency)
+	if err != nil {
+		return err
+	}
+	balance, err := readTokenAmount(ctx, balanceKey, amount.Currency)
+	if err != nil {
+		return err
+	}
+	balance, err = balance.Add(amount)
+	if err != nil {
+		return err
+	}
+	return putTokenAmount(ctx, balanceKey, balance)
+}
+
+func tokenBalanceKey(ctx contractapi.TransactionContextInterface, account string, accountOrg string, currency string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(tokenBalanceObjectType, []string{accountOrg, account, currency})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return key, nil
+}
+
+func tokenAllowanceKey(ctx contractapi.TransactionContextInterface, owner string, ownerOrg string, spender string, spenderOrg string, currency string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(tokenAllowanceObjectType, []string{ownerOrg, owner, spenderOrg, spender, currency})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return key, nil
+}
+
+// readTokenAmount reads a balance, an allowance or the supply in currency, 0 when there is none
+func readTokenAmount(ctx contractapi.TransactionContextInterface, key string, currency string) (Money, error) {
+	amountBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return Money{}, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if amountBytes == nil {
+		return Money{Currency: currency}, nil
+	}
+	amount, err := strconv.Atoi(string(amountBytes))
+	if err != nil {
+		return Money{}, fmt.Errorf("failed to read amount of %q: %v", key, err)
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+func putTokenAmount(ctx contractapi.TransactionContextInterface, key string, amount Money) error {
+	err := ctx.GetStub().PutState(key, []byte(strconv.Itoa(amount.Amount)))
+	if err != nil {
+		return fmt.Errorf("failed to put to world state: %v", err)
+	}
+	return nil
+}
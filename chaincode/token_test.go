@@ -0,0 +1,141 @@
+package chaincode_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+	"phase2/chaincode/mocks"
+)
+
+func balanceOf(t *testing.T, stub *mocks.ChaincodeStub, client *mocks.ClientIdentity) int {
+	var balance int
+	err := stub.Evaluate(client, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		balance, err = (&contracts{}).BalanceOf(ctx, client.Name, client.MSPID, "EUR")
+		return err
+	})
+	require.NoError(t, err)
+	return balance
+}
+
+// designateIssuer has the admin add a role for the issuers of org to the policy
+func designateIssuer(t *testing.T, stub *mocks.ChaincodeStub, org string) {
+	var policy *chaincode.Policy
+	err := stub.Evaluate(admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		policy, err = (&contracts{}).GetPolicy(ctx)
+		return err
+	})
+	require.NoError(t, err)
+	policy.Roles = append(policy.Roles, chaincode.PolicyRole{Name: "issuer", MSPID: org, Attribute: "issuer", Functions: []string{"market:Mint"}})
+	policyJSON, err := json.Marshal(policy)
+	require.NoError(t, err)
+	err = stub.Submit(admin, nil, mocks.Invoke(chaincode.NewAssetContract(), "SetPolicy", func(ctx contractapi.TransactionContextInterface) error {
+		return (&contracts{}).SetPolicy(ctx, string(policyJSON))
+	}))
+	require.NoError(t, err)
+}
+
+func TestTokens(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	mint := mocks.Invoke(chaincode.NewMarketContract(), "Mint", func(ctx contractapi.TransactionContextInterface) error {
+		return sc.Mint(ctx, "FarmerO", "Org1MSP", 100, "EUR")
+	})
+
+	run(t, stub, []step{
+		{"nobody mints until the admin designates an issuer", issuer, nil, mint, "needs one of the roles []"},
+	})
+	designateIssuer(t, stub, "Org2MSP")
+
+	run(t, stub, []step{
+		{"issuer mints", issuer, nil, mint, ""},
+		{"farmer cannot mint", farmer, nil, mocks.Invoke(chaincode.NewMarketContract(), "Mint", func(ctx contractapi.TransactionContextInterface) error {
+			return sc.Mint(ctx, "FarmerO", "Org1MSP", 100, "EUR")
+		}), "not authorized to call market:Mint"},
+		{"issuer of another org cannot mint", mocks.NewClientIdentity("Org1MSP", "FarmIssuer", map[string]string{"issuer": "true"}), nil, mocks.Invoke(chaincode.NewMarketContract(), "Mint", func(ctx contractapi.TransactionContextInterface) error {
+			return sc.Mint(ctx, "FarmerO", "Org1MSP", 100, "EUR")
+		}), "needs one of the roles [issuer]"},
+		{"amount must be positive", issuer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.Mint(ctx, "FarmerO", "Org1MSP", 0, "EUR")
+		}, "must be a positive number"},
+		{"retailer pays farmer", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferTokens(ctx, "FarmerO", "Org1MSP", 300, "EUR")
+		}, ""},
+		{"retailer cannot pay more than its balance", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferTokens(ctx, "FarmerO", "Org1MSP", 100000, "EUR")
+		}, "insufficient funds"},
+		{"supermarket lets retailer spend 500", supermarket, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.Approve(ctx, "RetailerO", "Org2MSP", 500, "EUR")
+		}, ""},
+		{"retailer spends 400 of supermarket", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferTokensFrom(ctx, "SupermarketO", "Org3MSP", "FarmerO", "Org1MSP", 400, "EUR")
+		}, ""},
+		{"retailer cannot spend more than allowed", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferTokensFrom(ctx, "SupermarketO", "Org3MSP", "FarmerO", "Org1MSP", 200, "EUR")
+		}, "is allowed 100 tokens"},
+	})
+
+	require.Equal(t, 100800, balanceOf(t, stub, farmer))
+	require.Equal(t, 99700, balanceOf(t, stub, retailer))
+	require.Equal(t, 99600, balanceOf(t, stub, supermarket))
+
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		supply, err := sc.TotalSupply(ctx, "EUR")
+		require.Equal(t, 400100, supply)
+		allowance, err := sc.Allowance(ctx, "SupermarketO", "Org3MSP", "RetailerO", "Org2MSP", "EUR")
+		require.Equal(t, 100, allowance)
+		return err
+	})
+	require.NoError(t, err)
+}
+
+func TestTransferSettlesPayment(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	retailer2 := mocks.NewClientIdentity("Org2MSP", "RetailerT", map[string]string{"retailer": "true"})
+	fund(t, stub, retailer2, 100)
+
+	agreeToBuy := func(ctx contractapi.TransactionContextInterface) error { return sc.AgreeToBuy(ctx, "asset1") }
+	requestToBuy := func(ctx contractapi.TransactionContextInterface) error { return sc.RequestToBuy(ctx, "asset1") }
+	transfer := func(ctx contractapi.TransactionContextInterface) error { return sc.TransferRequestedAsset(ctx) }
+
+	run(t, stub, []step{
+		{"farmer creates 15 kg lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"farmer asks 10 per kg", farmer, price("10", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, ""},
+		{"second retailer bids", retailer2, price("10", 15), agreeToBuy, ""},
+		{"second retailer requests", retailer2, nil, requestToBuy, ""},
+		{"second retailer has not approved the payment", farmer, transferTo(t, "asset1", retailer2), transfer, "is allowed 0 tokens of RetailerT"},
+		{"second retailer approves the farmer", retailer2, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.Approve(ctx, "FarmerO", "Org1MSP", 150, "EUR")
+		}, ""},
+		{"second retailer cannot pay 150", farmer, transferTo(t, "asset1", retailer2), transfer, "insufficient funds"},
+	})
+	require.Equal(t, "FarmerO", readAsset(t, stub, "asset1").Owner, "the lot stays with the seller when the payment fails")
+	require.Equal(t, 100, balanceOf(t, stub, retailer2))
+
+	run(t, stub, []step{
+		{"retailer bids", retailer, price("10", 15), agreeToBuy, ""},
+		{"retailer requests", retailer, nil, requestToBuy, ""},
+		{"farmer transfers to retailer", farmer, transferTo(t, "asset1", retailer), transfer, ""},
+	})
+	require.Equal(t, "RetailerO", readAsset(t, stub, "asset1").Owner)
+	require.Equal(t, 100150, balanceOf(t, stub, farmer))
+	require.Equal(t, 99850, balanceOf(t, stub, retailer))
+
+	// the payment used up 150 of what the retailer allowed the farmer
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		allowance, err := sc.Allowance(ctx, "RetailerO", "Org2MSP", "FarmerO", "Org1MSP", "EUR")
+		require.Equal(t, 99850, allowance)
+		return err
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,331 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Asks, bids, buy requests and negotiation offers can be limited to a validity window by passing a
+// duration such as "48h" under valid_for in the transient map. The window starts at the transaction
+// timestamp. The price bytes are compared by hash, so the end of the window of an ask or a bid is kept
+// in a separate record: next to the ask in the seller's implicit collection, and for a bid in the
+// collection the buyer's org shares with the owner's org, where the transfer can read it. The ask and
+// the bid of an accepted offer keep the window of the offer.
+const (
+	validityObjectType   = "ValidUntil"
+	validityTransientKey = "valid_for"
+)
+
+// tradeValidity is the end of the validity window of an ask or a bid placed by org
+type tradeValidity struct {
+	ValidUntil time.Time `json:"validUntil"`
+	Org        string    `json:"org"`
+}
+
+// expiredValidity is the key of an ended validity window and the attributes of the price key it belongs to
+type expiredValidity struct {
+	key        string
+	attributes []string
+}
+
+// PrunedTradingState lists the assets whose expired entries PruneExpiredTradingState removed or closed
+type PrunedTradingState struct {
+	Asks           []string `json:"asks"`
+	NegotiatedAsks []string `json:"negotiatedAsks"`
+	Bids           []string `json:"bids"`
+	BuyRequests    []string `json:"buyRequests"`
+	Negotiations   []string `json:"negotiations"`
+}
+
+// PruneExpiredTradingState removes the expired asks, negotiated asks and bids of the caller's org from
+// its implicit collection and the expired pending buy requests from the collections the org shares with
+// others, and marks the negotiations in those collections whose last offer has expired as expired
+func (s *MarketContract) PruneExpiredTradingState(ctx contractapi.TransactionContextInterface) (*PrunedTradingState, error) {
+	err := verifyClientOrgMatchesPeerOrg(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clientOrgID, err := getClientMSPID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+	collection, err := buildCollectionName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sharedCollections, err := s.sharedCollectionsOf(ctx, clientOrgID)
+	if err != nil {
+		return nil, err
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PrunedTradingState{Asks: []string{}, NegotiatedAsks: []string{}, Bids: []string{}, BuyRequests: []string{}, Negotiations: []string{}}
+
+	// asks and their windows are in the implicit collection
+	asks, err := expiredValidities(ctx, collection, typeAssetForSale, clientOrgID, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, ask := range asks {
+		assetPriceKey, err := askKey(ctx, ask.attributes[0])
+		if err != nil {
+			return nil, err
+		}
+		err = deletePrivateData(ctx, collection, assetPriceKey, ask.key)
+		if err != nil {
+			return nil, err
+		}
+		result.Asks = append(result.Asks, ask.attributes[0])
+	}
+	negotiatedAsks, err := expiredValidities(ctx, collection, typeNegotiatedAsk, clientOrgID, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, ask := range negotiatedAsks {
+		if len(ask.attributes) != 2 {
+			return nil, fmt.Errorf("validity key %q is not valid", ask.key)
+		}
+		assetPriceKey, err := negotiatedAskKey(ctx, ask.attributes[0], ask.attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		err = deletePrivateData(ctx, collection, assetPriceKey, ask.key)
+		if err != nil {
+			return nil, err
+		}
+		result.NegotiatedAsks = append(result.NegotiatedAsks, ask.attributes[0])
+	}
+
+	for _, sharedCollection := range sharedCollections {
+		// the windows of the org's bids are in the shared collections, the bids in the implicit collection
+		bids, err := expiredValidities(ctx, sharedCollection, typeAssetBid, clientOrgID, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, bid := range bids {
+			if len(bid.attributes) != 2 {
+				return nil, fmt.Errorf("validity key %q is not valid", bid.key)
+			}
+			assetBidKey, err := bidKey(ctx, bid.attributes[0], bid.attributes[1])
+			if err != nil {
+				return nil, err
+			}
+			err = deletePrivateData(ctx, collection, assetBidKey)
+			if err != nil {
+				return nil, err
+			}
+			err = deletePrivateData(ctx, sharedCollection, bid.key)
+			if err != nil {
+				return nil, err
+			}
+			result.Bids = append(result.Bids, bid.attributes[0])
+		}
+
+		requests, err := getBuyRequests(ctx, sharedCollection)
+		if err != nil {
+			return nil, err
+		}
+		for _, request := range requests {
+			if request.Status != buyRequestPending || !request.expiredAt(now) {
+				continue
+			}
+			requestKey, err := ctx.GetStub().CreateCompositeKey(requestToBuyObjectType, []string{request.ID, request.BuyerID})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create composite key: %v", err)
+			}
+			err = deletePrivateData(ctx, sharedCollection, requestKey)
+			if err != nil {
+				return nil, err
+			}
+			result.BuyRequests = append(result.BuyRequests, request.ID)
+		}
+
+		negotiations, err := expiredNegotiations(ctx, sharedCollection, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, negotiation := range negotiations {
+			negotiation.Status = negotiationExpired
+			negotiation.UpdatedAt = now
+			err = putNegotiation(ctx, sharedCollection, negotiation)
+			if err != nil {
+				return nil, err
+			}
+			result.Negotiations = append(result.Negotiations, negotiation.AssetID)
+		}
+	}
+
+	log.Printf("PruneExpiredTradingState: %v removed %d asks, %d negotiated asks, %d bids, %d buy requests, closed %d negotiations",
+		clientOrgID, len(result.Asks), len(result.NegotiatedAsks), len(result.Bids), len(result.BuyRequests), len(result.Negotiations))
+	return result, nil
+}
+
+// validUntilFromTransient returns the end of the validity window passed under valid_for, nil when there is none
+func validUntilFromTransient(ctx contractapi.TransactionContextInterface) (*time.Time, error) {
+	transMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("error getting transient: %v", err)
+	}
+	validFor, ok := transMap[validityTransientKey]
+	if !ok {
+		return nil, nil
+	}
+
+	window, err := time.ParseDuration(string(validFor))
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a duration such as 48h: %v", validityTransientKey, err)
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("%s must be a positive duration", validityTransientKey)
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	validUntil := now.Add(window)
+	return &validUntil, nil
+}
+
+// expiredNegotiations returns the negotiations in a shared collection whose last offer has expired at now
+func expiredNegotiations(ctx contractapi.TransactionContextInterface, sharedCollection string, now time.Time) ([]*Negotiation, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(sharedCollection, negotiationObjectType, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read negotiations from %s: %v", sharedCollection, err)
+	}
+	defer resultsIterator.Close()
+
+	var expired []*Negotiation
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var negotiation Negotiation
+		err = json.Unmarshal(queryResponse.Value, &negotiation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal negotiation %s: %v", queryResponse.Key, err)
+		}
+		if negotiation.expiredAt(now) {
+			expired = append(expired, &negotiation)
+		}
+	}
+	return expired, nil
+}
+
+// validityKey returns the key of the validity record of an ask or a bid, priceType followed by the
+// attributes of the price key
+func validityKey(ctx contractapi.TransactionContextInterface, priceType string, attributes ...string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(validityObjectType, append([]string{priceType}, attributes...))
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return key, nil
+}
+
+// putValidity records the end of the validity window of an ask or a bid of the caller's org. A nil
+// validUntil removes the window of an earlier ask or bid, so the new one does not expire.
+func putValidity(ctx contractapi.TransactionContextInterface, collection string, key string, validUntil *time.Time) error {
+	clientOrgID, err := getClientMSPID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+	return putOrgValidity(ctx, collection, key, validUntil, clientOrgID)
+}
+
+// putOrgValidity records the end of the validity window of an ask or a bid of org, whose pruning removes it
+func putOrgValidity(ctx contractapi.TransactionContextInterface, collection string, key string, validUntil *time.Time, org string) error {
+	if validUntil == nil {
+		return deletePrivateData(ctx, collection, key)
+	}
+
+	validityJSON, err := json.Marshal(tradeValidity{ValidUntil: *validUntil, Org: org})
+	if err != nil {
+		return fmt.Errorf("failed to marshal validity into JSON: %v", err)
+	}
+	err = ctx.GetStub().PutPrivateData(collection, key, validityJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put validity to %s: %v", collection, err)
+	}
+	return nil
+}
+
+// verifyStillValid fails when the validity window recorded under key has ended
+func verifyStillValid(ctx contractapi.TransactionContextInterface, collection string, key string, entry string) error {
+	validityJSON, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return fmt.Errorf("failed to read validity of %s: %v", entry, err)
+	}
+	if validityJSON == nil {
+		return nil
+	}
+
+	var validity tradeValidity
+	err = json.Unmarshal(validityJSON, &validity)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal JSON: %v", err)
+	}
+	now, err := getTxTime(ctx)
+	if err != nil {
+		return err
+	}
+	if !now.Before(validity.ValidUntil) {
+		return fmt.Errorf("%s expired at %v", entry, validity.ValidUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// expiredValidities returns the keys of the ended validity windows of org's asks or bids in a
+// collection, with the attributes of the price key they belong to
+func expiredValidities(ctx contractapi.TransactionContextInterface, collection string, priceType string, org string, now time.Time) ([]expiredValidity, error) {
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, validityObjectType, []string{priceType})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read validities from %s: %v", collection, err)
+	}
+	defer resultsIterator.Close()
+
+	var expired []expiredValidity
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var validity tradeValidity
+		err = json.Unmarshal(queryResponse.Value, &validity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal validity %s: %v", queryResponse.Key, err)
+		}
+		if validity.Org != org || now.Before(validity.ValidUntil) {
+			continue
+		}
+		_, compositeKeyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(compositeKeyParts) < 2 {
+			return nil, fmt.Errorf("validity key %q is not valid", queryResponse.Key)
+		}
+		expired = append(expired, expiredValidity{key: queryResponse.Key, attributes: compositeKeyParts[1:]})
+	}
+
+	return expired, nil
+}
+
+// deletePrivateData removes keys from a collection
+func deletePrivateData(ctx contractapi.TransactionContextInterface, collection string, keys ...string) error {
+	for _, key := range keys {
+		err := ctx.GetStub().DelPrivateData(collection, key)
+		if err != nil {
+			return fmt.Errorf("failed to delete %q from %s: %v", key, collection, err)
+		}
+	}
+	return nil
+}
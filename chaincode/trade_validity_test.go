@@ -0,0 +1,178 @@
+package chaincode_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+	"phase2/chaincode/mocks"
+)
+
+func validFor(transient map[string][]byte, window string) map[string][]byte {
+	if transient == nil {
+		transient = map[string][]byte{}
+	}
+	transient["valid_for"] = []byte(window)
+	return transient
+}
+
+func TestTradeValidityWindows(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+
+	setPrice := func(ctx contractapi.TransactionContextInterface) error { return sc.SetPrice(ctx, "asset1") }
+	agreeToBuy := func(ctx contractapi.TransactionContextInterface) error { return sc.AgreeToBuy(ctx, "asset1") }
+	requestToBuy := func(ctx contractapi.TransactionContextInterface) error { return sc.RequestToBuy(ctx, "asset1") }
+	transfer := func(ctx contractapi.TransactionContextInterface) error { return sc.TransferRequestedAsset(ctx) }
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"window is not a duration", farmer, validFor(price("100", 15), "soon"), setPrice, "valid_for must be a duration"},
+		{"window is negative", farmer, validFor(price("100", 15), "-1h"), setPrice, "must be a positive duration"},
+		{"farmer asks 100 for an hour", farmer, validFor(price("100", 15), "1h"), setPrice, ""},
+		{"retailer bids 100 for half an hour", retailer, validFor(price("100", 15), "30m"), agreeToBuy, ""},
+		{"retailer requests for two hours", retailer, validFor(nil, "2h"), requestToBuy, ""},
+	})
+
+	stub.Advance(40 * time.Minute)
+	run(t, stub, []step{
+		{"bid has expired", farmer, transferTo(t, "asset1", retailer), transfer, "buyer price for asset1 expired"},
+		{"retailer bids again without a window", retailer, price("100", 15), agreeToBuy, ""},
+	})
+
+	stub.Advance(30 * time.Minute)
+	run(t, stub, []step{
+		{"ask has expired", farmer, transferTo(t, "asset1", retailer), transfer, "seller price for asset1 expired"},
+	})
+
+	var pruned *chaincode.PrunedTradingState
+	run(t, stub, []step{{"farmer prunes", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		pruned, err = sc.PruneExpiredTradingState(ctx)
+		return err
+	}, ""}})
+	require.Equal(t, []string{"asset1"}, pruned.Asks)
+	require.Empty(t, pruned.Bids)
+	require.Empty(t, pruned.BuyRequests)
+
+	err := stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.GetAssetSalesPrice(ctx, "asset1")
+		return err
+	})
+	require.Error(t, err, "expired ask must be pruned")
+
+	stub.Advance(time.Hour)
+	run(t, stub, []step{
+		{"farmer asks 100 again", farmer, price("100", 15), setPrice, ""},
+		{"request has expired", farmer, transferTo(t, "asset1", retailer), transfer, "buy request of RetailerO for asset1 expired"},
+		{"expired request can be replaced", retailer, nil, requestToBuy, ""},
+		{"farmer transfers to retailer", farmer, transferTo(t, "asset1", retailer), transfer, ""},
+	})
+	require.Equal(t, "RetailerO", readAsset(t, stub, "asset1").Owner)
+}
+
+func TestPruneExpiredBidsAndRequests(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"retailer bids for ten minutes", retailer, validFor(price("100", 15), "10m"), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AgreeToBuy(ctx, "asset1")
+		}, ""},
+		{"retailer requests for ten minutes", retailer, validFor(nil, "10m"), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, ""},
+	})
+
+	var pruned *chaincode.PrunedTradingState
+	prune := func(name string) step {
+		return step{name, retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			pruned, err = sc.PruneExpiredTradingState(ctx)
+			return err
+		}, ""}
+	}
+
+	run(t, stub, []step{prune("nothing has expired yet")})
+	require.Empty(t, pruned.Bids)
+	require.Empty(t, pruned.BuyRequests)
+
+	stub.Advance(time.Hour)
+	run(t, stub, []step{prune("retailer prunes")})
+	require.Empty(t, pruned.Asks)
+	require.Equal(t, []string{"asset1"}, pruned.Bids)
+	require.Equal(t, []string{"asset1"}, pruned.BuyRequests)
+
+	err := stub.Evaluate(retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := sc.GetAssetBidPrice(ctx, "asset1")
+		require.Error(t, err, "expired bid must be pruned")
+		request, err := sc.ReadRequestToBuy(ctx, "asset1", "RetailerO", "assetCollection")
+		require.Nil(t, request, "expired request must be pruned")
+		return err
+	})
+	require.NoError(t, err)
+}
+
+func TestPruneExpiredNegotiations(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	retailer2 := mocks.NewClientIdentity("Org2MSP", "RetailerT", map[string]string{"retailer": "true"})
+
+	open := func(ctx contractapi.TransactionContextInterface) error { return sc.OpenNegotiation(ctx, "asset1") }
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"retailer offers 80 for an hour", retailer, validFor(price("80", 15), "1h"), open, ""},
+		{"farmer counters 100 for an hour", farmer, validFor(price("100", 15), "1h"), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CounterOffer(ctx, "asset1", "RetailerO")
+		}, ""},
+		{"retailer accepts 100", retailer, price("100", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AcceptOffer(ctx, "asset1", "RetailerO")
+		}, ""},
+		{"second retailer offers 90 for half an hour", retailer2, validFor(price("90", 15), "30m"), open, ""},
+	})
+
+	stub.Advance(2 * time.Hour)
+	run(t, stub, []step{
+		{"negotiated ask has expired", farmer, transferTo(t, "asset1", retailer), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferRequestedAsset(ctx)
+		}, "seller price for asset1 expired"},
+		{"expired offer cannot be countered", farmer, price("95", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CounterOffer(ctx, "asset1", "RetailerT")
+		}, "offer 1 of the negotiation of RetailerT for asset asset1 expired"},
+	})
+
+	var pruned *chaincode.PrunedTradingState
+	prune := func(name string, client *mocks.ClientIdentity) step {
+		return step{name, client, nil, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			pruned, err = sc.PruneExpiredTradingState(ctx)
+			return err
+		}, ""}
+	}
+	run(t, stub, []step{prune("farmer prunes", farmer)})
+	require.Equal(t, []string{"asset1"}, pruned.NegotiatedAsks)
+	require.Equal(t, []string{"asset1"}, pruned.Negotiations, "only the negotiation with the second retailer was still open")
+	require.Empty(t, pruned.Bids)
+
+	run(t, stub, []step{prune("retailer prunes", retailer)})
+	require.Equal(t, []string{"asset1"}, pruned.Bids, "the bid of the accepted offer has its window")
+	require.Empty(t, pruned.Negotiations)
+
+	err := stub.Evaluate(retailer2, nil, func(ctx contractapi.TransactionContextInterface) error {
+		negotiation, err := sc.GetNegotiation(ctx, "asset1", "RetailerT")
+		require.Equal(t, "expired", negotiation.Status)
+		return err
+	})
+	require.NoError(t, err)
+	run(t, stub, []step{{"second retailer opens a new negotiation", retailer2, price("90", 15), open, ""}})
+}
@@ -0,0 +1,104 @@
+package chaincode
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Client is the identity that submitted a transaction
+type Client struct {
+	MSPID string
+	// ID is the common name of the certificate, the owner of the assets the client creates and buys
+	ID string
+	// DN is the subject and issuer of the certificate, x509::CN=FarmerO,OU=client::CN=ca.org1.example.com,...
+	DN string
+
+	identity   cid.ClientIdentity
+	attributes map[string]bool
+}
+
+// HasAttribute returns true when the client's certificate has the attribute name=true, e.g. farmer=true.
+// Roles are defined by the policy, so attributes are read as they are asked for, each once.
+func (c *Client) HasAttribute(name string) bool {
+	held, ok := c.attributes[name]
+	if !ok {
+		value, found, err := c.identity.GetAttributeValue(name)
+		if err != nil {
+			log.Printf("failed to read attribute %s of %s: %v", name, c.ID, err)
+		}
+		held = err == nil && found && value == "true"
+		c.attributes[name] = held
+	}
+	return held
+}
+
+// TransactionContextInterface is the context the contracts of the chaincode run their transactions with
+type TransactionContextInterface interface {
+	contractapi.TransactionContextInterface
+	GetClient() (*Client, error)
+}
+
+// TransactionContext resolves the submitting client on first use and keeps it for the rest of the
+// transaction. contractapi makes a new one for every transaction.
+type TransactionContext struct {
+	contractapi.TransactionContext
+	client *Client
+}
+
+// GetClient returns the submitting client
+func (ctx *TransactionContext) GetClient() (*Client, error) {
+	if ctx.client == nil {
+		client, err := resolveClient(ctx.GetClientIdentity())
+		if err != nil {
+			return nil, err
+		}
+		ctx.client = client
+	}
+	return ctx.client, nil
+}
+
+// getClient returns the submitting client, from the transaction context when it is the chaincode's
+func getClient(ctx contractapi.TransactionContextInterface) (*Client, error) {
+	if txCtx, ok := ctx.(TransactionContextInterface); ok {
+		return txCtx.GetClient()
+	}
+	return resolveClient(ctx.GetClientIdentity())
+}
+
+// getClientMSPID returns the MSP ID of the submitting client
+func getClientMSPID(ctx contractapi.TransactionContextInterface) (string, error) {
+	client, err := getClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	return client.MSPID, nil
+}
+
+// resolveClient reads the client from its certificate. GetID returns the subject and issuer of the
+// certificate base64 encoded, see pkg/cid/cid.go of fabric-chaincode-go.
+func resolveClient(identity cid.ClientIdentity) (*Client, error) {
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed getting client's orgID: %v", err)
+	}
+	b64ID, err := identity.GetID()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read clientID: %v", err)
+	}
+	decodeID, err := base64.StdEncoding.DecodeString(b64ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to base64 decode clientID: %v", err)
+	}
+
+	return &Client{
+		MSPID:      mspID,
+		ID:         _between(string(decodeID), "x509::CN=", ","),
+		DN:         string(decodeID),
+		identity:   identity,
+		attributes: make(map[string]bool),
+	}, nil
+}
@@ -0,0 +1,80 @@
+package chaincode_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+	"phase2/chaincode/mocks"
+)
+
+// countingIdentity counts how often the certificate of the client is read
+type countingIdentity struct {
+	*mocks.ClientIdentity
+	reads int
+}
+
+func (c *countingIdentity) GetID() (string, error) {
+	c.reads++
+	return c.ClientIdentity.GetID()
+}
+
+func (c *countingIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	c.reads++
+	return c.ClientIdentity.GetAttributeValue(attrName)
+}
+
+func TestTransactionContext(t *testing.T) {
+	stub := newLedger(t)
+	err := stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		identity := &countingIdentity{ClientIdentity: farmer}
+		txCtx := new(chaincode.TransactionContext)
+		txCtx.SetStub(ctx.GetStub())
+		txCtx.SetClientIdentity(identity)
+
+		client, err := txCtx.GetClient()
+		require.NoError(t, err)
+		require.Equal(t, "Org1MSP", client.MSPID)
+		require.Equal(t, "FarmerO", client.ID)
+		require.Equal(t, farmer.DN(), client.DN)
+		require.True(t, client.HasAttribute("farmer"))
+		require.False(t, client.HasAttribute("admin"))
+
+		again, err := txCtx.GetClient()
+		require.NoError(t, err)
+		require.Same(t, client, again)
+		require.True(t, again.HasAttribute("farmer"))
+		require.Equal(t, 3, identity.reads, "the ID and each attribute are read once")
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestTransactionHooks(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	var audit bytes.Buffer
+	log.SetOutput(&audit)
+	defer log.SetOutput(os.Stderr)
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, mocks.Invoke(chaincode.NewAssetContract(), "CreateAsset", func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}), ""},
+		{"trading is open to every member", farmer, price("100", 15), mocks.Invoke(chaincode.NewMarketContract(), "SetPrice", func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}), ""},
+		{"retailer cannot create", retailer, nil, mocks.Invoke(chaincode.NewAssetContract(), "CreateAsset", func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset2", "red", 15, "apples")
+		}), "not authorized to call asset:CreateAsset"},
+	})
+
+	require.Contains(t, audit.String(), "audit: asset:CreateAsset by FarmerO of Org1MSP")
+	require.Contains(t, audit.String(), "audit: market:SetPrice by FarmerO of Org1MSP")
+	require.Contains(t, audit.String(), "audit: asset:CreateAsset denied to RetailerO of Org2MSP")
+}
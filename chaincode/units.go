@@ -0,0 +1,197 @@
+package chaincode
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// currencyMinorUnits are the ISO 4217 currencies the chaincode accepts, with the number of digits
+// of their minor unit. Amounts of money are always in minor units, e.g. cents for EUR.
+var currencyMinorUnits = map[string]int{
+	"AUD": 2, "CAD": 2, "CHF": 2, "CNY": 2, "DKK": 2, "EUR": 2, "GBP": 2,
+	"JPY": 0, "NOK": 2, "NZD": 2, "PLN": 2, "SEK": 2, "USD": 2,
+}
+
+// gramsPerUnit are the weight units the chaincode converts between
+var gramsPerUnit = map[string]float64{
+	"g":  1,
+	"kg": 1000,
+	"lb": 453.59237,
+}
+
+// Money is an amount in the minor units of an ISO 4217 currency
+type Money struct {
+	Amount   int    `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Quantity is a weight in a unit, kg, g or lb. Weights are kept to a thousandth of their unit.
+type Quantity struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// GetAssetWeight returns the weight of an asset in unit, e.g. lb for a client that reports in pounds.
+// An asset is weighed in the unit of its product catalog entry when it is created and keeps that unit.
+func (s *QueryContract) GetAssetWeight(ctx contractapi.TransactionContextInterface, assetID string, unit string) (*Quantity, error) {
+	asset, err := s.readAsset(ctx, assetID)
+	if err != nil {
+		return nil, err
+	}
+	converted, err := asset.Weight.In(unit)
+	if err != nil {
+		return nil, err
+	}
+	return &converted, nil
+}
+
+// newQuantity returns value in unit rounded to a thousandth of the unit
+func newQuantity(value float64, unit string) Quantity {
+	return Quantity{Value: math.Round(value*1000) / 1000, Unit: unit}
+}
+
+// parseQuantity reads a weight given as a number and a unit, e.g. "500 g", or as a bare number in defaultUnit
+func parseQuantity(value string, defaultUnit string) (Quantity, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Quantity{}, fmt.Errorf("weight %q must be a number and a unit, e.g. 5 kg", value)
+	}
+	number, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return Quantity{}, fmt.Errorf("weight %q is not a number", value)
+	}
+	quantity := Quantity{Value: number, Unit: defaultUnit}
+	if len(fields) == 2 {
+		quantity.Unit = fields[1]
+	}
+	err = validateWeightUnit(quantity.Unit)
+	if err != nil {
+		return Quantity{}, err
+	}
+	return quantity, nil
+}
+
+// validateCurrency checks that currency is an ISO 4217 code the chaincode accepts
+func validateCurrency(currency string) error {
+	if _, ok := currencyMinorUnits[currency]; !ok {
+		return fmt.Errorf("currency %q is not a supported ISO 4217 code", currency)
+	}
+	return nil
+}
+
+// validate checks that money has a supported currency and is not negative
+func (m Money) validate() error {
+	err := validateCurrency(m.Currency)
+	if err != nil {
+		return err
+	}
+	if m.Amount < 0 {
+		return fmt.Errorf("amount of money cannot be negative, got %d", m.Amount)
+	}
+	return nil
+}
+
+// Add returns the sum of two amounts of the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("mismatched currencies %s and %s", m.Currency, other.Currency)
+	}
+	if other.Amount > 0 && m.Amount > math.MaxInt-other.Amount {
+		return Money{}, fmt.Errorf("amount of %s overflows", m.Currency)
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Times returns the amount multiplied by factor, e.g. a unit price by a weight, rounded to the
+// nearest minor unit
+func (m Money) Times(factor float64) (Money, error) {
+	if factor < 0 || math.IsNaN(factor) {
+		return Money{}, fmt.Errorf("cannot multiply an amount by %v", factor)
+	}
+	amount := math.Round(float64(m.Amount) * factor)
+	if amount >= math.MaxInt {
+		return Money{}, fmt.Errorf("amount of %s overflows", m.Currency)
+	}
+	return Money{Amount: int(amount), Currency: m.Currency}, nil
+}
+
+// String formats the amount in major units, e.g. 16.50 EUR
+func (m Money) String() string {
+	digits := currencyMinorUnits[m.Currency]
+	if digits == 0 {
+		return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+	}
+	scale := int(math.Pow10(digits))
+	return fmt.Sprintf("%d.%0*d %s", m.Amount/scale, digits, m.Amount%scale, m.Currency)
+}
+
+// validateWeightUnit checks that unit is a weight unit the chaincode converts
+func validateWeightUnit(unit string) error {
+	if _, ok := gramsPerUnit[unit]; !ok {
+		return fmt.Errorf("weight unit %q is not supported, use one of kg, g or lb", unit)
+	}
+	return nil
+}
+
+// In converts the quantity to unit, rounded to a thousandth of the unit
+func (q Quantity) In(unit string) (Quantity, error) {
+	unit = strings.TrimSpace(unit)
+	err := validateWeightUnit(q.Unit)
+	if err != nil {
+		return Quantity{}, err
+	}
+	err = validateWeightUnit(unit)
+	if err != nil {
+		return Quantity{}, err
+	}
+	if unit == q.Unit {
+		return q, nil
+	}
+	return newQuantity(q.Value*gramsPerUnit[q.Unit]/gramsPerUnit[unit], unit), nil
+}
+
+// Plus returns the sum of two weights in the unit of q
+func (q Quantity) Plus(other Quantity) (Quantity, error) {
+	converted, err := other.In(q.Unit)
+	if err != nil {
+		return Quantity{}, err
+	}
+	return newQuantity(q.Value+converted.Value, q.Unit), nil
+}
+
+// Minus returns q less other in the unit of q, it fails when other is more than q
+func (q Quantity) Minus(other Quantity) (Quantity, error) {
+	converted, err := other.In(q.Unit)
+	if err != nil {
+		return Quantity{}, err
+	}
+	rest := newQuantity(q.Value-converted.Value, q.Unit)
+	if rest.Value < 0 {
+		return Quantity{}, fmt.Errorf("%v is more than %v", other, q)
+	}
+	return rest, nil
+}
+
+// Compare returns -1, 0 or 1 as q is less than, equal to or more than other, to a thousandth of the unit of q
+func (q Quantity) Compare(other Quantity) (int, error) {
+	difference, err := newQuantity(q.Value, q.Unit).Plus(Quantity{Value: -other.Value, Unit: other.Unit})
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case difference.Value < 0:
+		return -1, nil
+	case difference.Value > 0:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// String formats the quantity, e.g. 15 kg
+func (q Quantity) String() string {
+	return fmt.Sprintf("%g %s", q.Value, q.Unit)
+}
@@ -0,0 +1,150 @@
+package chaincode_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/stretchr/testify/require"
+
+	"phase2/chaincode"
+)
+
+func TestMoney(t *testing.T) {
+	sum, err := chaincode.Money{Amount: 1050, Currency: "EUR"}.Add(chaincode.Money{Amount: 600, Currency: "EUR"})
+	require.NoError(t, err)
+	require.Equal(t, "16.50 EUR", sum.String())
+
+	_, err = chaincode.Money{Amount: 1050, Currency: "EUR"}.Add(chaincode.Money{Amount: 600, Currency: "USD"})
+	require.EqualError(t, err, "mismatched currencies EUR and USD")
+
+	total, err := chaincode.Money{Amount: 110, Currency: "JPY"}.Times(15)
+	require.NoError(t, err)
+	require.Equal(t, "1650 JPY", total.String())
+
+	// the limits are those of int on the platform the peer runs on
+	_, err = chaincode.Money{Amount: math.MaxInt/2 + 1, Currency: "EUR"}.Times(2)
+	require.EqualError(t, err, "amount of EUR overflows")
+	_, err = chaincode.Money{Amount: math.MaxInt, Currency: "EUR"}.Add(chaincode.Money{Amount: 1, Currency: "EUR"})
+	require.EqualError(t, err, "amount of EUR overflows")
+}
+
+func TestQuantity(t *testing.T) {
+	for _, tc := range []struct {
+		from chaincode.Quantity
+		unit string
+		want chaincode.Quantity
+	}{
+		{chaincode.Quantity{Value: 15, Unit: "kg"}, "kg", chaincode.Quantity{Value: 15, Unit: "kg"}},
+		{chaincode.Quantity{Value: 15, Unit: "kg"}, "g", chaincode.Quantity{Value: 15000, Unit: "g"}},
+		{chaincode.Quantity{Value: 15, Unit: "kg"}, "lb", chaincode.Quantity{Value: 33.069, Unit: "lb"}},
+		{chaincode.Quantity{Value: 1, Unit: "lb"}, "g", chaincode.Quantity{Value: 453.592, Unit: "g"}},
+	} {
+		got, err := tc.from.In(tc.unit)
+		require.NoError(t, err, fmt.Sprintf("%v in %s", tc.from, tc.unit))
+		require.Equal(t, tc.want, got, fmt.Sprintf("%v in %s", tc.from, tc.unit))
+	}
+
+	_, err := chaincode.Quantity{Value: 15, Unit: "kg"}.In("stone")
+	require.Error(t, err)
+}
+
+func TestUnitsOnTheLedger(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+
+	run(t, stub, []step{
+		{"farmer creates lot", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"product unit must be supported", admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.PutProduct(ctx, `{"assetType":"cherries","colors":["red"],"minWeight":1,"maxWeight":10,"weightUnit":"crate","shelfLifeDays":3}`)
+		}, "weight unit \"crate\" is not supported"},
+		{"farmer asks in USD", farmer, usdPrice("100", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, ""},
+		{"retailer bids in USD", retailer, usdPrice("100", 15), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AgreeToBuy(ctx, "asset1")
+		}, ""},
+		{"retailer requests", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.RequestToBuy(ctx, "asset1")
+		}, ""},
+		{"retailer approves the farmer in USD", retailer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.Approve(ctx, "FarmerO", "Org1MSP", 1500, "USD")
+		}, ""},
+		{"retailer holds only EUR", farmer, transferTo(t, "asset1", retailer), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferRequestedAsset(ctx)
+		}, "insufficient funds"},
+	})
+
+	// the supermarket reads the weight of the lot in pounds
+	err := stub.Evaluate(supermarket, nil, func(ctx contractapi.TransactionContextInterface) error {
+		weight, err := sc.GetAssetWeight(ctx, "asset1", "lb")
+		require.Equal(t, &chaincode.Quantity{Value: 33.069, Unit: "lb"}, weight)
+		return err
+	})
+	require.NoError(t, err)
+}
+
+func usdPrice(value string, weight float64) map[string][]byte {
+	return map[string][]byte{"asset_price": []byte(fmt.Sprintf(`{"price":%s,"currency":"USD","quantity":{"value":%v,"unit":"kg"},"salt":"%s"}`, value, weight, testSalt))}
+}
+
+func TestUnitsArePersisted(t *testing.T) {
+	sc := contracts{}
+	stub := newLedger(t)
+	requestToBuy := func(ctx contractapi.TransactionContextInterface) error { return sc.RequestToBuy(ctx, "asset1") }
+
+	run(t, stub, []step{
+		{"farmer creates 15 kg", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset1", "red", 15, "apples")
+		}, ""},
+		{"apples are weighed in g from now on", admin, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.PutProduct(ctx, `{"assetType":"apples","colors":["red"],"minWeight":1000,"maxWeight":50000,"weightUnit":"g","shelfLifeDays":14}`)
+		}, ""},
+		{"farmer creates 15000 g", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.CreateAsset(ctx, "asset2", "red", 15000, "apples")
+		}, ""},
+		{"lots in different units", farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.MergeAssets(ctx, "asset3", []string{"asset1", "asset2"}, "red")
+		}, "weighed in g"},
+		{"terms in another unit than the asset", farmer, map[string][]byte{"asset_price": []byte(`{"price":1,"currency":"EUR","quantity":{"value":15000,"unit":"g"},"salt":"` + testSalt + `"}`)}, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, "terms are in g but asset asset1 is weighed in kg"},
+		{"offer in another unit than the asset", retailer, map[string][]byte{"asset_price": []byte(`{"price":1,"currency":"EUR","quantity":{"value":15000,"unit":"g"},"salt":"` + testSalt + `"}`)}, func(ctx contractapi.TransactionContextInterface) error {
+			return sc.OpenNegotiation(ctx, "asset1")
+		}, "terms are in g but asset asset1 is weighed in kg"},
+		{"quantity in an unknown unit", retailer, quantity(nil, "5 stone"), requestToBuy, "not supported"},
+		{"retailer requests 5.5 lb", retailer, quantity(nil, "5.5 lb"), requestToBuy, ""},
+	})
+
+	asset := readAsset(t, stub, "asset1")
+	require.Equal(t, kg(15), asset.Weight, "the asset keeps the unit it was created in")
+	require.Equal(t, chaincode.Quantity{Value: 15000, Unit: "g"}, readAsset(t, stub, "asset2").Weight)
+
+	err := stub.Evaluate(farmer, nil, func(ctx contractapi.TransactionContextInterface) error {
+		requests, err := sc.ListBuyRequests(ctx, "asset1")
+		require.Len(t, requests, 1)
+		require.Equal(t, &chaincode.Quantity{Value: 2.495, Unit: "kg"}, requests[0].Quantity)
+		return err
+	})
+	require.NoError(t, err)
+
+	// the price of a weight that is not whole is rounded to the cent
+	run(t, stub, []step{
+		{"farmer asks 10 per kg for 5.5 lb", farmer, price("10", 2.495), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.SetPrice(ctx, "asset1")
+		}, ""},
+		{"retailer bids 10 per kg for 5.5 lb", retailer, price("10", 2.495), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.AgreeToBuy(ctx, "asset1")
+		}, ""},
+		{"farmer sells 5.5 lb", farmer, transferTo(t, "asset1", retailer), func(ctx contractapi.TransactionContextInterface) error {
+			return sc.TransferRequestedAsset(ctx)
+		}, ""},
+	})
+	parent := readAsset(t, stub, "asset1")
+	require.Equal(t, kg(12.505), parent.Weight)
+	require.Equal(t, kg(2.495), readAsset(t, stub, parent.Children[0]).Weight)
+	require.Equal(t, 100000-25, balanceOf(t, stub, retailer))
+}
@@ -12,7 +12,7 @@ import (
 )
 
 func main() {
-	assetChaincode, err := contractapi.NewChaincode(&chaincode.SmartContract{})
+	assetChaincode, err := contractapi.NewChaincode(chaincode.NewAssetContract(), chaincode.NewMarketContract(), chaincode.NewQueryContract())
 	if err != nil {
 		log.Panicf("Error creating asset-transfer-private-data chaincode: %v", err)
 	}